@@ -0,0 +1,392 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"errors"
+	"reflect"
+
+	glua "github.com/yuin/gopher-lua"
+	gluar "layeh.com/gopher-luar"
+)
+
+// Classes registered with RegisterClass (or RegisterClassWithCtor) can be
+// extended by scripts:
+//
+//	local Boss = Mob:extend()
+//
+//	function Boss:init(name)
+//	  self.phase = 1
+//	end
+//
+//	function Boss:attack(target)
+//	  return "enraged " .. Boss.super.attack(self, target)
+//	end
+//
+//	local boss = Boss.new("Gruk")
+//
+// Instances of Lua classes are the Go value created by the Go class's
+// constructor (called with the arguments given to new) along with a table for
+// the fields scripts add. Methods are looked up in the Lua classes first, then
+// the Go type, and init is called once the instance is created if a class
+// defines it. Instances are converted to their Go value when passed to Go
+// functions, use ClassInstance to call their methods (overrides included) from
+// Go.
+
+// the field marking the metatables of instances of Lua classes
+const instanceMarker = "__luna_instance"
+
+// class is a class table known to the engine, either a registered Go class or
+// a Lua class extending one.
+type class struct {
+	table *glua.LTable
+
+	// for Go classes, the type and the constructor
+	goType reflect.Type
+	cons   glua.LValue
+
+	// for Lua classes, the class extended and the metatable of instances
+	super    *class
+	instance *glua.LTable
+}
+
+// the Go class at the root of the class's hierarchy
+func (c *class) root() *class {
+	for c.super != nil {
+		c = c.super
+	}
+
+	return c
+}
+
+// create the table for a Go class whose new function is cons and creates
+// values of the type.
+func (e *Engine) newGoClass(t reflect.Type, cons glua.LValue) *glua.LTable {
+	tbl := e.state.NewTable()
+	c := &class{table: tbl, goType: t, cons: cons}
+	e.registerClass(c)
+
+	tbl.RawSetString("new", cons)
+	meta := e.state.NewTable()
+	meta.RawSetString("__index", e.state.NewFunction(func(l *glua.LState) int {
+		key, ok := l.Get(2).(glua.LString)
+		if !ok || !e.hasMethod(c.goType, string(key)) {
+			return 0
+		}
+		l.Push(l.NewFunction(e.goMethod(string(key))))
+
+		return 1
+	}))
+	e.state.SetMetatable(tbl, meta)
+
+	return tbl
+}
+
+// remember the class, and give it an extend method
+func (e *Engine) registerClass(c *class) {
+	if e.classes == nil {
+		e.classes = make(map[*glua.LTable]*class)
+	}
+	e.classes[c.table] = c
+	c.table.RawSetString("extend", e.state.NewFunction(e.extendClass))
+}
+
+// Class:extend(), creating a Lua class extending the class
+func (e *Engine) extendClass(l *glua.LState) int {
+	super, ok := e.classes[l.CheckTable(1)]
+	if !ok {
+		l.ArgError(1, "expected a class")
+	}
+
+	tbl := l.NewTable()
+	c := &class{table: tbl, super: super}
+	e.registerClass(c)
+
+	tbl.RawSetString("super", super.table)
+	tbl.RawSetString("new", l.NewFunction(func(l *glua.LState) int {
+		l.Push(e.newInstance(c, l))
+
+		return 1
+	}))
+
+	meta := l.NewTable()
+	meta.RawSetString("__index", super.table)
+	l.SetMetatable(tbl, meta)
+
+	c.instance = l.NewTable()
+	c.instance.RawSetString(instanceMarker, glua.LTrue)
+	c.instance.RawSetString("__index", l.NewFunction(func(l *glua.LState) int {
+		return e.instanceIndex(c, l)
+	}))
+	c.instance.RawSetString("__newindex", l.NewFunction(e.instanceNewIndex))
+	c.instance.RawSetString("__tostring", l.NewFunction(func(l *glua.LState) int {
+		l.Push(glua.LString(l.ToStringMeta(e.unwrapInstance(l.Get(1))).String()))
+
+		return 1
+	}))
+	c.instance.RawSetString("__eq", l.NewFunction(func(l *glua.LState) int {
+		a, b := l.CheckUserData(1), l.CheckUserData(2)
+		l.Push(glua.LBool(a == b || sameValue(a.Value, b.Value)))
+
+		return 1
+	}))
+
+	l.Push(tbl)
+
+	return 1
+}
+
+// whether a and b are the same Go value, values of types that can't be
+// compared with == are only the same as themselves
+func sameValue(a, b interface{}) bool {
+	t := reflect.TypeOf(a)
+	if t == nil || t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+
+	return a == b
+}
+
+// Class.new(...), creating the Go value with the Go class's constructor and
+// calling init if a Lua class defines it
+func (e *Engine) newInstance(c *class, l *glua.LState) *glua.LUserData {
+	args := make([]glua.LValue, l.GetTop())
+	for i := range args {
+		args[i] = l.Get(i + 1)
+	}
+
+	l.Push(c.root().cons)
+	for _, arg := range args {
+		l.Push(arg)
+	}
+	l.Call(len(args), 1)
+	obj, ok := l.Get(-1).(*glua.LUserData)
+	l.Pop(1)
+	if !ok {
+		l.RaiseError("the constructor of the class did not return a Go value")
+	}
+
+	inst := l.NewUserData()
+	inst.Value = obj.Value
+	inst.Env = l.NewTable()
+	inst.Metatable = c.instance
+
+	if init, ok := e.luaClassField(c, "init").(*glua.LFunction); ok {
+		l.Push(init)
+		l.Push(inst)
+		for _, arg := range args {
+			l.Push(arg)
+		}
+		l.Call(len(args)+1, 0)
+	}
+
+	return inst
+}
+
+// the field defined by the Lua class or a Lua class it extends
+func (e *Engine) luaClassField(c *class, key string) glua.LValue {
+	for ; c.super != nil; c = c.super {
+		if val := c.table.RawGetString(key); val != glua.LNil {
+			return val
+		}
+	}
+
+	return glua.LNil
+}
+
+// __index of instances: the instance's own fields, then the classes (which
+// include the Go methods) and finally the fields of the Go value
+func (e *Engine) instanceIndex(c *class, l *glua.LState) int {
+	inst := l.CheckUserData(1)
+	key := l.Get(2)
+
+	if val := inst.Env.RawGet(key); val != glua.LNil {
+		l.Push(val)
+
+		return 1
+	}
+	if name, ok := key.(glua.LString); ok {
+		if val := l.GetField(c.table, string(name)); val != glua.LNil {
+			l.Push(val)
+
+			return 1
+		}
+	}
+
+	l.Push(l.GetTable(e.unwrapInstance(inst), key))
+
+	return 1
+}
+
+// __newindex of instances: fields of the Go value are assigned, others are
+// kept with the instance
+func (e *Engine) instanceNewIndex(l *glua.LState) int {
+	inst := l.CheckUserData(1)
+	key := l.Get(2)
+	val := l.Get(3)
+
+	if name, ok := key.(glua.LString); ok && e.hasField(reflect.TypeOf(inst.Value), string(name)) {
+		l.SetTable(e.unwrapInstance(inst), key, val)
+
+		return 0
+	}
+	inst.Env.RawSet(key, val)
+
+	return 0
+}
+
+// the gopher-luar userdata for the value if it's an instance of a Lua class
+func (e *Engine) unwrapInstance(val glua.LValue) glua.LValue {
+	ud, ok := val.(*glua.LUserData)
+	if !ok {
+		return val
+	}
+	mt, ok := ud.Metatable.(*glua.LTable)
+	if !ok || mt.RawGetString(instanceMarker) != glua.LTrue {
+		return val
+	}
+
+	return gluar.New(e.state, ud.Value)
+}
+
+// a function calling the Go method on the instance it's given
+func (e *Engine) goMethod(name string) glua.LGFunction {
+	return func(l *glua.LState) int {
+		top := l.GetTop()
+		if top == 0 {
+			l.ArgError(1, "expected an instance, use ':' to call methods")
+		}
+
+		self := e.unwrapInstance(l.Get(1))
+		l.Push(l.GetField(self, name))
+		l.Push(self)
+		for i := 2; i <= top; i++ {
+			l.Push(l.Get(i))
+		}
+		l.Call(top, glua.MultRet)
+
+		return l.GetTop() - top
+	}
+}
+
+// the metatables gopher-luar uses for the type and, for structs, pointers to
+// it (which have the methods of both)
+func (e *Engine) luarMetatables(t reflect.Type) []*glua.LTable {
+	if t == nil {
+		return nil
+	}
+
+	types := []reflect.Type{t}
+	switch {
+	case t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct:
+		types = append(types, t.Elem())
+	case t.Kind() == reflect.Struct:
+		types = append(types, reflect.PtrTo(t))
+	}
+
+	mts := make([]*glua.LTable, 0, len(types))
+	for _, typ := range types {
+		if mt := gluar.MT(e.state, reflect.Zero(typ).Interface()); mt != nil {
+			mts = append(mts, mt.LTable)
+		}
+	}
+
+	return mts
+}
+
+// determines if the type has a method with the Lua name
+func (e *Engine) hasMethod(t reflect.Type, name string) bool {
+	for _, mt := range e.luarMetatables(t) {
+		if methods, ok := mt.RawGetString("methods").(*glua.LTable); ok && methods.RawGetString(name) != glua.LNil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// determines if the type has a field with the Lua name
+func (e *Engine) hasField(t reflect.Type, name string) bool {
+	for _, mt := range e.luarMetatables(t) {
+		if fields, ok := mt.RawGetString("fields").(*glua.LTable); ok && fields.RawGetString(name) != glua.LNil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClassInstance lets Go call the methods of an instance of a class, including
+// the methods a Lua class overrides, so Go code can use instances of Lua
+// classes through its own interfaces. For example:
+//
+//	type scriptedMob struct {
+//		*luna.ClassInstance
+//	}
+//
+//	func (m scriptedMob) Attack(target string) string {
+//		vals, err := m.CallMethod("attack", 1, target)
+//		if err != nil || len(vals) == 0 {
+//			return ""
+//		}
+//
+//		return vals[0].AsString()
+//	}
+type ClassInstance struct {
+	value *Value
+}
+
+// NewClassInstance wraps an instance of a class (or any Go value passed to
+// the engine), returning an error if the value isn't one.
+func NewClassInstance(val *Value) (*ClassInstance, error) {
+	if val == nil || !val.IsUserData() {
+		return nil, errors.New("value is not an instance of a class")
+	}
+
+	return &ClassInstance{value: val}, nil
+}
+
+// Value returns the instance as a Lua value.
+func (ci *ClassInstance) Value() *Value {
+	return ci.value
+}
+
+// Object returns the Go value of the instance, as created by the constructor
+// of the Go class.
+func (ci *ClassInstance) Object() interface{} {
+	return ci.value.asUserData().Value
+}
+
+// CallMethod calls the method with the instance as self, returning retCount
+// results. Methods are looked up like they are in Lua so overrides from Lua
+// classes are called.
+func (ci *ClassInstance) CallMethod(name string, retCount int, args ...interface{}) ([]*Value, error) {
+	eng := ci.value.owner
+	l := eng.state
+
+	fn := l.GetField(ci.value.lval, name)
+	if fn.Type() != glua.LTFunction {
+		return nil, errors.New("instance has no method " + name)
+	}
+
+	largs := make([]glua.LValue, len(args)+1)
+	largs[0] = ci.value.lval
+	for i, arg := range args {
+		largs[i+1] = eng.ValueFor(arg).lval
+	}
+
+	err := eng.callLua(func() error {
+		return l.CallByParam(glua.P{Fn: fn, NRet: retCount, Protect: true}, largs...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make([]*Value, retCount)
+	for i := retCount - 1; i >= 0; i-- {
+		vals[i] = eng.newValue(l.Get(-1))
+		l.Pop(1)
+	}
+
+	return vals, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+type classMob struct {
+	Name      string
+	HitPoints int
+}
+
+func newClassMob(name string) *classMob {
+	return &classMob{Name: name, HitPoints: 10}
+}
+
+func (m *classMob) Attack(target string) string {
+	return m.Name + " attacks " + target
+}
+
+type classBag struct {
+	Items []string
+}
+
+func newClassBag() classBag {
+	return classBag{Items: []string{"rope"}}
+}
+
+var _ = Describe("Classes", func() {
+	var eng *Engine
+
+	BeforeEach(func() {
+		eng = NewEngine()
+		eng.RegisterClassWithCtor("Mob", classMob{}, newClassMob)
+		Ω(eng.DoString(`
+			Boss = Mob:extend()
+
+			function Boss:init(name)
+				self.phase = 1
+			end
+
+			function Boss:attack(target)
+				return "enraged " .. Boss.super.attack(self, target)
+			end
+		`)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		eng.Close()
+	})
+
+	It("still creates Go values with new", func() {
+		Ω(eng.DoString(`result = Mob.new("orc"):attack("bob")`)).Should(Succeed())
+		Ω(eng.GetGlobal("result").AsString()).Should(Equal("orc attacks bob"))
+	})
+
+	It("overrides methods and calls the method of the class extended", func() {
+		Ω(eng.DoString(`result = Boss.new("Gruk"):attack("bob")`)).Should(Succeed())
+		Ω(eng.GetGlobal("result").AsString()).Should(Equal("enraged Gruk attacks bob"))
+	})
+
+	It("calls init and keeps the fields scripts add", func() {
+		Ω(eng.DoString(`
+			local boss = Boss.new("Gruk")
+			boss.phase = boss.phase + 1
+			boss.hit_points = 50
+			result = boss.phase .. "/" .. boss.hit_points .. "/" .. boss.name
+		`)).Should(Succeed())
+		Ω(eng.GetGlobal("result").AsString()).Should(Equal("2/50/Gruk"))
+	})
+
+	It("extends Lua classes", func() {
+		Ω(eng.DoString(`
+			Dragon = Boss:extend()
+
+			function Dragon:attack(target)
+				return "fiery " .. Dragon.super.attack(self, target)
+			end
+
+			local dragon = Dragon.new("Smaug")
+			result = dragon:attack("bob") .. "/" .. dragon.phase
+		`)).Should(Succeed())
+		Ω(eng.GetGlobal("result").AsString()).Should(Equal("fiery enraged Smaug attacks bob/1"))
+	})
+
+	It("passes the Go value to Go functions", func() {
+		var got *classMob
+		eng.SetGlobal("inspect", func(m *classMob) {
+			got = m
+		})
+
+		Ω(eng.DoString(`inspect(Boss.new("Gruk"))`)).Should(Succeed())
+		Ω(got).ShouldNot(BeNil())
+		Ω(got.Name).Should(Equal("Gruk"))
+	})
+
+	It("compares instances of Go values that can't be compared with ==", func() {
+		eng.RegisterClassWithCtor("Bag", classBag{}, newClassBag)
+		Ω(eng.DoString(`
+			Satchel = Bag:extend()
+
+			local satchel = Satchel.new()
+			result = tostring(satchel == satchel) .. "/" .. tostring(satchel == Satchel.new())
+		`)).Should(Succeed())
+		Ω(eng.GetGlobal("result").AsString()).Should(Equal("true/false"))
+	})
+
+	Describe("ClassInstance", func() {
+		It("calls methods overridden by Lua classes", func() {
+			Ω(eng.DoString(`boss = Boss.new("Gruk")`)).Should(Succeed())
+
+			inst, err := NewClassInstance(eng.GetGlobal("boss"))
+			Ω(err).Should(BeNil())
+			Ω(inst.Object().(*classMob).Name).Should(Equal("Gruk"))
+
+			vals, err := inst.CallMethod("attack", 1, "bob")
+			Ω(err).Should(BeNil())
+			Ω(vals).Should(HaveLen(1))
+			Ω(vals[0].AsString()).Should(Equal("enraged Gruk attacks bob"))
+
+			_, err = inst.CallMethod("missing", 0)
+			Ω(err).ShouldNot(BeNil())
+		})
+
+		It("rejects values that aren't instances", func() {
+			_, err := NewClassInstance(eng.ValueFor(10))
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,15 @@
+// Copyright (c) 2020 Brandon Buck
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestLunaCommand(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Luna Command Suite")
+}
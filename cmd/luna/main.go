@@ -0,0 +1,233 @@
+// Copyright (c) 2020 Brandon Buck
+
+// Command luna runs Lua scripts with a luna engine, or starts a REPL when no
+// scripts or expressions are given.
+//
+// Usage:
+//
+//	luna [flags] [script [args...]]
+//	luna test [flags] [dir]
+//
+// Scripts receive their arguments through the global arg table and as the
+// varargs of the chunk. Calling os.exit ends the program with the given exit
+// code.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bbuck/luna"
+)
+
+// the default search path for require, relative to the working directory
+const defaultPath = "./?.lua;./?/init.lua"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// expressions is a flag.Value collecting each -e flag given
+type expressions []string
+
+func (e *expressions) String() string {
+	return strings.Join(*e, "; ")
+}
+
+func (e *expressions) Set(expr string) error {
+	*e = append(*e, expr)
+
+	return nil
+}
+
+// run the command with the given arguments, returning the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "test" {
+		return runTests(args[1:], stdout, stderr)
+	}
+
+	flags := flag.NewFlagSet("luna", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: luna [flags] [script [args...]]")
+		flags.PrintDefaults()
+	}
+
+	var exprs expressions
+	flags.Var(&exprs, "e", "execute the Lua `code`, may be given more than once")
+	interactive := flags.Bool("i", false, "start the REPL after running scripts and expressions")
+	sandbox := flags.String("sandbox", "safe", "the libraries scripts can use: strict, safe or none")
+	fields := flags.String("fields", "snake", "naming convention for Go fields: snake, camel, pascal, snake+pascal, kebab or screaming-snake")
+	methods := flags.String("methods", "snake", "naming convention for Go methods: snake, camel, pascal, snake+pascal, kebab or screaming-snake")
+	path := flags.String("path", defaultPath, "semicolon separated `patterns` require searches, ? is replaced with the module name")
+	name := flags.String("name", "luna", "the name shown in the REPL prompt")
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+
+		return 2
+	}
+
+	options, err := engineOptions(*fields, *methods)
+	if err != nil {
+		fmt.Fprintf(stderr, "luna: %s\n", err)
+
+		return 2
+	}
+
+	level, ok := sandboxLevels[*sandbox]
+	if !ok {
+		fmt.Fprintf(stderr, "luna: unknown sandbox level %q\n", *sandbox)
+
+		return 2
+	}
+
+	eng := luna.NewEngineWithOptions(options)
+	defer eng.Close()
+
+	level(eng)
+	eng.SecureRequire(strings.Split(*path, ";"))
+
+	status := new(exitStatus)
+	status.install(eng)
+
+	for _, expr := range exprs {
+		if code, stop := status.check(eng.DoString(expr), stderr); stop {
+			return code
+		}
+	}
+
+	scriptArgs := flags.Args()
+	if len(scriptArgs) > 0 {
+		err := runScript(eng, scriptArgs[0], scriptArgs[1:])
+		if code, stop := status.check(err, stderr); stop {
+			return code
+		}
+	}
+
+	if (len(exprs) == 0 && len(scriptArgs) == 0) || *interactive {
+		repl := luna.NewREPLWithConfig(luna.REPLConfig{
+			Engine: eng,
+			Name:   *name,
+			Output: stdout,
+		})
+		defer repl.Close()
+		status.repl = repl
+
+		if err := repl.Run(); err != nil {
+			fmt.Fprintf(stderr, "luna: %s\n", err)
+
+			return 1
+		}
+		if status.requested {
+			return status.code
+		}
+	}
+
+	return 0
+}
+
+// load and execute the script, passing it the arguments
+func runScript(eng *luna.Engine, script string, args []string) error {
+	argTable := eng.NewTable()
+	argTable.RawSetInt(0, script)
+	callArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		argTable.RawSetInt(i+1, arg)
+		callArgs[i] = arg
+	}
+	eng.SetGlobal("arg", argTable)
+
+	fn, err := eng.LoadFile(script)
+	if err != nil {
+		return err
+	}
+
+	_, err = fn.Call(0, callArgs...)
+
+	return err
+}
+
+// build engine options from the naming convention flags
+func engineOptions(fields, methods string) (luna.EngineOptions, error) {
+	fieldCasing, ok := luna.LookupNamingConvention(fields)
+	if !ok {
+		return luna.EngineOptions{}, fmt.Errorf("unknown naming convention %q for fields", fields)
+	}
+
+	methodCasing, ok := luna.LookupNamingConvention(methods)
+	if !ok {
+		return luna.EngineOptions{}, fmt.Errorf("unknown naming convention %q for methods", methods)
+	}
+
+	return luna.EngineOptions{
+		FieldCasing:  fieldCasing,
+		MethodCasing: methodCasing,
+	}, nil
+}
+
+// exitStatus tracks calls to os.exit
+type exitStatus struct {
+	requested bool
+	code      int
+
+	// repl is ended by os.exit once it's running, so it's closed (restoring
+	// the terminal) before the program exits.
+	repl *luna.REPL
+}
+
+// install replaces os.exit (creating the os table if necessary) with a
+// function that records the exit code and raises an error to stop the script.
+func (es *exitStatus) install(eng *luna.Engine) {
+	osTable := eng.GetGlobal("os")
+	if !osTable.IsTable() {
+		osTable = eng.NewTable()
+		eng.SetGlobal("os", osTable)
+	}
+
+	eng.SetField(osTable, "exit", func(eng *luna.Engine) int {
+		code := 0
+		if eng.StackSize() > 0 {
+			val := eng.Get(1)
+			switch {
+			case val.IsBool():
+				if val.IsFalse() {
+					code = 1
+				}
+			case val.IsNumber():
+				code = int(val.AsNumber())
+			}
+		}
+
+		es.requested = true
+		es.code = code
+		if es.repl != nil {
+			es.repl.Exit()
+		}
+		eng.RaiseError("exit")
+
+		return 0
+	})
+}
+
+// check the outcome of running a script, determining if the program should
+// stop and with which exit code. If the script called os.exit its code is used
+// even if the error was caught by the script, otherwise errors are reported.
+func (es *exitStatus) check(err error, stderr io.Writer) (int, bool) {
+	if es.requested {
+		return es.code, true
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "luna: %s\n", err)
+
+		return 1, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2020 Brandon Buck
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("luna", func() {
+	var (
+		dir            string
+		stdout, stderr *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "luna-cmd")
+		Ω(err).Should(BeNil())
+
+		stdout, stderr = new(bytes.Buffer), new(bytes.Buffer)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		Ω(ioutil.WriteFile(path, []byte(src), 0644)).Should(Succeed())
+
+		return path
+	}
+
+	luna := func(args ...string) int {
+		return run(args, stdout, stderr)
+	}
+
+	Describe("running code", func() {
+		It("exits with the code given to os.exit", func() {
+			Ω(luna("-e", "os.exit(3)")).Should(Equal(3))
+			Ω(luna("-e", "os.exit(false)")).Should(Equal(1))
+			Ω(luna("-e", "os.exit(true)")).Should(Equal(0))
+		})
+
+		It("exits with the code given to os.exit even when the error is caught", func() {
+			Ω(luna("-e", "pcall(os.exit, 4)", "-e", "os.exit(5)")).Should(Equal(4))
+		})
+
+		It("runs expressions in order", func() {
+			Ω(luna("-e", "x = 6", "-e", "os.exit(x + 1)")).Should(Equal(7))
+		})
+
+		It("reports errors and exits with 1", func() {
+			Ω(luna("-e", "error('boom')")).Should(Equal(1))
+			Ω(stderr.String()).Should(ContainSubstring("boom"))
+		})
+
+		It("passes arguments to scripts", func() {
+			script := write("args.lua", `
+				local first, second = ...
+				if arg[1] == "a" and arg[2] == "b" and first == "a" and second == "b" then
+					os.exit(9)
+				end
+			`)
+
+			Ω(luna(script, "a", "b")).Should(Equal(9))
+		})
+
+		It("requires modules from the path", func() {
+			write("answer.lua", `return 42`)
+
+			Ω(luna("-path", filepath.Join(dir, "?.lua"), "-e", "os.exit(require('answer') - 40)")).Should(Equal(2))
+		})
+	})
+
+	Describe("flags", func() {
+		It("limits the libraries with the sandbox level", func() {
+			Ω(luna("-sandbox", "safe", "-e", "os.exit(io == nil and 0 or 1)")).Should(Equal(0))
+			Ω(luna("-sandbox", "none", "-e", "os.exit(io ~= nil and 0 or 1)")).Should(Equal(0))
+		})
+
+		It("names Go members with the naming conventions", func() {
+			Ω(luna("-fields", "camel", "-methods", "pascal", "-e", "os.exit(0)")).Should(Equal(0))
+		})
+
+		It("rejects invalid flags", func() {
+			Ω(luna("-nope")).Should(Equal(2))
+			Ω(luna("-sandbox", "open")).Should(Equal(2))
+			Ω(stderr.String()).Should(ContainSubstring(`unknown sandbox level "open"`))
+			Ω(luna("-fields", "shouting")).Should(Equal(2))
+			Ω(stderr.String()).Should(ContainSubstring(`unknown naming convention "shouting"`))
+		})
+
+		It("prints usage for -h", func() {
+			Ω(luna("-h")).Should(Equal(0))
+			Ω(stderr.String()).Should(ContainSubstring("usage: luna"))
+		})
+	})
+
+	Describe("test", func() {
+		It("exits with 0 when the tests pass and 1 when they fail", func() {
+			write("math_test.lua", `
+				describe("math", function()
+					it("adds", function()
+						expect(1 + 1).to_equal(2)
+					end)
+				end)
+			`)
+			Ω(luna("test", dir)).Should(Equal(0))
+
+			write("broken_test.lua", `
+				it("fails", function()
+					expect(1).to_equal(2)
+				end)
+			`)
+			Ω(luna("test", "-format", "tap", dir)).Should(Equal(1))
+			Ω(stdout.String()).Should(ContainSubstring("not ok"))
+		})
+	})
+})
@@ -0,0 +1,46 @@
+// Copyright (c) 2020 Brandon Buck
+
+package main
+
+import (
+	"github.com/bbuck/luna"
+)
+
+// sandboxLevels configure the libraries available to scripts, keyed by the
+// value of the -sandbox flag.
+var sandboxLevels = map[string]func(*luna.Engine){
+	// strict only provides the libraries every engine starts with, without
+	// the ability to load files outside of require.
+	"strict": func(eng *luna.Engine) {
+		removeGlobals(eng, "dofile", "loadfile")
+	},
+
+	// safe adds the libraries that can't reach outside of the process, the
+	// os library is limited to its time functions.
+	"safe": func(eng *luna.Engine) {
+		removeGlobals(eng, "dofile", "loadfile")
+		eng.OpenMath()
+		eng.OpenCoroutine()
+		eng.OpenOS()
+
+		osTable := eng.GetGlobal("os")
+		safe := eng.NewTable()
+		for _, name := range []string{"clock", "date", "difftime", "time"} {
+			safe.RawSet(name, osTable.RawGet(name))
+		}
+		eng.SetGlobal("os", safe)
+	},
+
+	// none opens every library.
+	"none": func(eng *luna.Engine) {
+		eng.OpenLibs()
+	},
+}
+
+// remove the named global values from the engine
+func removeGlobals(eng *luna.Engine, names ...string) {
+	globals := eng.GetGlobals()
+	for _, name := range names {
+		globals.RawSet(name, eng.Nil())
+	}
+}
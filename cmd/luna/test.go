@@ -0,0 +1,179 @@
+// Copyright (c) 2020 Brandon Buck
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bbuck/luna"
+)
+
+// run the `luna test` subcommand, returning the exit code
+func runTests(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("luna test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: luna test [flags] [dir]")
+		flags.PrintDefaults()
+	}
+
+	pattern := flags.String("pattern", luna.DefaultLuaTestPattern, "the test files to run, matched against file names")
+	format := flags.String("format", "text", "the report format: text, tap or junit")
+	output := flags.String("o", "", "write the report to `file` instead of stdout")
+	sandbox := flags.String("sandbox", "safe", "the libraries tests can use: strict, safe or none")
+	fields := flags.String("fields", "snake", "naming convention for Go fields: snake, camel, pascal, snake+pascal, kebab or screaming-snake")
+	methods := flags.String("methods", "snake", "naming convention for Go methods: snake, camel, pascal, snake+pascal, kebab or screaming-snake")
+	coverProfile := flags.String("coverprofile", "", "write an LCOV coverage report of the modules tests require to `file`")
+	coverHTML := flags.String("coverhtml", "", "write an HTML coverage report of the modules tests require to `file`")
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+
+		return 2
+	}
+
+	options, err := engineOptions(*fields, *methods)
+	if err != nil {
+		fmt.Fprintf(stderr, "luna: %s\n", err)
+
+		return 2
+	}
+
+	level, ok := sandboxLevels[*sandbox]
+	if !ok {
+		fmt.Fprintf(stderr, "luna: unknown sandbox level %q\n", *sandbox)
+
+		return 2
+	}
+
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	var coverage *luna.Coverage
+	if len(*coverProfile) > 0 || len(*coverHTML) > 0 {
+		coverage = luna.NewCoverage()
+	}
+
+	report, err := luna.RunLuaTestsWithConfig(os.DirFS(dir), *pattern, luna.LuaTestConfig{
+		Options: &options,
+		Setup: func(eng *luna.Engine) error {
+			level(eng)
+
+			return nil
+		},
+		Coverage: coverage,
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "luna: %s\n", err)
+
+		return 1
+	}
+
+	if len(*coverProfile) > 0 {
+		if err := writeFile(*coverProfile, coverage.WriteLCOV); err != nil {
+			fmt.Fprintf(stderr, "luna: %s\n", err)
+
+			return 1
+		}
+	}
+	if len(*coverHTML) > 0 {
+		if err := writeFile(*coverHTML, coverage.WriteHTML); err != nil {
+			fmt.Fprintf(stderr, "luna: %s\n", err)
+
+			return 1
+		}
+	}
+
+	out := stdout
+	if len(*output) > 0 {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(stderr, "luna: %s\n", err)
+
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	switch *format {
+	case "tap":
+		err = report.WriteTAP(out)
+	case "junit":
+		err = report.WriteJUnit(out)
+	case "text":
+		err = writeTestSummary(out, report, coverage)
+	default:
+		fmt.Fprintf(stderr, "luna: unknown report format %q\n", *format)
+
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "luna: %s\n", err)
+
+		return 1
+	}
+
+	if !report.OK() {
+		return 1
+	}
+
+	return 0
+}
+
+// create the file and write to it with the given function
+func writeFile(fpath string, write func(io.Writer) error) error {
+	file, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
+
+// write a human readable summary of the report, listing each failure and the
+// line coverage when it was recorded.
+func writeTestSummary(w io.Writer, report *luna.LuaTestReport, coverage *luna.Coverage) error {
+	for _, result := range report.Results() {
+		if result.Status != luna.LuaTestFailed {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "FAIL %s: %s\n    %s\n", result.File, result.FullName(), result.Message); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d passed, %d failed, %d skipped in %d file(s) (%s)\n",
+		report.Count(luna.LuaTestPassed),
+		report.Count(luna.LuaTestFailed),
+		report.Count(luna.LuaTestSkipped),
+		len(report.Files),
+		report.Duration,
+	)
+	if err != nil || coverage == nil {
+		return err
+	}
+
+	hit, found := coverage.LinesHit()
+	percent := 100.0
+	if found > 0 {
+		percent = float64(hit) * 100 / float64(found)
+	}
+	_, err = fmt.Fprintf(w, "coverage: %.1f%% of lines (%d/%d)\n", percent, hit, found)
+
+	return err
+}
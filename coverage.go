@@ -0,0 +1,308 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FunctionID identifies a function within a file by its name and the line it
+// was defined on.
+type FunctionID struct {
+	Name string
+	Line int
+}
+
+// BranchID identifies one outcome of an if statement. Block numbers the if
+// statements of a file, and Branch is 0 when the condition held and 1 when it
+// didn't (the else or elseif part, whether or not one was written).
+type BranchID struct {
+	Line   int
+	Block  int
+	Branch int
+}
+
+// FileCoverage holds the coverage recorded for a single chunk, every line,
+// function and branch that can be executed is present with the number of
+// times it was executed (which may be zero).
+type FileCoverage struct {
+	// Name is the chunk name, the path for files loaded from disk.
+	Name string
+
+	// Source is the code the chunk was compiled from.
+	Source []byte
+
+	// Lines maps line numbers with statements to their hit counts.
+	Lines map[int]int
+
+	// Functions maps each function defined in the chunk to the number of
+	// times it was called.
+	Functions map[FunctionID]int
+
+	// Branches maps each branch to the number of times it was taken.
+	Branches map[BranchID]int
+}
+
+// LinesHit returns the number of lines executed at least once and the number
+// of lines that could be executed.
+func (f *FileCoverage) LinesHit() (hit, found int) {
+	for _, count := range f.Lines {
+		if count > 0 {
+			hit++
+		}
+	}
+
+	return hit, len(f.Lines)
+}
+
+// FunctionsHit returns the number of functions called at least once and the
+// number of functions defined.
+func (f *FileCoverage) FunctionsHit() (hit, found int) {
+	for _, count := range f.Functions {
+		if count > 0 {
+			hit++
+		}
+	}
+
+	return hit, len(f.Functions)
+}
+
+// BranchesHit returns the number of branches taken at least once and the
+// number of branches.
+func (f *FileCoverage) BranchesHit() (hit, found int) {
+	for _, count := range f.Branches {
+		if count > 0 {
+			hit++
+		}
+	}
+
+	return hit, len(f.Branches)
+}
+
+// copy the coverage so it can be used without holding the lock
+func (f *FileCoverage) copy() *FileCoverage {
+	dup := newFileCoverage(f.Name, f.Source)
+	dup.merge(f)
+
+	return dup
+}
+
+// add the counts from other to the coverage
+func (f *FileCoverage) merge(other *FileCoverage) {
+	if len(f.Source) == 0 {
+		f.Source = other.Source
+	}
+	for line, count := range other.Lines {
+		f.Lines[line] += count
+	}
+	for id, count := range other.Functions {
+		f.Functions[id] += count
+	}
+	for id, count := range other.Branches {
+		f.Branches[id] += count
+	}
+}
+
+// set every count to zero
+func (f *FileCoverage) reset() {
+	for line := range f.Lines {
+		f.Lines[line] = 0
+	}
+	for id := range f.Functions {
+		f.Functions[id] = 0
+	}
+	for id := range f.Branches {
+		f.Branches[id] = 0
+	}
+}
+
+func newFileCoverage(name string, source []byte) *FileCoverage {
+	return &FileCoverage{
+		Name:      name,
+		Source:    source,
+		Lines:     make(map[int]int),
+		Functions: make(map[FunctionID]int),
+		Branches:  make(map[BranchID]int),
+	}
+}
+
+// Coverage collects the lines, functions and branches executed by the chunks
+// loaded into engines with coverage enabled. It's safe to share one Coverage
+// between any number of engines (such as every engine in a pool) and the
+// results from each are combined by chunk name.
+type Coverage struct {
+	mutex sync.Mutex
+	files map[string]*FileCoverage
+}
+
+// NewCoverage creates an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		files: make(map[string]*FileCoverage),
+	}
+}
+
+// Files returns a copy of the coverage of each file, ordered by name.
+func (c *Coverage) Files() []*FileCoverage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	files := make([]*FileCoverage, 0, len(c.files))
+	for _, file := range c.files {
+		files = append(files, file.copy())
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	return files
+}
+
+// File returns a copy of the coverage for the named chunk, or nil if nothing
+// was recorded for it.
+func (c *Coverage) File(name string) *FileCoverage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if file, ok := c.files[name]; ok {
+		return file.copy()
+	}
+
+	return nil
+}
+
+// LinesHit returns the number of lines executed at least once and the number
+// of lines that could be executed across every file.
+func (c *Coverage) LinesHit() (hit, found int) {
+	for _, file := range c.Files() {
+		fileHit, fileFound := file.LinesHit()
+		hit += fileHit
+		found += fileFound
+	}
+
+	return hit, found
+}
+
+// Merge adds the coverage recorded by other to this coverage, for combining
+// results collected separately such as from different processes.
+func (c *Coverage) Merge(other *Coverage) {
+	if other == c {
+		return
+	}
+
+	files := other.Files()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, file := range files {
+		c.file(file.Name, file.Source).merge(file)
+	}
+}
+
+// Reset sets every recorded count back to zero. The files stay known, with
+// their lines, functions and branches, as engines keep recording into them for
+// chunks that are already loaded.
+func (c *Coverage) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, file := range c.files {
+		file.reset()
+	}
+}
+
+// fetch or create the coverage for the named file, the lock must be held
+func (c *Coverage) file(name string, source []byte) *FileCoverage {
+	file, ok := c.files[name]
+	if !ok {
+		file = newFileCoverage(name, source)
+		c.files[name] = file
+	}
+
+	return file
+}
+
+// coverageRecorder is the hook listener recording an engine's coverage.
+type coverageRecorder struct {
+	coverage *Coverage
+	files    map[int]*FileCoverage
+}
+
+// register the lines, functions and branches of the chunk with zero hits.
+// Chunks with names like "<string>" have no file to report against and are
+// ignored.
+func (cr *coverageRecorder) chunkInstrumented(chunk *instrumentedChunk) {
+	if strings.HasPrefix(chunk.name, "<") {
+		return
+	}
+
+	cr.coverage.mutex.Lock()
+	defer cr.coverage.mutex.Unlock()
+
+	file := cr.coverage.file(chunk.name, chunk.source)
+	for _, line := range chunk.lines {
+		file.Lines[line] += 0
+	}
+	// the first function is the main chunk, which isn't reported
+	for _, fn := range chunk.functions[1:] {
+		file.Functions[FunctionID{Name: fn.name, Line: fn.line}] += 0
+	}
+	for _, branch := range chunk.branches {
+		file.Branches[BranchID{Line: branch.line, Block: branch.block, Branch: branch.branch}] += 0
+	}
+
+	cr.files[chunk.id] = file
+}
+
+func (cr *coverageRecorder) chunkReleased(id int) {
+	delete(cr.files, id)
+}
+
+func (cr *coverageRecorder) hook(_ *Engine, ev *hookEvent) {
+	file, ok := cr.files[ev.chunk.id]
+	if !ok {
+		return
+	}
+
+	cr.coverage.mutex.Lock()
+	defer cr.coverage.mutex.Unlock()
+
+	switch ev.kind {
+	case hookLine:
+		file.Lines[ev.index]++
+	case hookCall:
+		if ev.index > 0 {
+			fn := ev.chunk.functions[ev.index]
+			file.Functions[FunctionID{Name: fn.name, Line: fn.line}]++
+		}
+	case hookBranch:
+		branch := ev.chunk.branches[ev.index]
+		file.Branches[BranchID{Line: branch.line, Block: branch.block, Branch: branch.branch}]++
+	}
+}
+
+// EnableCoverage records the coverage of chunks loaded into the engine from
+// now on into c, chunks that were loaded before coverage was enabled aren't
+// recorded. Coverage is gathered by instrumenting the chunks as they're
+// compiled, so scripts run slower while it's enabled.
+func (e *Engine) EnableCoverage(c *Coverage) {
+	e.DisableCoverage()
+
+	e.coverage = &coverageRecorder{
+		coverage: c,
+		files:    make(map[int]*FileCoverage),
+	}
+	e.addHookListener(e.coverage)
+}
+
+// DisableCoverage stops recording coverage, chunks loaded afterwards are no
+// longer instrumented.
+func (e *Engine) DisableCoverage() {
+	if e.coverage != nil {
+		e.removeHookListener(e.coverage)
+		e.coverage = nil
+	}
+}
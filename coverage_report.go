@@ -0,0 +1,267 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// WriteLCOV writes the coverage in the LCOV tracefile format read by genhtml
+// and most coverage services.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	for _, file := range c.Files() {
+		fmt.Fprintln(out, "TN:")
+		fmt.Fprintf(out, "SF:%s\n", file.Name)
+
+		functions := sortedFunctions(file)
+		names := lcovFunctionNames(functions)
+		for i, fn := range functions {
+			fmt.Fprintf(out, "FN:%d,%s\n", fn.Line, names[i])
+		}
+		for i, fn := range functions {
+			fmt.Fprintf(out, "FNDA:%d,%s\n", file.Functions[fn], names[i])
+		}
+		hit, found := file.FunctionsHit()
+		fmt.Fprintf(out, "FNF:%d\nFNH:%d\n", found, hit)
+
+		branches := sortedBranches(file)
+		for _, branch := range branches {
+			taken := "-"
+			if blockEvaluated(file, branch.Block) {
+				taken = strconv.Itoa(file.Branches[branch])
+			}
+			fmt.Fprintf(out, "BRDA:%d,%d,%d,%s\n", branch.Line, branch.Block, branch.Branch, taken)
+		}
+		hit, found = file.BranchesHit()
+		fmt.Fprintf(out, "BRF:%d\nBRH:%d\n", found, hit)
+
+		for _, line := range sortedLines(file) {
+			fmt.Fprintf(out, "DA:%d,%d\n", line, file.Lines[line])
+		}
+		hit, found = file.LinesHit()
+		fmt.Fprintf(out, "LF:%d\nLH:%d\n", found, hit)
+		fmt.Fprintln(out, "end_of_record")
+	}
+
+	return out.Flush()
+}
+
+// the functions of the file ordered by line
+func sortedFunctions(file *FileCoverage) []FunctionID {
+	functions := make([]FunctionID, 0, len(file.Functions))
+	for fn := range file.Functions {
+		functions = append(functions, fn)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Line != functions[j].Line {
+			return functions[i].Line < functions[j].Line
+		}
+
+		return functions[i].Name < functions[j].Name
+	})
+
+	return functions
+}
+
+// LCOV identifies functions by name alone, so names used by more than one
+// function (such as "anonymous") have the line they're defined on appended.
+func lcovFunctionNames(functions []FunctionID) []string {
+	counts := make(map[string]int)
+	for _, fn := range functions {
+		counts[fn.Name]++
+	}
+
+	names := make([]string, len(functions))
+	for i, fn := range functions {
+		names[i] = fn.Name
+		if counts[fn.Name] > 1 {
+			names[i] = fmt.Sprintf("%s@%d", fn.Name, fn.Line)
+		}
+	}
+
+	return names
+}
+
+// the branches of the file ordered by block then branch
+func sortedBranches(file *FileCoverage) []BranchID {
+	branches := make([]BranchID, 0, len(file.Branches))
+	for branch := range file.Branches {
+		branches = append(branches, branch)
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		if branches[i].Block != branches[j].Block {
+			return branches[i].Block < branches[j].Block
+		}
+
+		return branches[i].Branch < branches[j].Branch
+	})
+
+	return branches
+}
+
+// determines if the condition of the if statement was ever evaluated
+func blockEvaluated(file *FileCoverage, block int) bool {
+	for branch, count := range file.Branches {
+		if branch.Block == block && count > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the lines of the file with statements, in order
+func sortedLines(file *FileCoverage) []int {
+	lines := make([]int, 0, len(file.Lines))
+	for line := range file.Lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+
+	return lines
+}
+
+// coverageHTMLFile is the data used to render a file in the HTML report
+type coverageHTMLFile struct {
+	ID       string
+	Name     string
+	Lines    string
+	Percent  string
+	Branches string
+	Source   []coverageHTMLLine
+}
+
+// coverageHTMLLine is a single line of source in the HTML report
+type coverageHTMLLine struct {
+	Number int
+	Hits   string
+	Class  string
+	Text   string
+}
+
+var coverageHTMLTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Lua Coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.8em; text-align: left; }
+.summary td { border-top: 1px solid #ddd; }
+.source { font-family: monospace; white-space: pre; width: 100%; }
+.source td { padding: 0 0.8em; }
+.number, .hits { color: #888; text-align: right; }
+.covered { background: #dfd; }
+.uncovered { background: #fdd; }
+.partial { background: #ffd; }
+</style>
+</head>
+<body>
+<h1>Lua Coverage</h1>
+<p>{{.Lines}} lines covered ({{.Percent}})</p>
+<table class="summary">
+<tr><th>File</th><th>Lines</th><th>Coverage</th><th>Branches</th></tr>
+{{- range .Files}}
+<tr><td><a href="#{{.ID}}">{{.Name}}</a></td><td>{{.Lines}}</td><td>{{.Percent}}</td><td>{{.Branches}}</td></tr>
+{{- end}}
+</table>
+{{- range .Files}}
+<h2 id="{{.ID}}">{{.Name}}</h2>
+<table class="source">
+{{- range .Source}}
+<tr class="{{.Class}}"><td class="number">{{.Number}}</td><td class="hits">{{.Hits}}</td><td>{{.Text}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes a standalone HTML page summarizing the coverage of each
+// file and listing its source with the hit count of every line. Lines that ran
+// are highlighted green, lines that didn't red, and lines where only one side
+// of an if statement ran yellow.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	files := c.Files()
+	data := struct {
+		Lines   string
+		Percent string
+		Files   []coverageHTMLFile
+	}{
+		Files: make([]coverageHTMLFile, 0, len(files)),
+	}
+
+	totalHit, totalFound := 0, 0
+	for i, file := range files {
+		hit, found := file.LinesHit()
+		totalHit += hit
+		totalFound += found
+		branchHit, branchFound := file.BranchesHit()
+
+		data.Files = append(data.Files, coverageHTMLFile{
+			ID:       "file" + strconv.Itoa(i),
+			Name:     file.Name,
+			Lines:    fmt.Sprintf("%d/%d", hit, found),
+			Percent:  coveragePercent(hit, found),
+			Branches: fmt.Sprintf("%d/%d", branchHit, branchFound),
+			Source:   coverageHTMLSource(file),
+		})
+	}
+	data.Lines = fmt.Sprintf("%d/%d", totalHit, totalFound)
+	data.Percent = coveragePercent(totalHit, totalFound)
+
+	return coverageHTMLTemplate.Execute(w, data)
+}
+
+// the lines of a file annotated with their hits
+func coverageHTMLSource(file *FileCoverage) []coverageHTMLLine {
+	partial := make(map[int]bool)
+	for branch, count := range file.Branches {
+		if count == 0 && blockEvaluated(file, branch.Block) {
+			partial[branch.Line] = true
+		}
+	}
+
+	src := bytes.TrimSuffix(file.Source, []byte("\n"))
+	lines := bytes.Split(src, []byte("\n"))
+	result := make([]coverageHTMLLine, len(lines))
+	for i, text := range lines {
+		number := i + 1
+		line := coverageHTMLLine{
+			Number: number,
+			Text:   string(bytes.TrimSuffix(text, []byte("\r"))),
+		}
+		if hits, ok := file.Lines[number]; ok {
+			line.Hits = strconv.Itoa(hits)
+			switch {
+			case hits == 0:
+				line.Class = "uncovered"
+			case partial[number]:
+				line.Class = "partial"
+			default:
+				line.Class = "covered"
+			}
+		}
+		result[i] = line
+	}
+
+	return result
+}
+
+// format the fraction as a percentage, nothing to cover counts as complete
+func coveragePercent(hit, found int) string {
+	if found == 0 {
+		return "100.0%"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(hit)*100/float64(found))
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"bytes"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("Coverage", func() {
+	const module = `local M = {}
+
+function M.sign(n)
+  if n < 0 then
+    return -1
+  end
+  return 1
+end
+
+function M.unused()
+  return nil
+end
+
+return M
+`
+
+	var (
+		fsys     fstest.MapFS
+		coverage *Coverage
+	)
+
+	newEngine := func() *Engine {
+		eng := NewEngine()
+		eng.OpenLibs()
+		eng.SecureRequireFS(fsys, []string{"?.lua"})
+		eng.EnableCoverage(coverage)
+
+		return eng
+	}
+
+	BeforeEach(func() {
+		fsys = fstest.MapFS{
+			"sign.lua": &fstest.MapFile{Data: []byte(module)},
+		}
+		coverage = NewCoverage()
+	})
+
+	It("records the lines, functions and branches executed", func() {
+		eng := newEngine()
+		defer eng.Close()
+
+		Ω(eng.DoString(`local sign = require("sign"); sign.sign(5)`)).Should(Succeed())
+
+		file := coverage.File("sign.lua")
+		Ω(file).ShouldNot(BeNil())
+		Ω(file.Source).Should(Equal([]byte(module)))
+		Ω(file.Lines).Should(Equal(map[int]int{1: 1, 3: 1, 4: 1, 5: 0, 7: 1, 10: 1, 11: 0, 14: 1}))
+		Ω(file.Functions).Should(Equal(map[FunctionID]int{
+			{Name: "M.sign", Line: 3}:    1,
+			{Name: "M.unused", Line: 10}: 0,
+		}))
+		Ω(file.Branches).Should(Equal(map[BranchID]int{
+			{Line: 4, Block: 0, Branch: 0}: 0,
+			{Line: 4, Block: 0, Branch: 1}: 1,
+		}))
+	})
+
+	It("ignores chunks loaded from strings", func() {
+		eng := newEngine()
+		defer eng.Close()
+
+		Ω(eng.DoString(`local x = 1`)).Should(Succeed())
+		Ω(coverage.Files()).Should(BeEmpty())
+	})
+
+	It("stops recording when disabled", func() {
+		eng := newEngine()
+		defer eng.Close()
+
+		eng.DisableCoverage()
+		Ω(eng.DoString(`require("sign")`)).Should(Succeed())
+		Ω(coverage.Files()).Should(BeEmpty())
+	})
+
+	It("doesn't change the values returned by functions", func() {
+		eng := newEngine()
+		defer eng.Close()
+
+		fsys["multi.lua"] = &fstest.MapFile{Data: []byte(`
+			local function pair() return 1, 2 end
+			local function tail() return pair() end
+			return { a = { pair() }, b = { tail() }, c = { (pair()) } }
+		`)}
+		Ω(eng.DoString(`
+			local m = require("multi")
+			assert(#m.a == 2 and #m.b == 2 and #m.c == 1)
+		`)).Should(Succeed())
+	})
+
+	It("keeps recording chunks already loaded after a reset", func() {
+		eng := newEngine()
+		defer eng.Close()
+
+		Ω(eng.DoString(`sign = require("sign"); sign.sign(5)`)).Should(Succeed())
+		coverage.Reset()
+
+		hit, found := coverage.LinesHit()
+		Ω(hit).Should(Equal(0))
+		Ω(found).Should(Equal(8))
+
+		Ω(eng.DoString(`sign.sign(-5)`)).Should(Succeed())
+		file := coverage.File("sign.lua")
+		Ω(file.Lines).Should(Equal(map[int]int{1: 0, 3: 0, 4: 1, 5: 1, 7: 0, 10: 0, 11: 0, 14: 0}))
+		Ω(file.Functions[FunctionID{Name: "M.sign", Line: 3}]).Should(Equal(1))
+	})
+
+	It("combines the coverage of engines sharing it", func() {
+		first, second := newEngine(), newEngine()
+		defer first.Close()
+		defer second.Close()
+
+		Ω(first.DoString(`require("sign").sign(5)`)).Should(Succeed())
+		Ω(second.DoString(`require("sign").sign(-5)`)).Should(Succeed())
+
+		file := coverage.File("sign.lua")
+		Ω(file.Lines[4]).Should(Equal(2))
+		Ω(file.Lines[5]).Should(Equal(1))
+		hit, found := file.BranchesHit()
+		Ω(hit).Should(Equal(2))
+		Ω(found).Should(Equal(2))
+	})
+
+	It("records the engines of a pool", func() {
+		pool, err := NewEnginePoolWithConfig(EnginePoolConfig{
+			MaxPoolSize: 1,
+			Coverage:    coverage,
+			Mutator: func(eng *Engine) {
+				eng.SecureRequireFS(fsys, []string{"?.lua"})
+			},
+		})
+		Ω(err).Should(BeNil())
+		defer pool.Shutdown()
+
+		eng, err := pool.Get()
+		Ω(err).Should(BeNil())
+		Ω(eng.DoString(`require("sign").sign(1)`)).Should(Succeed())
+		eng.Release()
+
+		Ω(coverage.File("sign.lua").Lines[7]).Should(Equal(1))
+	})
+
+	It("merges separately recorded coverage", func() {
+		eng := newEngine()
+		defer eng.Close()
+		Ω(eng.DoString(`require("sign").sign(-1)`)).Should(Succeed())
+
+		total := NewCoverage()
+		total.Merge(coverage)
+		total.Merge(coverage)
+
+		Ω(total.File("sign.lua").Lines[5]).Should(Equal(2))
+		hit, found := total.LinesHit()
+		Ω(hit).Should(Equal(6))
+		Ω(found).Should(Equal(8))
+	})
+
+	It("is recorded for the modules required by Lua tests", func() {
+		fsys["sign_test.lua"] = &fstest.MapFile{Data: []byte(`
+			local sign = require("sign")
+			it("is negative", function() expect(sign.sign(-2)).to_equal(-1) end)
+		`)}
+
+		report, err := RunLuaTestsWithConfig(fsys, "", LuaTestConfig{Coverage: coverage})
+		Ω(err).Should(BeNil())
+		Ω(report.OK()).Should(BeTrue())
+
+		files := coverage.Files()
+		Ω(files).Should(HaveLen(1))
+		Ω(files[0].Name).Should(Equal("sign.lua"))
+		Ω(files[0].Lines[5]).Should(Equal(1))
+	})
+
+	Describe("reports", func() {
+		BeforeEach(func() {
+			eng := newEngine()
+			defer eng.Close()
+
+			Ω(eng.DoString(`require("sign").sign(5)`)).Should(Succeed())
+		})
+
+		It("writes LCOV", func() {
+			buf := new(bytes.Buffer)
+			Ω(coverage.WriteLCOV(buf)).Should(Succeed())
+
+			Ω(buf.String()).Should(Equal(`TN:
+SF:sign.lua
+FN:3,M.sign
+FN:10,M.unused
+FNDA:1,M.sign
+FNDA:0,M.unused
+FNF:2
+FNH:1
+BRDA:4,0,0,0
+BRDA:4,0,1,1
+BRF:2
+BRH:1
+DA:1,1
+DA:3,1
+DA:4,1
+DA:5,0
+DA:7,1
+DA:10,1
+DA:11,0
+DA:14,1
+LF:8
+LH:6
+end_of_record
+`))
+		})
+
+		It("writes HTML", func() {
+			buf := new(bytes.Buffer)
+			Ω(coverage.WriteHTML(buf)).Should(Succeed())
+
+			html := buf.String()
+			Ω(html).Should(ContainSubstring("6/8 lines covered (75.0%)"))
+			Ω(html).Should(ContainSubstring(`<a href="#file0">sign.lua</a>`))
+			Ω(html).Should(ContainSubstring(`<tr class="partial"><td class="number">4</td><td class="hits">1</td><td>  if n &lt; 0 then</td></tr>`))
+			Ω(html).Should(ContainSubstring(`<tr class="uncovered"><td class="number">5</td>`))
+		})
+	})
+})
@@ -0,0 +1,631 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// errNotStopped is returned for requests that inspect the engine while it's
+// running.
+var errNotStopped = errors.New("the engine is not paused")
+
+// the ways execution can be resumed
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// debugBreakpoint is a line breakpoint set by the client.
+type debugBreakpoint struct {
+	id        int
+	line      int
+	condition string
+	verified  bool
+}
+
+// debugStop describes where the engine is paused, it's only used by the
+// engine's goroutine.
+type debugStop struct {
+	state *glua.LState
+	refs  map[int]debugVariables
+}
+
+// debugFrame is an instrumented function on the Lua call stack, site is the
+// line site it last reported or -1 before it reports one.
+type debugFrame struct {
+	chunk *instrumentedChunk
+	site  int
+	depth int
+}
+
+// debugVariables is something whose variables can be listed by the client, a
+// scope of a frame or a table.
+type debugVariables struct {
+	level    int
+	upvalues bool
+	table    *glua.LTable
+}
+
+// Debugger lets a Debug Adapter Protocol client (such as VS Code) debug the
+// scripts running in an engine. Clients connect over the network and can set
+// breakpoints (with conditions), pause, step through code, inspect the call
+// stack, locals and upvalues and evaluate expressions in a frame. Pausing
+// blocks the goroutine running the engine, other engines are unaffected.
+//
+// Only chunks loaded after the debugger is created can be debugged, as they're
+// instrumented to report each line while they're compiled.
+type Debugger struct {
+	engine *Engine
+
+	// control serializes resuming the engine with running commands on it,
+	// mutex guards the rest of the state.
+	control     *sync.Mutex
+	mutex       *sync.Mutex
+	chunks      []*instrumentedChunk
+	paths       map[int]string
+	breakpoints map[string][]*debugBreakpoint
+	active      map[int]map[int]*debugBreakpoint
+	nextID      int
+	pause       bool
+	step        stepMode
+	stepDepth   int
+	stopped     bool
+	session     *dapSession
+	listener    net.Listener
+	closed      bool
+
+	commands chan func()
+	resume   chan struct{}
+
+	// used only by the engine's goroutine, frames are kept for each thread
+	// (coroutines have their own stack)
+	stop       *debugStop
+	evaluating bool
+	frames     map[*glua.LState][]debugFrame
+}
+
+// NewDebugger attaches a debugger to the engine, use Serve to accept clients.
+func NewDebugger(eng *Engine) *Debugger {
+	d := &Debugger{
+		engine:      eng,
+		control:     new(sync.Mutex),
+		mutex:       new(sync.Mutex),
+		paths:       make(map[int]string),
+		breakpoints: make(map[string][]*debugBreakpoint),
+		active:      make(map[int]map[int]*debugBreakpoint),
+		commands:    make(chan func()),
+		resume:      make(chan struct{}),
+		frames:      make(map[*glua.LState][]debugFrame),
+	}
+	eng.addHookListener(d)
+
+	return d
+}
+
+// Serve accepts Debug Adapter Protocol clients on the listener, one at a time.
+// It blocks until the listener fails or Close is called, returning nil in the
+// latter case.
+func (d *Debugger) Serve(listener net.Listener) error {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+
+		return errors.New("debugger has been closed")
+	}
+	d.listener = listener
+	d.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			d.mutex.Lock()
+			closed := d.closed
+			d.mutex.Unlock()
+			if closed {
+				return nil
+			}
+
+			return err
+		}
+
+		d.serveSession(conn)
+	}
+}
+
+// Close stops accepting clients, disconnects the current client and detaches
+// from the engine, resuming it if it's paused.
+func (d *Debugger) Close() error {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+
+		return nil
+	}
+	d.closed = true
+
+	var err error
+	if d.listener != nil {
+		err = d.listener.Close()
+	}
+	if d.session != nil {
+		d.session.conn.Close()
+	}
+	d.mutex.Unlock()
+
+	d.detach()
+	d.engine.removeHookListener(d)
+
+	return err
+}
+
+// forget the client's breakpoints and let the engine run freely
+func (d *Debugger) detach() {
+	d.control.Lock()
+	defer d.control.Unlock()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.breakpoints = make(map[string][]*debugBreakpoint)
+	d.active = make(map[int]map[int]*debugBreakpoint)
+	d.pause = false
+	d.resumeLocked(stepNone)
+}
+
+func (d *Debugger) chunkInstrumented(chunk *instrumentedChunk) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.chunks = append(d.chunks, chunk)
+	path := sourcePath(chunk.name)
+	d.paths[chunk.id] = path
+
+	for _, bp := range d.breakpoints[path] {
+		d.activate(chunk, bp, true)
+	}
+}
+
+func (d *Debugger) chunkReleased(id int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	chunks := d.chunks[:0]
+	for _, chunk := range d.chunks {
+		if chunk.id != id {
+			chunks = append(chunks, chunk)
+		}
+	}
+	d.chunks = chunks
+	delete(d.paths, id)
+	delete(d.active, id)
+}
+
+// add the breakpoint to the lines checked in the chunk, if the chunk has a
+// statement on the line. When notify is true the client is told if the
+// breakpoint becomes verified.
+func (d *Debugger) activate(chunk *instrumentedChunk, bp *debugBreakpoint, notify bool) {
+	for _, line := range chunk.lines {
+		if line != bp.line {
+			continue
+		}
+
+		lines, ok := d.active[chunk.id]
+		if !ok {
+			lines = make(map[int]*debugBreakpoint)
+			d.active[chunk.id] = lines
+		}
+		lines[bp.line] = bp
+
+		if !bp.verified {
+			bp.verified = true
+			if notify && d.session != nil {
+				d.session.event("breakpoint", map[string]interface{}{
+					"reason":     "changed",
+					"breakpoint": bp.body(),
+				})
+			}
+		}
+
+		return
+	}
+}
+
+// setBreakpoints replaces the breakpoints in the source file
+func (d *Debugger) setBreakpoints(path string, lines []int, conditions []string) []*debugBreakpoint {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	path = sourcePath(path)
+	bps := make([]*debugBreakpoint, len(lines))
+	for i, line := range lines {
+		d.nextID++
+		bps[i] = &debugBreakpoint{
+			id:        d.nextID,
+			line:      line,
+			condition: conditions[i],
+		}
+	}
+	d.breakpoints[path] = bps
+
+	for _, chunk := range d.chunks {
+		if d.paths[chunk.id] != path {
+			continue
+		}
+
+		delete(d.active, chunk.id)
+		for _, bp := range bps {
+			d.activate(chunk, bp, false)
+		}
+	}
+
+	return bps
+}
+
+// hook is called for every event of the engine's instrumented chunks, on the
+// engine's goroutine. It pauses the engine when a breakpoint is hit, a step
+// completes or the client asked to pause.
+func (d *Debugger) hook(_ *Engine, ev *hookEvent) {
+	if ev.kind == hookBranch || d.evaluating {
+		return
+	}
+
+	d.trackFrames(ev)
+	if ev.kind != hookLine {
+		return
+	}
+
+	d.mutex.Lock()
+	if d.session == nil {
+		d.mutex.Unlock()
+
+		return
+	}
+
+	reason := ""
+	var bp *debugBreakpoint
+	switch {
+	case d.pause:
+		reason = "pause"
+	case d.step == stepIn:
+		reason = "step"
+	case d.step == stepOver && ev.depth() <= d.stepDepth:
+		reason = "step"
+	case d.step == stepOut && ev.depth() < d.stepDepth:
+		reason = "step"
+	default:
+		bp = d.active[ev.chunk.id][ev.index]
+		if bp != nil {
+			reason = "breakpoint"
+		}
+	}
+	d.mutex.Unlock()
+
+	if len(reason) == 0 {
+		return
+	}
+	if bp != nil && len(bp.condition) > 0 {
+		val, err := d.evaluate(ev.state, 1, bp.condition)
+		if err == nil && !glua.LVAsBool(val) {
+			return
+		}
+	}
+
+	d.pauseEngine(ev, reason, bp)
+}
+
+// follow the instrumented functions on the call stack so the locals of each
+// can be named
+func (d *Debugger) trackFrames(ev *hookEvent) {
+	depth := ev.depth()
+	frames := truncateDebugFrames(d.frames[ev.state], depth+1)
+
+	switch ev.kind {
+	case hookCall:
+		frames = truncateDebugFrames(frames, depth)
+		frames = append(frames, debugFrame{chunk: ev.chunk, site: -1, depth: depth})
+	case hookReturn:
+		frames = truncateDebugFrames(frames, depth)
+	case hookLine:
+		n := len(frames)
+		if n > 0 && frames[n-1].depth == depth && frames[n-1].chunk == ev.chunk {
+			frames[n-1].site = ev.site
+		} else {
+			frames = truncateDebugFrames(frames, depth)
+			frames = append(frames, debugFrame{chunk: ev.chunk, site: ev.site, depth: depth})
+		}
+	}
+
+	d.frames[ev.state] = frames
+}
+
+// drop the frames that have returned or been unwound by an error
+func (d *Debugger) luaCallFinished(eng *Engine, depth int, _ error) {
+	d.frames[eng.state] = truncateDebugFrames(d.frames[eng.state], depth+1)
+	for state, frames := range d.frames {
+		if len(frames) == 0 {
+			delete(d.frames, state)
+		}
+	}
+}
+
+// remove the frames at or above the given depth
+func truncateDebugFrames(frames []debugFrame, depth int) []debugFrame {
+	for len(frames) > 0 && frames[len(frames)-1].depth >= depth {
+		frames = frames[:len(frames)-1]
+	}
+
+	return frames
+}
+
+// the names of the locals in scope for the function at the stack level, in
+// register order, or nil if the function isn't known
+func (d *Debugger) localNames(l *glua.LState, level int) []string {
+	depth := callDepth(l) - level
+	for _, frame := range d.frames[l] {
+		if frame.depth == depth && frame.site >= 0 {
+			return frame.chunk.sites[frame.site].locals
+		}
+	}
+
+	return nil
+}
+
+// block the engine until the client resumes it, running the commands the
+// client sends in the meantime. The engine keeps running if the client has
+// gone since the event was checked.
+func (d *Debugger) pauseEngine(ev *hookEvent, reason string, bp *debugBreakpoint) {
+	d.mutex.Lock()
+	session := d.session
+	if session == nil || d.closed {
+		d.mutex.Unlock()
+
+		return
+	}
+	d.pause = false
+	d.step = stepNone
+	d.stepDepth = ev.depth()
+	d.stopped = true
+	d.mutex.Unlock()
+
+	d.stop = &debugStop{
+		state: ev.state,
+		refs:  make(map[int]debugVariables),
+	}
+	defer func() {
+		d.stop = nil
+	}()
+
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          dapThreadID,
+		"allThreadsStopped": true,
+	}
+	if bp != nil {
+		body["hitBreakpointIds"] = []int{bp.id}
+	}
+	session.event("stopped", body)
+
+	for {
+		select {
+		case cmd := <-d.commands:
+			cmd()
+		case <-d.resume:
+			return
+		}
+	}
+}
+
+// onEngine runs the function on the engine's goroutine while it's paused
+func (d *Debugger) onEngine(fn func(stop *debugStop)) error {
+	d.control.Lock()
+	defer d.control.Unlock()
+
+	d.mutex.Lock()
+	stopped := d.stopped
+	d.mutex.Unlock()
+	if !stopped {
+		return errNotStopped
+	}
+
+	done := make(chan struct{})
+	d.commands <- func() {
+		fn(d.stop)
+		close(done)
+	}
+	<-done
+
+	return nil
+}
+
+// request that the engine pauses at the next line
+func (d *Debugger) requestPause() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.pause = true
+}
+
+// continue running the paused engine in the given mode
+func (d *Debugger) resumeEngine(mode stepMode) error {
+	d.control.Lock()
+	defer d.control.Unlock()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.stopped {
+		return errNotStopped
+	}
+	d.resumeLocked(mode)
+
+	return nil
+}
+
+// resume the engine if it's paused, the lock must be held
+func (d *Debugger) resumeLocked(mode stepMode) {
+	if !d.stopped {
+		return
+	}
+
+	d.step = mode
+	d.stopped = false
+	d.resume <- struct{}{}
+}
+
+// evaluate the expression (or statement) with the locals and upvalues of the
+// function at the given level of the call stack in scope, returning the first
+// value it produces. Assignments are made to globals.
+func (d *Debugger) evaluate(l *glua.LState, level int, expr string) (glua.LValue, error) {
+	// the level is relative to the Lua function that reported the event, the
+	// hook function is on top of it
+	dbg, ok := l.GetStack(level)
+	if !ok {
+		return glua.LNil, fmt.Errorf("invalid frame %d", level)
+	}
+
+	fn, err := l.Load(strings.NewReader("return "+expr), "=(eval)")
+	if err != nil {
+		fn, err = l.Load(strings.NewReader(expr), "=(eval)")
+		if err != nil {
+			return glua.LNil, err
+		}
+	}
+
+	env := l.NewTable()
+	meta := l.NewTable()
+	meta.RawSetString("__index", l.G.Global)
+	meta.RawSetString("__newindex", l.G.Global)
+	l.SetMetatable(env, meta)
+	for _, v := range frameUpvalues(l, dbg) {
+		env.RawSetString(v.name, v.value)
+	}
+	for _, v := range frameLocals(l, dbg, d.localNames(l, level)) {
+		env.RawSetString(v.name, v.value)
+	}
+	fn.Env = env
+
+	d.evaluating = true
+	defer func() {
+		d.evaluating = false
+	}()
+
+	top := l.GetTop()
+	l.Push(fn)
+	if err := l.PCall(0, 1, nil); err != nil {
+		l.SetTop(top)
+
+		return glua.LNil, err
+	}
+	val := l.Get(-1)
+	l.SetTop(top)
+
+	return val, nil
+}
+
+// debugVariable is a named value in a frame
+type debugVariable struct {
+	name  string
+	value glua.LValue
+}
+
+// the visible locals of the function at the stack level, in the order they
+// were declared. When the names of the locals in scope are known they're used
+// instead of the names gopher-lua reports.
+func frameLocals(l *glua.LState, dbg *glua.Debug, names []string) []debugVariable {
+	vars := make([]debugVariable, 0)
+	if names != nil {
+		for i, name := range names {
+			if isInternalName(name) {
+				continue
+			}
+			_, val := l.GetLocal(dbg, i+1)
+			vars = append(vars, debugVariable{name: name, value: val})
+		}
+
+		return vars
+	}
+
+	for i := 1; ; i++ {
+		name, val := l.GetLocal(dbg, i)
+		if len(name) == 0 {
+			break
+		}
+		if isInternalName(name) {
+			continue
+		}
+		vars = append(vars, debugVariable{name: name, value: val})
+	}
+
+	return vars
+}
+
+// the upvalues of the function at the stack level
+func frameUpvalues(l *glua.LState, dbg *glua.Debug) []debugVariable {
+	vars := make([]debugVariable, 0)
+	fnVal, err := l.GetInfo("f", dbg, glua.LNil)
+	if err != nil {
+		return vars
+	}
+	fn, ok := fnVal.(*glua.LFunction)
+	if !ok || fn.IsG {
+		return vars
+	}
+
+	for i := 1; i <= len(fn.Upvalues); i++ {
+		name, val := l.GetUpvalue(fn, i)
+		if len(name) == 0 || isInternalName(name) {
+			continue
+		}
+		vars = append(vars, debugVariable{name: name, value: val})
+	}
+
+	return vars
+}
+
+// determines if the variable is one of gopher-lua's or the instrumentation's
+func isInternalName(name string) bool {
+	return strings.HasPrefix(name, "(") || name == hookLocal
+}
+
+// the variables in a table, ordered by key
+func tableVariables(tbl *glua.LTable) []debugVariable {
+	vars := make([]debugVariable, 0)
+	tbl.ForEach(func(key, val glua.LValue) {
+		name := key.String()
+		if str, ok := key.(glua.LString); !ok {
+			name = "[" + key.String() + "]"
+		} else if len(str) == 0 {
+			name = `[""]`
+		}
+		vars = append(vars, debugVariable{name: name, value: val})
+	})
+	sort.SliceStable(vars, func(i, j int) bool {
+		return vars[i].name < vars[j].name
+	})
+
+	return vars
+}
+
+// the path breakpoints are matched against for the chunk name (or a path
+// given by the client)
+func sourcePath(name string) string {
+	if strings.HasPrefix(name, "<") || strings.HasPrefix(name, "=") {
+		return name
+	}
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+
+	return filepath.Clean(name)
+}
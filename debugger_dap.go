@@ -0,0 +1,502 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// the engine is presented to clients as a single thread
+const dapThreadID = 1
+
+// dapRequest is a request sent by the client.
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// dapResponse is the reply to a request.
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Command    string      `json:"command"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// dapEvent is a message sent to the client without being requested.
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// dapSession is a connected client.
+type dapSession struct {
+	conn     net.Conn
+	in       *bufio.Reader
+	mutex    sync.Mutex
+	seq      int
+	debugger *Debugger
+}
+
+// serve the client until it disconnects
+func (d *Debugger) serveSession(conn net.Conn) {
+	session := &dapSession{
+		conn:     conn,
+		in:       bufio.NewReader(conn),
+		debugger: d,
+	}
+
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		conn.Close()
+
+		return
+	}
+	d.session = session
+	d.mutex.Unlock()
+
+	defer func() {
+		conn.Close()
+		d.mutex.Lock()
+		d.session = nil
+		d.mutex.Unlock()
+		d.detach()
+	}()
+
+	for {
+		req, err := session.read()
+		if err != nil {
+			return
+		}
+
+		if !session.handle(req) {
+			return
+		}
+	}
+}
+
+// read the next request, messages have a Content-Length header followed by a
+// blank line and the JSON body.
+func (s *dapSession) read() (*dapRequest, error) {
+	headers, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+
+	req := new(dapRequest)
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// write a message, assigning its sequence number
+func (s *dapSession) write(build func(seq int) interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	body, err := json.Marshal(build(s.seq))
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(s.conn, "Content-Length: %d\r\n\r\n", len(body))
+	s.conn.Write(body)
+}
+
+func (s *dapSession) event(name string, body interface{}) {
+	s.write(func(seq int) interface{} {
+		return dapEvent{Seq: seq, Type: "event", Event: name, Body: body}
+	})
+}
+
+func (s *dapSession) respond(req *dapRequest, body interface{}, err error) {
+	s.write(func(seq int) interface{} {
+		resp := dapResponse{
+			Seq:        seq,
+			Type:       "response",
+			RequestSeq: req.Seq,
+			Command:    req.Command,
+			Success:    err == nil,
+			Body:       body,
+		}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+
+		return resp
+	})
+}
+
+// handle a request, returning false once the client has disconnected
+func (s *dapSession) handle(req *dapRequest) bool {
+	d := s.debugger
+
+	var (
+		body interface{}
+		err  error
+	)
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsEvaluateForHovers":        true,
+		}, nil)
+		s.event("initialized", nil)
+
+		return true
+	case "launch", "attach":
+		var args struct {
+			StopOnEntry bool `json:"stopOnEntry"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		if args.StopOnEntry {
+			d.requestPause()
+		}
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		body = map[string]interface{}{"breakpoints": []interface{}{}}
+	case "configurationDone":
+	case "threads":
+		body = map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": dapThreadID, "name": "luna"}},
+		}
+	case "stackTrace":
+		body, err = s.stackTrace(req.Arguments)
+	case "scopes":
+		body, err = s.scopes(req.Arguments)
+	case "variables":
+		body, err = s.variables(req.Arguments)
+	case "evaluate":
+		body, err = s.evaluate(req.Arguments)
+	case "continue":
+		err = d.resumeEngine(stepNone)
+		body = map[string]interface{}{"allThreadsContinued": true}
+	case "next":
+		err = d.resumeEngine(stepOver)
+	case "stepIn":
+		err = d.resumeEngine(stepIn)
+	case "stepOut":
+		err = d.resumeEngine(stepOut)
+	case "pause":
+		d.requestPause()
+	case "disconnect":
+		s.respond(req, nil, nil)
+
+		return false
+	default:
+		err = fmt.Errorf("unsupported request %q", req.Command)
+	}
+
+	s.respond(req, body, err)
+
+	return true
+}
+
+// the JSON form of the breakpoint
+func (bp *debugBreakpoint) body() map[string]interface{} {
+	return map[string]interface{}{
+		"id":       bp.id,
+		"verified": bp.verified,
+		"line":     bp.line,
+	}
+}
+
+func (s *dapSession) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line      int    `json:"line"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	lines := make([]int, len(args.Breakpoints))
+	conditions := make([]string, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		lines[i] = bp.Line
+		conditions[i] = bp.Condition
+	}
+
+	bps := s.debugger.setBreakpoints(args.Source.Path, lines, conditions)
+	result := make([]map[string]interface{}, len(bps))
+	for i, bp := range bps {
+		result[i] = bp.body()
+	}
+
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+func (s *dapSession) stackTrace(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	frames := make([]map[string]interface{}, 0)
+	err := s.debugger.onEngine(func(stop *debugStop) {
+		l := stop.state
+		// level 0 is the hook function
+		for level := 1; ; level++ {
+			dbg, ok := l.GetStack(level)
+			if !ok {
+				break
+			}
+			if _, err := l.GetInfo("Sln", dbg, glua.LNil); err != nil {
+				break
+			}
+
+			frame := map[string]interface{}{
+				"id":     level,
+				"name":   frameName(dbg),
+				"line":   dbg.CurrentLine,
+				"column": 1,
+			}
+			if dbg.What == "G" {
+				frame["line"] = 0
+				frame["presentationHint"] = "subtle"
+			} else {
+				frame["source"] = dapSource(dbg.Source)
+			}
+			frames = append(frames, frame)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(frames)
+	if args.StartFrame < len(frames) {
+		frames = frames[args.StartFrame:]
+	} else {
+		frames = frames[:0]
+	}
+	if args.Levels > 0 && args.Levels < len(frames) {
+		frames = frames[:args.Levels]
+	}
+
+	return map[string]interface{}{
+		"stackFrames": frames,
+		"totalFrames": total,
+	}, nil
+}
+
+// the name of the function of a frame
+func frameName(dbg *glua.Debug) string {
+	switch {
+	case dbg.What == "main":
+		return "main chunk"
+	case len(dbg.Name) > 0:
+		return dbg.Name
+	case dbg.What == "G":
+		return "[Go]"
+	}
+
+	return fmt.Sprintf("function <%s:%d>", dbg.Source, dbg.LineDefined)
+}
+
+// the JSON form of a source file
+func dapSource(name string) map[string]interface{} {
+	source := map[string]interface{}{
+		"name": filepath.Base(name),
+	}
+	if path := sourcePath(name); filepath.IsAbs(path) {
+		source["path"] = path
+	}
+
+	return source
+}
+
+func (s *dapSession) scopes(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	scopes := make([]map[string]interface{}, 0, 2)
+	err := s.debugger.onEngine(func(stop *debugStop) {
+		if _, ok := stop.state.GetStack(args.FrameID); !ok {
+			return
+		}
+
+		locals := stop.reference(debugVariables{level: args.FrameID})
+		upvalues := stop.reference(debugVariables{level: args.FrameID, upvalues: true})
+		scopes = append(scopes,
+			map[string]interface{}{"name": "Locals", "presentationHint": "locals", "variablesReference": locals, "expensive": false},
+			map[string]interface{}{"name": "Upvalues", "variablesReference": upvalues, "expensive": false},
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+// reference assigns an ID the client uses to request the variables
+func (stop *debugStop) reference(vars debugVariables) int {
+	id := len(stop.refs) + 1
+	stop.refs[id] = vars
+
+	return id
+}
+
+func (s *dapSession) variables(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	variables := make([]map[string]interface{}, 0)
+	err := s.debugger.onEngine(func(stop *debugStop) {
+		ref, ok := stop.refs[args.VariablesReference]
+		if !ok {
+			return
+		}
+
+		var vars []debugVariable
+		switch {
+		case ref.table != nil:
+			vars = tableVariables(ref.table)
+		default:
+			dbg, ok := stop.state.GetStack(ref.level)
+			if !ok {
+				return
+			}
+			if ref.upvalues {
+				vars = frameUpvalues(stop.state, dbg)
+			} else {
+				vars = frameLocals(stop.state, dbg, s.debugger.localNames(stop.state, ref.level))
+			}
+		}
+
+		for _, v := range vars {
+			variable := s.describe(stop, v.value)
+			variable["name"] = v.name
+			variables = append(variables, variable)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+// describe a value for the client, rendered with Inspect. Tables can be
+// expanded to list their fields. Inspect may run Lua (an inspect method) so
+// breakpoints are ignored while rendering, as they are for evaluate.
+func (s *dapSession) describe(stop *debugStop, lval glua.LValue) map[string]interface{} {
+	val := s.debugger.engine.newValue(lval)
+	s.debugger.evaluating = true
+	rendered := val.Inspect("")
+	s.debugger.evaluating = false
+	ref := 0
+	if tbl, ok := lval.(*glua.LTable); ok {
+		ref = stop.reference(debugVariables{table: tbl})
+		if strings.Contains(rendered, "\n") {
+			rendered = fmt.Sprintf("table (%d fields)", countFields(tbl))
+		}
+	}
+
+	return map[string]interface{}{
+		"value":              rendered,
+		"type":               lval.Type().String(),
+		"variablesReference": ref,
+	}
+}
+
+// the number of keys in the table
+func countFields(tbl *glua.LTable) int {
+	count := 0
+	tbl.ForEach(func(glua.LValue, glua.LValue) {
+		count++
+	})
+
+	return count
+}
+
+func (s *dapSession) evaluate(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.FrameID == 0 {
+		args.FrameID = 1
+	}
+
+	var (
+		result  map[string]interface{}
+		evalErr error
+	)
+	err := s.debugger.onEngine(func(stop *debugStop) {
+		val, err := s.debugger.evaluate(stop.state, args.FrameID, args.Expression)
+		if err != nil {
+			evalErr = err
+
+			return
+		}
+
+		result = s.describe(stop, val)
+		result["result"] = result["value"]
+		delete(result, "value")
+	})
+	if err != nil {
+		if errors.Is(err, errNotStopped) {
+			return nil, errors.New("expressions can only be evaluated while the engine is paused")
+		}
+
+		return nil, err
+	}
+
+	return result, evalErr
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+// dapClient speaks just enough of the Debug Adapter Protocol for the tests
+type dapClient struct {
+	conn net.Conn
+	in   *bufio.Reader
+	seq  int
+}
+
+func (c *dapClient) send(command string, args interface{}) {
+	c.seq++
+	body, err := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	Ω(err).Should(BeNil())
+	fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (c *dapClient) read() map[string]interface{} {
+	headers, err := textproto.NewReader(c.in).ReadMIMEHeader()
+	Ω(err).Should(BeNil())
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	Ω(err).Should(BeNil())
+	body := make([]byte, length)
+	_, err = io.ReadFull(c.in, body)
+	Ω(err).Should(BeNil())
+
+	msg := make(map[string]interface{})
+	Ω(json.Unmarshal(body, &msg)).Should(Succeed())
+
+	return msg
+}
+
+// send the request and wait for its response, skipping events
+func (c *dapClient) request(command string, args interface{}) map[string]interface{} {
+	c.send(command, args)
+	for {
+		msg := c.read()
+		if msg["type"] == "response" && msg["command"] == command {
+			return msg
+		}
+	}
+}
+
+// wait for the event, skipping anything else
+func (c *dapClient) await(event string) map[string]interface{} {
+	for {
+		msg := c.read()
+		if msg["type"] == "event" && msg["event"] == event {
+			return msg["body"].(map[string]interface{})
+		}
+	}
+}
+
+var _ = Describe("Debugger", func() {
+	const script = `local total = 0
+for i = 1, 5 do
+  total = total + i
+end
+local function double(n)
+  local result = n * 2
+  return result
+end
+local doubled = double(total)
+finished = doubled
+`
+
+	var (
+		eng      *Engine
+		debugger *Debugger
+		listener net.Listener
+		client   *dapClient
+		path     string
+		dir      string
+		done     chan error
+		finished chan struct{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "luna-debugger")
+		Ω(err).Should(BeNil())
+		path = filepath.Join(dir, "script.lua")
+		Ω(ioutil.WriteFile(path, []byte(script), 0644)).Should(Succeed())
+
+		eng = NewEngine()
+		debugger = NewDebugger(eng)
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).Should(BeNil())
+		go debugger.Serve(listener)
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Ω(err).Should(BeNil())
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		client = &dapClient{conn: conn, in: bufio.NewReader(conn)}
+
+		Ω(client.request("initialize", map[string]interface{}{})["success"]).Should(BeTrue())
+		Ω(client.request("attach", map[string]interface{}{})["success"]).Should(BeTrue())
+		done = make(chan error, 1)
+		finished = nil
+	})
+
+	AfterEach(func() {
+		client.conn.Close()
+		Ω(debugger.Close()).Should(Succeed())
+		if finished != nil {
+			Eventually(finished).Should(BeClosed())
+		}
+		eng.Close()
+		os.RemoveAll(dir)
+	})
+
+	run := func() {
+		finished = make(chan struct{})
+		go func() {
+			defer close(finished)
+			done <- eng.DoFile(path)
+		}()
+	}
+
+	setBreakpoint := func(line int, condition string) {
+		resp := client.request("setBreakpoints", map[string]interface{}{
+			"source":      map[string]interface{}{"path": path},
+			"breakpoints": []map[string]interface{}{{"line": line, "condition": condition}},
+		})
+		Ω(resp["success"]).Should(BeTrue())
+		client.request("configurationDone", nil)
+	}
+
+	topFrame := func() map[string]interface{} {
+		resp := client.request("stackTrace", map[string]interface{}{"threadId": 1})
+		Ω(resp["success"]).Should(BeTrue())
+		frames := resp["body"].(map[string]interface{})["stackFrames"].([]interface{})
+
+		return frames[0].(map[string]interface{})
+	}
+
+	evaluate := func(expr string) string {
+		resp := client.request("evaluate", map[string]interface{}{"expression": expr, "frameId": 1})
+		Ω(resp["success"]).Should(BeTrue(), fmt.Sprint(resp["message"]))
+
+		return resp["body"].(map[string]interface{})["result"].(string)
+	}
+
+	It("stops at breakpoints and reports the stack and locals", func() {
+		setBreakpoint(6, "")
+		run()
+
+		stopped := client.await("stopped")
+		Ω(stopped["reason"]).Should(Equal("breakpoint"))
+
+		frame := topFrame()
+		Ω(frame["line"]).Should(BeEquivalentTo(6))
+		Ω(frame["name"]).Should(Equal("double"))
+		Ω(frame["source"].(map[string]interface{})["path"]).Should(Equal(path))
+
+		scopes := client.request("scopes", map[string]interface{}{"frameId": frame["id"]})
+		locals := scopes["body"].(map[string]interface{})["scopes"].([]interface{})[0].(map[string]interface{})
+		vars := client.request("variables", map[string]interface{}{"variablesReference": locals["variablesReference"]})
+		variables := vars["body"].(map[string]interface{})["variables"].([]interface{})
+		Ω(variables).Should(HaveLen(1))
+		Ω(variables[0].(map[string]interface{})["name"]).Should(Equal("n"))
+		Ω(variables[0].(map[string]interface{})["value"]).Should(Equal("15"))
+
+		Ω(evaluate("n + 1")).Should(Equal("16"))
+
+		Ω(client.request("continue", map[string]interface{}{"threadId": 1})["success"]).Should(BeTrue())
+		Eventually(done).Should(Receive(BeNil()))
+		Ω(eng.GetGlobal("finished").AsNumber()).Should(BeEquivalentTo(30))
+	})
+
+	It("only stops at conditional breakpoints when the condition holds", func() {
+		setBreakpoint(3, "i == 4")
+		run()
+
+		client.await("stopped")
+		Ω(evaluate("i")).Should(Equal("4"))
+		Ω(evaluate("total")).Should(Equal("6"))
+
+		client.request("continue", map[string]interface{}{"threadId": 1})
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("steps over, into and out of functions", func() {
+		setBreakpoint(9, "")
+		run()
+		client.await("stopped")
+
+		client.request("stepIn", map[string]interface{}{"threadId": 1})
+		Ω(client.await("stopped")["reason"]).Should(Equal("step"))
+		Ω(topFrame()["line"]).Should(BeEquivalentTo(6))
+
+		client.request("next", map[string]interface{}{"threadId": 1})
+		client.await("stopped")
+		Ω(topFrame()["line"]).Should(BeEquivalentTo(7))
+
+		client.request("stepOut", map[string]interface{}{"threadId": 1})
+		client.await("stopped")
+		Ω(topFrame()["line"]).Should(BeEquivalentTo(10))
+		Ω(evaluate("doubled")).Should(Equal("30"))
+
+		client.request("continue", map[string]interface{}{"threadId": 1})
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("ignores breakpoints in the Lua run to describe variables", func() {
+		Ω(ioutil.WriteFile(path, []byte(`local obj = {}
+function obj.inspect(self)
+  return "custom"
+end
+finished = obj
+`), 0644)).Should(Succeed())
+		resp := client.request("setBreakpoints", map[string]interface{}{
+			"source":      map[string]interface{}{"path": path},
+			"breakpoints": []map[string]interface{}{{"line": 3}, {"line": 5}},
+		})
+		Ω(resp["success"]).Should(BeTrue())
+		client.request("configurationDone", nil)
+		run()
+		client.await("stopped")
+
+		scopes := client.request("scopes", map[string]interface{}{"frameId": topFrame()["id"]})
+		locals := scopes["body"].(map[string]interface{})["scopes"].([]interface{})[0].(map[string]interface{})
+		vars := client.request("variables", map[string]interface{}{"variablesReference": locals["variablesReference"]})
+		variables := vars["body"].(map[string]interface{})["variables"].([]interface{})
+		Ω(variables).Should(HaveLen(1))
+		Ω(variables[0].(map[string]interface{})["value"]).Should(Equal(`"custom"`))
+
+		client.request("continue", map[string]interface{}{"threadId": 1})
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("keeps the engine running when the client disconnects as it stops", func() {
+		// the condition is checked after the hook has seen the client, which
+		// is gone by the time the engine would pause
+		eng.SetGlobal("disconnect", func() bool {
+			client.conn.Close()
+			time.Sleep(100 * time.Millisecond)
+
+			return true
+		})
+		setBreakpoint(3, "disconnect()")
+		run()
+
+		Eventually(done).Should(Receive(BeNil()))
+		Ω(eng.GetGlobal("finished").AsNumber()).Should(BeEquivalentTo(30))
+	})
+
+	It("refuses to evaluate while the engine is running", func() {
+		resp := client.request("evaluate", map[string]interface{}{"expression": "1"})
+		Ω(resp["success"]).Should(BeFalse())
+	})
+
+	It("resumes the engine when the client disconnects", func() {
+		setBreakpoint(6, "")
+		run()
+		client.await("stopped")
+
+		client.request("disconnect", nil)
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})
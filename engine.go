@@ -3,8 +3,14 @@
 package luna
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 
@@ -12,14 +18,27 @@ import (
 	gluar "layeh.com/gopher-luar"
 )
 
+// ErrNotReloadable is returned when reloading a module that wasn't loaded from
+// a file by require.
+var ErrNotReloadable = errors.New("not loaded as a reloadable module")
+
 // Engine is the core interface for interacting with a glua.LState, it provides
 // most methods the base LState provides in a more conveinient way as well as
 // adding new ways to interact with the LState.
 type Engine struct {
-	state   *glua.LState
-	closed  bool
-	Meta    map[string]interface{}
-	Options EngineOptions
+	state           *glua.LState
+	closed          bool
+	modulePaths     map[string]string
+	moduleOrder     []string
+	instrumentation *instrumentation
+	coverage        *coverageRecorder
+	profiler        *profiler
+	hook            *userHook
+	naming          *naming
+	classes         map[*glua.LTable]*class
+	enums           map[reflect.Type]*enum
+	Meta            map[string]interface{}
+	Options         EngineOptions
 }
 
 // do no open default libs, use snake case
@@ -41,9 +60,10 @@ func NewEngineWithOptions(options EngineOptions) *Engine {
 			SkipOpenLibs:        true,
 			IncludeGoStackTrace: true,
 		}),
-		closed:  false,
-		Meta:    make(map[string]interface{}),
-		Options: options,
+		closed:      false,
+		modulePaths: make(map[string]string),
+		Meta:        make(map[string]interface{}),
+		Options:     options,
 	}
 	eng.OpenBase()
 	eng.OpenPackage()
@@ -58,20 +78,43 @@ func NewEngineWithOptions(options EngineOptions) *Engine {
 // perform configuartion work on the engine
 func (e *Engine) configureFromOptions() {
 	config := gluar.GetConfig(e.state)
-	config.FieldNames = e.Options.FieldCasing.getFieldTransformer()
-	config.MethodNames = e.Options.MethodCasing.getMethodTransformer()
+	fields, methods := e.Options.transformers()
+	e.naming = newNaming(e, fields, methods)
+	config.FieldNames = e.naming.fieldNames
+	config.MethodNames = e.naming.methodNames
 
-	if engine.Options.OpenLibs {
+	if e.Options.OpenLibs {
 		e.OpenLibs()
 	}
 }
 
-// Close will perform a close on the Lua state.
+// Close will perform a close on the Lua state. Closing an engine more than once
+// has no effect.
 func (e *Engine) Close() {
+	if e.closed {
+		return
+	}
+	if e.profiler != nil {
+		// stop the sampling goroutine, the profile is abandoned
+		close(e.profiler.stop)
+		<-e.profiler.done
+		e.profiler = nil
+	}
 	e.state.Close()
 	e.closed = true
 }
 
+// SetContext associates a context with the engine, any script running in the
+// engine is stopped with an error once the context is done.
+func (e *Engine) SetContext(ctx context.Context) {
+	e.state.SetContext(ctx)
+}
+
+// RemoveContext removes the context associated with the engine and returns it.
+func (e *Engine) RemoveContext() context.Context {
+	return e.state.RemoveContext()
+}
+
 // OpenBase allows the Lua engine to open the base library up for use in
 // scripts.
 func (e *Engine) OpenBase() {
@@ -112,9 +155,20 @@ func (e *Engine) OpenOS() {
 }
 
 // OpenPackage allows the Lua module for packages to be used in scripts.
+// Modules require finds on package.path are recorded so they can be reloaded.
 // TODO: Find out what this does/means.
 func (e *Engine) OpenPackage() {
 	glua.OpenPackage(e.state)
+	e.recordPackagePath()
+}
+
+// replace the package.path loader require uses with one that records the
+// files modules are loaded from
+func (e *Engine) recordPackagePath() {
+	pkg := e.state.GetField(e.state.Get(glua.EnvironIndex), "package")
+	if loaders, ok := e.state.GetField(pkg, "loaders").(*glua.LTable); ok {
+		loaders.RawSetInt(2, e.genScriptFunc(packagePathLoader))
+	}
 }
 
 // OpenString allows the Lua module for string operations to be used in
@@ -132,18 +186,24 @@ func (e *Engine) OpenTable() {
 // be used if security isn't necessarily a major concern.
 func (e *Engine) OpenLibs() {
 	e.state.OpenLibs()
+	e.recordPackagePath()
 }
 
 // DoFile runs the file through the Lua interpreter.
 func (e *Engine) DoFile(fn string) error {
-	return e.state.DoFile(fn)
+	lfn, err := e.compileFile(fn)
+	if err != nil {
+		return err
+	}
+
+	return e.run(lfn)
 }
 
 // LoadString runs the given string through the Lua interpreter, wrapping it
 // in a function that is then returned and it can be executed by calling the
 // returned function.
 func (e *Engine) LoadString(src string) (*Value, error) {
-	fn, err := e.state.LoadString(src)
+	fn, err := e.compile(strings.NewReader(src), "<string>")
 	if err != nil {
 		return nil, err
 	}
@@ -154,7 +214,18 @@ func (e *Engine) LoadString(src string) (*Value, error) {
 // LoadFile attempts to read the file from the file system and then load it
 // into the engine, returning a function that executes the contents of the file.
 func (e *Engine) LoadFile(fpath string) (*Value, error) {
-	fn, err := e.state.LoadFile(fpath)
+	fn, err := e.compileFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.ValueFor(fn), nil
+}
+
+// load compiles the source read from r into a function, name is used as the
+// chunk name in error messages.
+func (e *Engine) load(r io.Reader, name string) (*Value, error) {
+	fn, err := e.compile(r, name)
 	if err != nil {
 		return nil, err
 	}
@@ -164,7 +235,12 @@ func (e *Engine) LoadFile(fpath string) (*Value, error) {
 
 // DoString runs the given string through the Lua interpreter.
 func (e *Engine) DoString(src string) error {
-	return e.state.DoString(src)
+	fn, err := e.compile(strings.NewReader(src), "<string>")
+	if err != nil {
+		return err
+	}
+
+	return e.run(fn)
 }
 
 // RaiseError will throw an error in the Lua engine.
@@ -368,8 +444,8 @@ func (e *Engine) SecureRequire(validPaths []string) {
 		if eng.StackSize() == 0 {
 			eng.ArgumentError(1, "expected a string, got nothing")
 		}
-		mod := eng.PopString()
-		mod = strings.Replace(mod, ".", "/", -1)
+		name := eng.PopString()
+		mod := strings.Replace(name, ".", "/", -1)
 		for _, path := range validPaths {
 			fpath := strings.Replace(path, "?", mod, -1)
 			if _, err := os.Stat(fpath); err == nil {
@@ -379,6 +455,7 @@ func (e *Engine) SecureRequire(validPaths []string) {
 
 					return 0
 				}
+				eng.recordModulePath(name, fpath)
 				eng.PushValue(fn)
 
 				return 1
@@ -390,13 +467,141 @@ func (e *Engine) SecureRequire(validPaths []string) {
 		return 0
 	}
 
+	e.setRequireLoader(require)
+}
+
+// SecureRequireFS is like SecureRequire, but modules are loaded from the given
+// file system rather than the OS file system. The paths are slash separated
+// patterns within fsys, such as "scripts/?.lua". Modules loaded from fsys
+// can't be tied to files on disk so they can't be reloaded.
+func (e *Engine) SecureRequireFS(fsys fs.FS, validPaths []string) {
+	require := func(eng *Engine) int {
+		if eng.StackSize() == 0 {
+			eng.ArgumentError(1, "expected a string, got nothing")
+		}
+		name := eng.PopString()
+		mod := strings.Replace(name, ".", "/", -1)
+		for _, path := range validPaths {
+			fpath := strings.Replace(path, "?", mod, -1)
+			src, err := fs.ReadFile(fsys, fpath)
+			if err != nil {
+				continue
+			}
+
+			fn, err := eng.load(bytes.NewReader(src), fpath)
+			if err != nil {
+				eng.RaiseError(err.Error())
+
+				return 0
+			}
+			eng.PushValue(fn)
+
+			return 1
+		}
+
+		eng.RaiseError("%q module not found", mod)
+
+		return 0
+	}
+
+	e.setRequireLoader(require)
+}
+
+// remember the file the module was loaded from so it can be reloaded
+func (e *Engine) recordModulePath(name, fpath string) {
+	abs, err := filepath.Abs(fpath)
+	if err != nil {
+		return
+	}
+	if _, ok := e.modulePaths[name]; !ok {
+		e.moduleOrder = append(e.moduleOrder, name)
+	}
+	e.modulePaths[name] = abs
+}
+
+// replace the loaders used by require with the preload loader followed by the
+// given loader
+func (e *Engine) setRequireLoader(loader ScriptFunction) {
 	tbl := e.NewTable()
 	tbl.RawSetInt(1, preloadLoader)
-	tbl.RawSetInt(2, require)
+	tbl.RawSetInt(2, loader)
 	e.GetEnviron().RawGet("package").RawSet("loaders", tbl)
 	e.GetRegistry().RawSet("_LOADERS", tbl)
 }
 
+// ReloadModule executes the file a module was loaded from again and replaces
+// its entry in package.loaded with the result. If the new module is a table with
+// an on_reload function it's called with the new and old module, giving it the
+// chance to carry state over. Only modules loaded from files by require, from
+// package.path or the paths given to SecureRequire, can be reloaded, others
+// return an error wrapping ErrNotReloadable.
+func (e *Engine) ReloadModule(name string) error {
+	fpath, ok := e.modulePaths[name]
+	if !ok {
+		return fmt.Errorf("module %q: %w", name, ErrNotReloadable)
+	}
+
+	fn, err := e.LoadFile(fpath)
+	if err != nil {
+		return err
+	}
+
+	results, err := fn.Call(1, name)
+	if err != nil {
+		return err
+	}
+
+	mod := results[0]
+	if mod.IsNil() {
+		// mirror require, which stores true for modules that return nothing
+		mod = e.True()
+	}
+
+	loaded := e.GetEnviron().RawGet("package").RawGet("loaded")
+	old := loaded.RawGet(name)
+	loaded.RawSet(name, mod)
+
+	if mod.IsTable() {
+		if hook := mod.RawGet("on_reload"); hook.IsFunction() {
+			if _, err := hook.Call(0, mod, old); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReloadFiles reloads every module that was loaded from one of the given file
+// paths, as reported by a ScriptWatcher, in the order the modules were first
+// loaded. Paths no reloadable module was loaded from (see ReloadModule), such
+// as data files or scripts that haven't been required, are skipped. Engines are
+// not safe for concurrent use, so this should be called from the goroutine that
+// owns the engine (for example by calling ScriptWatcher.Poll from the host's
+// main loop). All affected modules are reloaded even if one fails, the first
+// error is returned.
+func (e *Engine) ReloadFiles(paths []string) error {
+	changed := make(map[string]bool)
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			changed[abs] = true
+		}
+	}
+
+	var firstErr error
+	for _, name := range e.moduleOrder {
+		if !changed[e.modulePaths[name]] {
+			continue
+		}
+
+		if err := e.ReloadModule(name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
 // Call allows for calling a method by name.
 // The second parameter is the number of return values the function being
 // called should return. These values will be returned in a slice of Value
@@ -408,11 +613,13 @@ func (e *Engine) Call(name string, retCount int, params ...interface{}) ([]*Valu
 		luaParams[i] = v.lval
 	}
 
-	err := e.state.CallByParam(glua.P{
-		Fn:      e.state.GetGlobal(name),
-		NRet:    retCount,
-		Protect: true,
-	}, luaParams...)
+	err := e.callLua(func() error {
+		return e.state.CallByParam(glua.P{
+			Fn:      e.state.GetGlobal(name),
+			NRet:    retCount,
+			Protect: true,
+		}, luaParams...)
+	})
 
 	if err != nil {
 		return nil, err
@@ -430,29 +637,31 @@ func (e *Engine) Call(name string, retCount int, params ...interface{}) ([]*Valu
 // RegisterType creates a construtor with the given name that will generate the
 // given type.
 func (e *Engine) RegisterType(name string, val interface{}) {
+	e.naming.prepare(reflect.TypeOf(val))
 	cons := gluar.NewType(e.state, val)
 	e.state.SetGlobal(name, cons)
 }
 
 // RegisterClass assigns a new type, but instead of creating it via "TypeName()"
 // it provides a more OO way of creating the object "TypeName.new()" otherwise
-// it's functionally equivalent to RegisterType.
+// it's functionally equivalent to RegisterType. Scripts can extend the class
+// with "TypeName:extend()", see ClassInstance.
 func (e *Engine) RegisterClass(name string, val interface{}) {
+	e.naming.prepare(reflect.TypeOf(val))
 	cons := gluar.NewType(e.state, val)
-	table := e.NewTable()
-	table.RawSet("new", cons)
-	e.state.SetGlobal(name, table.lval)
+	table := e.newGoClass(reflect.TypeOf(val), cons)
+	e.state.SetGlobal(name, table)
 }
 
 // RegisterClassWithCtor does the same thing as RegisterClass excep the new
 // function is mapped to the constructor passed in.
 func (e *Engine) RegisterClassWithCtor(name string, typ interface{}, cons interface{}) {
+	e.naming.prepare(reflect.TypeOf(typ))
 	gluar.NewType(e.state, typ)
 	lcons := e.ValueFor(cons)
-	table := e.NewTable()
-	table.RawSet("new", lcons)
+	table := e.newGoClass(reflect.TypeOf(typ), lcons.lval)
 
-	e.state.SetGlobal(name, table.lval)
+	e.state.SetGlobal(name, table)
 }
 
 // MetatableFor returns the Lua metatable for a given type, allowing it to be
@@ -467,7 +676,10 @@ func (e *Engine) MetatableFor(goVal interface{}) *Value {
 func (e *Engine) ValueFor(val interface{}) *Value {
 	switch v := val.(type) {
 	case ScriptableObject:
-		return e.newValue(gluar.New(e.state, v.ScriptObject()))
+		obj := v.ScriptObject()
+		e.naming.prepare(reflect.TypeOf(obj))
+
+		return e.newValue(gluar.New(e.state, obj))
 	case *Value:
 		return v
 	case ScriptFunction:
@@ -475,6 +687,11 @@ func (e *Engine) ValueFor(val interface{}) *Value {
 	case func(*Engine) int:
 		return e.newValue(gluar.New(e.state, e.genScriptFunc(ScriptFunction(v))))
 	default:
+		e.naming.prepare(reflect.TypeOf(val))
+		if checked := e.enumChecked(reflect.ValueOf(val)); checked != nil {
+			return e.newValue(checked)
+		}
+
 		return e.newValue(gluar.New(e.state, val))
 	}
 }
@@ -564,6 +781,48 @@ func (e *Engine) genScriptFunc(fn ScriptFunction) *glua.LFunction {
 	return e.state.NewFunction(e.wrapScriptFunction(fn))
 }
 
+// package.path loader, pulled from yuin/gopher-lua and converted to match the
+// new engine API, recording the files modules are loaded from.
+func packagePathLoader(eng *Engine) int {
+	if eng.StackSize() == 0 {
+		eng.ArgumentError(1, "expected a string, but got nothing.")
+
+		return 0
+	}
+
+	name := eng.PopString()
+	path := eng.GetEnviron().RawGet("package").RawGet("path")
+	if !path.IsString() {
+		eng.RaiseError("package.path must be a string")
+	}
+
+	mod := strings.Replace(name, ".", string(os.PathSeparator), -1)
+	var messages []string
+	for _, pattern := range strings.Split(path.AsString(), ";") {
+		fpath := strings.Replace(pattern, "?", mod, -1)
+		if _, err := os.Stat(fpath); err != nil {
+			messages = append(messages, err.Error())
+
+			continue
+		}
+
+		fn, err := eng.LoadFile(fpath)
+		if err != nil {
+			eng.RaiseError(err.Error())
+
+			return 0
+		}
+		eng.recordModulePath(name, fpath)
+		eng.PushValue(fn)
+
+		return 1
+	}
+
+	eng.PushValue(strings.Join(messages, "\n\t"))
+
+	return 1
+}
+
 // preload loader, pulled from yuin/gopher-lua and converted to match the new
 // engine API.
 func preloadLoader(eng *Engine) int {
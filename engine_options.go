@@ -2,6 +2,14 @@
 
 package luna
 
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bbuck/luna/transformers"
+)
+
 // NamingConvention defines how Go names should be converted into Lua names when
 // passing values into the Engine.
 type NamingConvention int8
@@ -22,6 +30,13 @@ const (
 
 	// CamelCase converts Go names into camelCased only.
 	CamelCase
+
+	// KebabCase converts Go names into kebab-case only, the names have to be
+	// indexed with brackets in Lua (ex obj["hello-world"]).
+	KebabCase
+
+	// ScreamingSnakeCase converts Go names into SCREAMING_SNAKE_CASE only.
+	ScreamingSnakeCase
 )
 
 // EngineOptions allows for customization of a lua.Engine such as altering
@@ -40,11 +55,158 @@ type EngineOptions struct {
 	// MethodCasing defines how the name of a Go struct/interface method should
 	// be converted when being passed to Lua.
 	MethodCasing NamingConvention
+
+	// FieldTransformer, if set, is used to name struct fields instead of the
+	// FieldCasing convention.
+	FieldTransformer FieldTransformer
+
+	// MethodTransformer, if set, is used to name methods instead of the
+	// MethodCasing convention.
+	MethodTransformer MethodTransformer
+}
+
+// the transformers the options name fields and methods with
+func (o EngineOptions) transformers() (FieldTransformer, MethodTransformer) {
+	fields := o.FieldTransformer
+	if fields == nil {
+		fields = o.FieldCasing.getFieldTransformer()
+	}
+
+	methods := o.MethodTransformer
+	if methods == nil {
+		methods = o.MethodCasing.getMethodTransformer()
+	}
+
+	return fields, methods
+}
+
+// namingConvention is a registered naming convention
+type namingConvention struct {
+	name    string
+	fields  FieldTransformer
+	methods MethodTransformer
+}
+
+// the registered naming conventions, the built in conventions are registered
+// without transformers as they're handled directly.
+var (
+	namingConventionMutex = new(sync.RWMutex)
+	namingConventions     = []namingConvention{
+		SnakeCaseAndPascalCase: {name: "snake+pascal"},
+		SnakeCase:              {name: "snake"},
+		PascalCase:             {name: "pascal"},
+		CamelCase:              {name: "camel"},
+		KebabCase:              {name: "kebab"},
+		ScreamingSnakeCase:     {name: "screaming-snake"},
+	}
+)
+
+// RegisterNamingConvention adds a naming convention using the given
+// transformers, returning the value to use for it in EngineOptions. The
+// convention can also be found by name with LookupNamingConvention (which the
+// luna command uses for its naming flags), the name must not already be in
+// use.
+func RegisterNamingConvention(name string, fields FieldTransformer, methods MethodTransformer) (NamingConvention, error) {
+	namingConventionMutex.Lock()
+	defer namingConventionMutex.Unlock()
+
+	if fields == nil || methods == nil {
+		return 0, fmt.Errorf("naming convention %q needs both a field and method transformer", name)
+	}
+	for _, nc := range namingConventions {
+		if nc.name == name {
+			return 0, fmt.Errorf("naming convention %q is already registered", name)
+		}
+	}
+	if len(namingConventions) > 127 {
+		return 0, fmt.Errorf("too many naming conventions to register %q", name)
+	}
+
+	namingConventions = append(namingConventions, namingConvention{
+		name:    name,
+		fields:  fields,
+		methods: methods,
+	})
+
+	return NamingConvention(len(namingConventions) - 1), nil
+}
+
+// LookupNamingConvention finds a naming convention by the name it was
+// registered with, the built in conventions are named "snake", "camel",
+// "pascal", "snake+pascal", "kebab" and "screaming-snake".
+func LookupNamingConvention(name string) (NamingConvention, bool) {
+	namingConventionMutex.RLock()
+	defer namingConventionMutex.RUnlock()
+
+	for i, nc := range namingConventions {
+		if nc.name == name {
+			return NamingConvention(i), true
+		}
+	}
+
+	return 0, false
+}
+
+// NamingConventionNames returns the names of every registered naming
+// convention, sorted.
+func NamingConventionNames() []string {
+	namingConventionMutex.RLock()
+	defer namingConventionMutex.RUnlock()
+
+	names := make([]string, len(namingConventions))
+	for i, nc := range namingConventions {
+		names[i] = nc.name
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// String returns the name the convention is registered with.
+func (n NamingConvention) String() string {
+	if nc, ok := n.registered(); ok {
+		return nc.name
+	}
+
+	return fmt.Sprintf("NamingConvention(%d)", int8(n))
+}
+
+// the registration of the convention
+func (n NamingConvention) registered() (namingConvention, bool) {
+	namingConventionMutex.RLock()
+	defer namingConventionMutex.RUnlock()
+
+	if n < 0 || int(n) >= len(namingConventions) {
+		return namingConvention{}, false
+	}
+
+	return namingConventions[n], true
+}
+
+// FieldTransformer returns the transformer the convention names struct fields
+// with, for use when composing transformers.
+func (n NamingConvention) FieldTransformer() FieldTransformer {
+	if n == SnakeCaseAndPascalCase {
+		return CombineFieldTransformers(fieldToSnake, fieldToPascal)
+	}
+
+	return n.getFieldTransformer()
+}
+
+// MethodTransformer returns the transformer the convention names methods with,
+// for use when composing transformers.
+func (n NamingConvention) MethodTransformer() MethodTransformer {
+	if n == SnakeCaseAndPascalCase {
+		return CombineMethodTransformers(methodToSnake, methodToPascal)
+	}
+
+	return n.getMethodTransformer()
 }
 
 // return the associated field transformer function depending on the casing value.
 // The only special case is SnakeCaseAndPascalCase is the default behavior of
-// gopher-luar and so we return `nil` to leverage that default behavior.
+// gopher-luar and so we return `nil` to leverage that default behavior,
+// registered conventions return the transformer they were registered with.
 func (n NamingConvention) getFieldTransformer() FieldTransformer {
 	switch n {
 	case SnakeCase:
@@ -53,9 +215,15 @@ func (n NamingConvention) getFieldTransformer() FieldTransformer {
 		return fieldToPascal
 	case CamelCase:
 		return fieldToCamel
-	default:
-		return nil
+	case KebabCase:
+		return FieldTransformerFunc(transformers.StringToKebab)
+	case ScreamingSnakeCase:
+		return FieldTransformerFunc(transformers.StringToScreamingSnake)
 	}
+
+	nc, _ := n.registered()
+
+	return nc.fields
 }
 
 // similar to field transformer, but returns the method name transformer function
@@ -67,7 +235,13 @@ func (n NamingConvention) getMethodTransformer() MethodTransformer {
 		return methodToPascal
 	case CamelCase:
 		return methodToCamel
-	default:
-		return nil
+	case KebabCase:
+		return MethodTransformerFunc(transformers.StringToKebab)
+	case ScreamingSnakeCase:
+		return MethodTransformerFunc(transformers.StringToScreamingSnake)
 	}
+
+	nc, _ := n.registered()
+
+	return nc.methods
 }
@@ -0,0 +1,117 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"reflect"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+type namingPlayer struct {
+	UserName string
+}
+
+func (p *namingPlayer) Greet() string {
+	return "hello " + p.UserName
+}
+
+type namingMob struct {
+	MobName string
+}
+
+var _ = Describe("EngineOptions", func() {
+	var (
+		eng    *Engine
+		player *namingPlayer
+	)
+
+	run := func(src string) string {
+		Ω(eng.DoString(src)).Should(Succeed())
+
+		return eng.GetGlobal("result").AsString()
+	}
+
+	BeforeEach(func() {
+		player = &namingPlayer{UserName: "bob"}
+	})
+
+	AfterEach(func() {
+		eng.Close()
+	})
+
+	It("names fields and methods with the transformers given", func() {
+		upper := strings.ToUpper
+		eng = NewEngineWithOptions(EngineOptions{
+			FieldTransformer:  FieldTransformerFunc(upper),
+			MethodTransformer: MethodTransformerFunc(upper),
+		})
+		eng.SetGlobal("player", player)
+
+		Ω(run(`result = player.USERNAME .. "/" .. player:GREET()`)).Should(Equal("bob/hello bob"))
+	})
+
+	It("combines transformers", func() {
+		eng = NewEngineWithOptions(EngineOptions{
+			FieldTransformer:  CombineFieldTransformers(SnakeCase.FieldTransformer(), PascalCase.FieldTransformer()),
+			MethodTransformer: CamelCase.MethodTransformer(),
+		})
+		eng.SetGlobal("player", player)
+
+		Ω(run(`result = player.user_name .. player.UserName .. player:greet()`)).Should(Equal("bobbobhello bob"))
+	})
+
+	It("chooses transformers by type", func() {
+		eng = NewEngineWithOptions(EngineOptions{
+			FieldTransformer: FieldTransformerByType(SnakeCase.FieldTransformer(), map[reflect.Type]FieldTransformer{
+				reflect.TypeOf(namingMob{}): PascalCase.FieldTransformer(),
+			}),
+			MethodCasing: SnakeCase,
+		})
+		eng.SetGlobal("player", player)
+		eng.SetGlobal("mob", &namingMob{MobName: "orc"})
+
+		Ω(run(`result = player.user_name .. mob.MobName .. tostring(mob.mob_name)`)).Should(Equal("boborcnil"))
+	})
+
+	Describe("registered naming conventions", func() {
+		It("can be looked up by name and used as a casing", func() {
+			shout := func(s string) string { return strings.ToUpper(s) + "!" }
+			nc, err := RegisterNamingConvention("shout", FieldTransformerFunc(shout), MethodTransformerFunc(shout))
+			Ω(err).Should(BeNil())
+			Ω(nc.String()).Should(Equal("shout"))
+
+			found, ok := LookupNamingConvention("shout")
+			Ω(ok).Should(BeTrue())
+			Ω(found).Should(Equal(nc))
+			Ω(NamingConventionNames()).Should(ContainElement("shout"))
+
+			eng = NewEngineWithOptions(EngineOptions{FieldCasing: found, MethodCasing: found})
+			eng.SetGlobal("player", player)
+			Ω(run(`result = player["USERNAME!"]`)).Should(Equal("bob"))
+
+			_, err = RegisterNamingConvention("shout", FieldTransformerFunc(shout), MethodTransformerFunc(shout))
+			Ω(err).ShouldNot(BeNil())
+		})
+
+		It("includes the built in conventions", func() {
+			eng = NewEngine()
+
+			nc, ok := LookupNamingConvention("snake+pascal")
+			Ω(ok).Should(BeTrue())
+			Ω(nc).Should(Equal(SnakeCaseAndPascalCase))
+			Ω(CamelCase.String()).Should(Equal("camel"))
+		})
+
+		It("includes kebab and screaming snake case", func() {
+			eng = NewEngineWithOptions(EngineOptions{FieldCasing: KebabCase, MethodCasing: ScreamingSnakeCase})
+			eng.SetGlobal("player", player)
+
+			Ω(run(`result = player["user-name"] .. player:GREET()`)).Should(Equal("bobhello bob"))
+		})
+	})
+})
@@ -0,0 +1,280 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	glua "github.com/yuin/gopher-lua"
+	gluar "layeh.com/gopher-luar"
+)
+
+// enum is an enum registered with the engine. Members are plain numbers (or
+// strings) in Lua, as values of the enum's type are when gopher-luar passes
+// them to scripts, so they compare equal to values coming from Go.
+type enum struct {
+	name    string
+	goType  reflect.Type
+	names   []string
+	values  map[string]glua.LValue
+	byValue map[glua.LValue]string
+	table   *glua.LTable
+}
+
+// RegisterEnum assigns a read-only table with the given name mapping the
+// names of the members to their values, which must all be integers or strings
+// of the same named Go type. Each type can only be registered once, as
+// functions taking it are checked for the enum, and string values can't be the
+// name of another member. Indexing the table with a value returns the name of
+// its member, so DamageType[DamageType.Fire] is "Fire".
+//
+// The table is empty and serves the members through a metatable scripts can't
+// replace, so assigning to it raises an error. Lua 5.1 has no __pairs, so pairs
+// and next see an empty table and rawset and rawget go around the members. In
+// place of pairs, calling the table returns an iterator over the names and
+// values of the members in order of their values
+// (for name, value in DamageType() do ... end) and # counts them.
+//
+// Go functions passed to the engine after the enum is registered that take
+// its type only accept the values of members (or their names) for it, raising
+// an error for any other value instead of converting it. Functions passed
+// before it is registered, and methods of Go values, aren't checked.
+func (e *Engine) RegisterEnum(name string, members map[string]interface{}) error {
+	if len(members) == 0 {
+		return fmt.Errorf("enum %s has no members", name)
+	}
+
+	en := &enum{
+		name:    name,
+		values:  make(map[string]glua.LValue),
+		byValue: make(map[glua.LValue]string),
+	}
+	for member, val := range members {
+		t := reflect.TypeOf(val)
+		switch {
+		case t == nil:
+			return fmt.Errorf("member %s of enum %s is nil", member, name)
+		case t.PkgPath() == "":
+			return fmt.Errorf("members of enum %s are %s, enums need a named type of their own", name, t)
+		case en.goType == nil:
+			en.goType = t
+		case en.goType != t:
+			return fmt.Errorf("members of enum %s are %s and %s, they must have the same type", name, en.goType, t)
+		}
+
+		lval := gluar.New(e.state, val)
+		if lval.Type() != glua.LTNumber && lval.Type() != glua.LTString {
+			return fmt.Errorf("enum %s has members of type %s, expected integers or strings", name, t)
+		}
+		if other, ok := en.byValue[lval]; ok {
+			return fmt.Errorf("members %s and %s of enum %s have the same value", other, member, name)
+		}
+
+		en.names = append(en.names, member)
+		en.values[member] = lval
+		en.byValue[lval] = member
+	}
+	for member, lval := range en.values {
+		if str, ok := lval.(glua.LString); ok && string(str) != member {
+			if _, ok := en.values[string(str)]; ok {
+				return fmt.Errorf("member %s of enum %s has the name of member %s as its value", member, name, str)
+			}
+		}
+	}
+	if other, ok := e.enums[en.goType]; ok {
+		return fmt.Errorf("%s is already registered as enum %s", en.goType, other.name)
+	}
+
+	sort.Slice(en.names, func(i, j int) bool {
+		a, b := en.values[en.names[i]], en.values[en.names[j]]
+		if an, ok := a.(glua.LNumber); ok {
+			return an < b.(glua.LNumber)
+		}
+
+		return a.(glua.LString) < b.(glua.LString)
+	})
+
+	en.table = e.newEnumTable(en)
+	if e.enums == nil {
+		e.enums = make(map[reflect.Type]*enum)
+	}
+	e.enums[en.goType] = en
+	e.state.SetGlobal(name, en.table)
+
+	return nil
+}
+
+// RegisterEnumValues registers an enum like RegisterEnum with the values
+// given, naming the members with their String method (as the stringer tool
+// generates for iota constants).
+func (e *Engine) RegisterEnumValues(name string, values ...interface{}) error {
+	members := make(map[string]interface{}, len(values))
+	for _, val := range values {
+		str, ok := val.(fmt.Stringer)
+		if !ok {
+			return fmt.Errorf("value %v of enum %s has no String method to name it", val, name)
+		}
+		members[str.String()] = val
+	}
+
+	return e.RegisterEnum(name, members)
+}
+
+// the read-only table for the enum, it's empty and looks up members through
+// its metatable so scripts can't change them
+func (e *Engine) newEnumTable(en *enum) *glua.LTable {
+	l := e.state
+	tbl := l.NewTable()
+	meta := l.NewTable()
+	meta.RawSetString("__index", l.NewFunction(func(l *glua.LState) int {
+		key := l.Get(2)
+		if name, ok := key.(glua.LString); ok {
+			if val, ok := en.values[string(name)]; ok {
+				l.Push(val)
+
+				return 1
+			}
+		}
+		if name, ok := en.byValue[key]; ok {
+			l.Push(glua.LString(name))
+
+			return 1
+		}
+
+		return 0
+	}))
+	meta.RawSetString("__newindex", l.NewFunction(func(l *glua.LState) int {
+		l.RaiseError("cannot assign to %s of enum %s, enums are read-only", l.Get(2).String(), en.name)
+
+		return 0
+	}))
+	meta.RawSetString("__len", l.NewFunction(func(l *glua.LState) int {
+		l.Push(glua.LNumber(len(en.names)))
+
+		return 1
+	}))
+	meta.RawSetString("__call", l.NewFunction(func(l *glua.LState) int {
+		i := 0
+		l.Push(l.NewFunction(func(l *glua.LState) int {
+			if i >= len(en.names) {
+				return 0
+			}
+			name := en.names[i]
+			i++
+			l.Push(glua.LString(name))
+			l.Push(en.values[name])
+
+			return 2
+		}))
+
+		return 1
+	}))
+	meta.RawSetString("__metatable", glua.LString("enum "+en.name))
+	l.SetMetatable(tbl, meta)
+
+	return tbl
+}
+
+// the value of the member given as a value or name
+func (en *enum) member(val glua.LValue) (glua.LValue, bool) {
+	if _, ok := en.byValue[val]; ok {
+		return val, true
+	}
+	if name, ok := val.(glua.LString); ok {
+		if member, ok := en.values[string(name)]; ok {
+			return member, true
+		}
+	}
+
+	return nil, false
+}
+
+// inspect the members in order of their values
+func (en *enum) inspect() string {
+	members := make([]string, len(en.names))
+	for i, name := range en.names {
+		members[i] = fmt.Sprintf("%s = %s", name, en.inspectValue(en.values[name]))
+	}
+
+	return en.name + "{" + strings.Join(members, ", ") + "}"
+}
+
+// the value as it would be written in Lua
+func (en *enum) inspectValue(val glua.LValue) string {
+	if str, ok := val.(glua.LString); ok {
+		return fmt.Sprintf("%q", string(str))
+	}
+
+	return val.String()
+}
+
+// the enum whose table the value is
+func (e *Engine) enumFor(val glua.LValue) (*enum, bool) {
+	if e == nil {
+		return nil, false
+	}
+	for _, en := range e.enums {
+		if en.table == val {
+			return en, true
+		}
+	}
+
+	return nil, false
+}
+
+// enumChecked wraps the Go function so arguments for enum parameters are
+// checked to be members of the enum, returning nil if it takes no enums. Only
+// the enums registered so far are checked.
+func (e *Engine) enumChecked(fn reflect.Value) glua.LValue {
+	if len(e.enums) == 0 || fn.Kind() != reflect.Func || fn.IsNil() {
+		return nil
+	}
+
+	t := fn.Type()
+	params := make(map[int]*enum)
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = in.Elem()
+		}
+		if en, ok := e.enums[in]; ok {
+			params[i] = en
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+
+	inner := gluar.New(e.state, fn.Interface())
+
+	return e.state.NewFunction(func(l *glua.LState) int {
+		top := l.GetTop()
+		for i := 1; i <= top; i++ {
+			param := i - 1
+			if t.IsVariadic() && param >= t.NumIn()-1 {
+				param = t.NumIn() - 1
+			}
+			en, ok := params[param]
+			if !ok {
+				continue
+			}
+
+			val, ok := en.member(l.Get(i))
+			if !ok {
+				l.ArgError(i, fmt.Sprintf("%s expected, got %s", en.name, l.Get(i).String()))
+			}
+			l.Replace(i, val)
+		}
+
+		l.Push(inner)
+		for i := 1; i <= top; i++ {
+			l.Push(l.Get(i))
+		}
+		l.Call(top, glua.MultRet)
+
+		return l.GetTop() - top
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+type enumDamageType int
+
+const (
+	enumFire enumDamageType = iota
+	enumIce
+	enumPoison
+)
+
+func (d enumDamageType) String() string {
+	return [...]string{"Fire", "Ice", "Poison"}[d]
+}
+
+type enumDirection string
+
+type enumLevel int
+
+type enumBadge struct{}
+
+type enumGrade string
+
+var _ = Describe("Enums", func() {
+	var eng *Engine
+
+	run := func(src string) string {
+		Ω(eng.DoString(src)).Should(Succeed())
+
+		return eng.GetGlobal("result").AsString()
+	}
+
+	BeforeEach(func() {
+		eng = NewEngine()
+		Ω(eng.RegisterEnumValues("DamageType", enumFire, enumIce, enumPoison)).Should(Succeed())
+		Ω(eng.RegisterEnum("Direction", map[string]interface{}{
+			"North": enumDirection("n"),
+			"South": enumDirection("s"),
+		})).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		eng.Close()
+	})
+
+	It("looks up members by name and value", func() {
+		Ω(run(`result = DamageType.Ice .. "/" .. DamageType[2] .. "/" .. Direction.South .. "/" .. Direction.n`)).Should(Equal("1/Poison/s/North"))
+		Ω(run(`result = tostring(DamageType.Lightning)`)).Should(Equal("nil"))
+	})
+
+	It("compares equal to values from Go", func() {
+		eng.SetGlobal("weakness", func() enumDamageType { return enumIce })
+
+		Ω(run(`result = tostring(weakness() == DamageType.Ice)`)).Should(Equal("true"))
+	})
+
+	It("can't be changed by scripts", func() {
+		err := eng.DoString(`DamageType.Fire = 10`)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("read-only"))
+
+		Ω(eng.DoString(`setmetatable(DamageType, {})`)).ShouldNot(Succeed())
+		Ω(run(`result = DamageType.Fire .. "/" .. type(DamageType)`)).Should(Equal("0/table"))
+	})
+
+	It("iterates and counts the members in order", func() {
+		Ω(run(`
+			result = ""
+			for name, value in DamageType() do
+				result = result .. name .. "=" .. value .. " "
+			end
+			result = result .. #DamageType
+		`)).Should(Equal("Fire=0 Ice=1 Poison=2 3"))
+	})
+
+	It("checks arguments passed to Go functions", func() {
+		var got []enumDamageType
+		eng.SetGlobal("hit", func(amount int, types ...enumDamageType) {
+			got = append(got, types...)
+		})
+
+		Ω(eng.DoString(`hit(10, DamageType.Poison, "Ice")`)).Should(Succeed())
+		Ω(got).Should(Equal([]enumDamageType{enumPoison, enumIce}))
+
+		err := eng.DoString(`hit(10, 7)`)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("DamageType expected, got 7"))
+
+		Ω(eng.DoString(`hit(10, Direction.North)`)).ShouldNot(Succeed())
+	})
+
+	It("inspects the members in order", func() {
+		Ω(eng.GetGlobal("DamageType").Inspect("")).Should(Equal("DamageType{Fire = 0, Ice = 1, Poison = 2}"))
+		Ω(NewInspector(false).Inspect(eng.GetGlobal("Direction"), "")).Should(Equal(`Direction{North = "n", South = "s"}`))
+	})
+
+	It("rejects invalid members", func() {
+		Ω(eng.RegisterEnum("Empty", map[string]interface{}{})).ShouldNot(Succeed())
+		Ω(eng.RegisterEnum("Mixed", map[string]interface{}{"A": enumFire, "B": 1})).ShouldNot(Succeed())
+		Ω(eng.RegisterEnum("Same", map[string]interface{}{"A": enumLevel(1), "B": enumLevel(1)})).ShouldNot(Succeed())
+		Ω(eng.RegisterEnum("Structs", map[string]interface{}{"A": enumBadge{}})).ShouldNot(Succeed())
+		Ω(eng.RegisterEnum("Names", map[string]interface{}{"A": enumGrade("B"), "B": enumGrade("x")})).ShouldNot(Succeed())
+		Ω(eng.RegisterEnum("Own", map[string]interface{}{"A": enumGrade("A"), "B": enumGrade("x")})).Should(Succeed())
+	})
+
+	It("requires a named type that isn't already registered", func() {
+		Ω(eng.RegisterEnum("Level", map[string]interface{}{"Low": 1, "High": 2})).ShouldNot(Succeed())
+		Ω(eng.RegisterEnum("Element", map[string]interface{}{"Fire": enumFire})).ShouldNot(Succeed())
+
+		eng.SetGlobal("double", func(n int) int { return n * 2 })
+		Ω(run(`result = double(21)`)).Should(Equal("42"))
+	})
+})
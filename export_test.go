@@ -0,0 +1,16 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+// CompactHistory exposes compactHistory to the specs.
+var CompactHistory = compactHistory
+
+// InstrumentedChunks returns the number of instrumented chunks the engine is
+// keeping track of.
+func (e *Engine) InstrumentedChunks() int {
+	if e.instrumentation == nil {
+		return 0
+	}
+
+	return len(e.instrumentation.chunks)
+}
@@ -3,5 +3,14 @@ local function fib(n)
     return fib(n - 2) + fib(n - 1)
 end
 
-print(fib(35))
+describe("fib", function()
+    it("returns n for the first two numbers", function()
+        expect(fib(0)).to_equal(0)
+        expect(fib(1)).to_equal(1)
+    end)
 
+    it("sums the previous two numbers", function()
+        expect(fib(10)).to_equal(55)
+        expect(fib(20)).to_equal(6765)
+    end)
+end)
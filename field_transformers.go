@@ -4,6 +4,8 @@ package luna
 
 import (
 	"reflect"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/bbuck/luna/transformers"
 )
@@ -24,6 +26,20 @@ func fieldToCamel(t reflect.Type, s reflect.StructField) []string {
 	return []string{transformers.StringToCamel(s.Name)}
 }
 
+// the names gopher-luar gives fields by default, the name from a luar tag or
+// the Go name along with it beginning in lower case
+func luarFieldNames(t reflect.Type, f reflect.StructField) []string {
+	tag := f.Tag.Get("luar")
+	if tag == "-" {
+		return nil
+	}
+	if tag != "" {
+		return []string{tag}
+	}
+
+	return []string{f.Name, lowerFirst(f.Name)}
+}
+
 // MethodTransformer is a function that takes information about a struct method
 // and returns an array of names to use for this method in Lua.
 type MethodTransformer func(reflect.Type, reflect.Method) []string
@@ -39,3 +55,113 @@ func methodToPascal(t reflect.Type, s reflect.Method) []string {
 func methodToCamel(t reflect.Type, s reflect.Method) []string {
 	return []string{transformers.StringToCamel(s.Name)}
 }
+
+// the names gopher-luar gives methods by default
+func luarMethodNames(t reflect.Type, m reflect.Method) []string {
+	return []string{m.Name, lowerFirst(m.Name)}
+}
+
+// the name with its first letter in lower case
+func lowerFirst(name string) string {
+	first, n := utf8.DecodeRuneInString(name)
+	if n == 0 {
+		return name
+	}
+
+	return string(unicode.ToLower(first)) + name[n:]
+}
+
+// FieldTransformerFunc builds a field transformer that names fields by
+// converting their Go name with the function.
+func FieldTransformerFunc(convert func(string) string) FieldTransformer {
+	return func(_ reflect.Type, f reflect.StructField) []string {
+		return []string{convert(f.Name)}
+	}
+}
+
+// MethodTransformerFunc builds a method transformer that names methods by
+// converting their Go name with the function.
+func MethodTransformerFunc(convert func(string) string) MethodTransformer {
+	return func(_ reflect.Type, m reflect.Method) []string {
+		return []string{convert(m.Name)}
+	}
+}
+
+// CombineFieldTransformers builds a field transformer that gives fields the
+// names from each of the transformers, in order and without duplicates. For
+// example combining SnakeCase and PascalCase transformers makes fields
+// available by both names.
+func CombineFieldTransformers(fts ...FieldTransformer) FieldTransformer {
+	return func(t reflect.Type, f reflect.StructField) []string {
+		var names []string
+		for _, ft := range fts {
+			names = appendUniqueNames(names, ft(t, f))
+		}
+
+		return names
+	}
+}
+
+// CombineMethodTransformers builds a method transformer that gives methods the
+// names from each of the transformers, in order and without duplicates.
+func CombineMethodTransformers(mts ...MethodTransformer) MethodTransformer {
+	return func(t reflect.Type, m reflect.Method) []string {
+		var names []string
+		for _, mt := range mts {
+			names = appendUniqueNames(names, mt(t, m))
+		}
+
+		return names
+	}
+}
+
+// FieldTransformerByType builds a field transformer that uses the transformer
+// given for the struct type, falling back to the default for other types.
+// Pointer types use the transformer of the type they point to.
+func FieldTransformerByType(def FieldTransformer, types map[reflect.Type]FieldTransformer) FieldTransformer {
+	return func(t reflect.Type, f reflect.StructField) []string {
+		if ft, ok := types[t]; ok {
+			return ft(t, f)
+		}
+		if t.Kind() == reflect.Ptr {
+			if ft, ok := types[t.Elem()]; ok {
+				return ft(t, f)
+			}
+		}
+
+		return def(t, f)
+	}
+}
+
+// MethodTransformerByType builds a method transformer that uses the
+// transformer given for the type, falling back to the default for other types.
+// Pointer types use the transformer of the type they point to.
+func MethodTransformerByType(def MethodTransformer, types map[reflect.Type]MethodTransformer) MethodTransformer {
+	return func(t reflect.Type, m reflect.Method) []string {
+		if mt, ok := types[t]; ok {
+			return mt(t, m)
+		}
+		if t.Kind() == reflect.Ptr {
+			if mt, ok := types[t.Elem()]; ok {
+				return mt(t, m)
+			}
+		}
+
+		return def(t, m)
+	}
+}
+
+// add the names that aren't already in the list
+func appendUniqueNames(names, add []string) []string {
+outer:
+	for _, name := range add {
+		for _, existing := range names {
+			if existing == name {
+				continue outer
+			}
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
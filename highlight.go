@@ -0,0 +1,239 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"bytes"
+	"strings"
+)
+
+// LuaTokenKind identifies the kind of a LuaToken.
+type LuaTokenKind int
+
+// The kinds of tokens produced by TokenizeLua.
+const (
+	TokenWhitespace LuaTokenKind = iota
+	TokenName
+	TokenKeyword
+	TokenConstant
+	TokenNumber
+	TokenString
+	TokenComment
+	TokenOperator
+)
+
+// LuaToken is a span of Lua source code.
+type LuaToken struct {
+	Kind LuaTokenKind
+	Text string
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "for": true, "function": true, "goto": true, "if": true,
+	"in": true, "local": true, "not": true, "or": true, "repeat": true,
+	"return": true, "then": true, "until": true, "while": true,
+}
+
+// TokenizeLua splits Lua source into tokens for highlighting. The source does
+// not need to be valid Lua, unterminated strings and comments run to the end
+// of the input so partially entered code can be tokenized. Joining the text of
+// the tokens reproduces the source.
+func TokenizeLua(src string) []LuaToken {
+	tokens := make([]LuaToken, 0)
+	for i := 0; i < len(src); {
+		kind, end := scanLuaToken(src, i)
+		tokens = append(tokens, LuaToken{Kind: kind, Text: src[i:end]})
+		i = end
+	}
+
+	return tokens
+}
+
+// scan the token beginning at start, returning its kind and the index just
+// past its end
+func scanLuaToken(src string, start int) (LuaTokenKind, int) {
+	c := src[start]
+	switch {
+	case isLuaSpace(c):
+		end := start
+		for end < len(src) && isLuaSpace(src[end]) {
+			end++
+		}
+
+		return TokenWhitespace, end
+	case isLuaNameStart(c):
+		end := start
+		for end < len(src) && (isLuaNameStart(src[end]) || isLuaDigit(src[end])) {
+			end++
+		}
+
+		word := src[start:end]
+		switch {
+		case luaKeywords[word]:
+			return TokenKeyword, end
+		case word == "true" || word == "false" || word == "nil":
+			return TokenConstant, end
+		}
+
+		return TokenName, end
+	case isLuaDigit(c) || (c == '.' && start+1 < len(src) && isLuaDigit(src[start+1])):
+		return TokenNumber, scanLuaNumber(src, start)
+	case c == '"' || c == '\'':
+		return TokenString, scanLuaQuoted(src, start)
+	case c == '[':
+		if end, ok := scanLuaLongBracket(src, start); ok {
+			return TokenString, end
+		}
+	case strings.HasPrefix(src[start:], "--"):
+		if end, ok := scanLuaLongBracket(src, start+2); ok {
+			return TokenComment, end
+		}
+
+		end := strings.IndexByte(src[start:], '\n')
+		if end < 0 {
+			return TokenComment, len(src)
+		}
+
+		return TokenComment, start + end
+	}
+
+	for _, op := range []string{"...", "..", "==", "~=", "<=", ">=", "::"} {
+		if strings.HasPrefix(src[start:], op) {
+			return TokenOperator, start + len(op)
+		}
+	}
+
+	return TokenOperator, start + 1
+}
+
+// scan a number, including hex numbers and exponents
+func scanLuaNumber(src string, start int) int {
+	end := start
+	exponents := "eE"
+	if strings.HasPrefix(src[start:], "0x") || strings.HasPrefix(src[start:], "0X") {
+		end += 2
+		exponents = "pP"
+	}
+
+	for end < len(src) {
+		c := src[end]
+		switch {
+		case strings.IndexByte(exponents, c) >= 0:
+			end++
+			if end < len(src) && (src[end] == '+' || src[end] == '-') {
+				end++
+			}
+		case c == '.' && strings.HasPrefix(src[end:], ".."):
+			// concatenation following a number
+			return end
+		case isLuaDigit(c) || c == '.' || isLuaNameStart(c):
+			end++
+		default:
+			return end
+		}
+	}
+
+	return end
+}
+
+// scan a quoted string, honoring escapes
+func scanLuaQuoted(src string, start int) int {
+	quote := src[start]
+	for end := start + 1; end < len(src); end++ {
+		switch src[end] {
+		case '\\':
+			end++
+		case quote:
+			return end + 1
+		case '\n':
+			return end
+		}
+	}
+
+	return len(src)
+}
+
+// scan a long bracket such as [[...]] or [==[...]==], returning false if the
+// source at start isn't the opening of a long bracket
+func scanLuaLongBracket(src string, start int) (int, bool) {
+	if start >= len(src) || src[start] != '[' {
+		return 0, false
+	}
+
+	level := 0
+	for start+1+level < len(src) && src[start+1+level] == '=' {
+		level++
+	}
+	open := start + 1 + level
+	if open >= len(src) || src[open] != '[' {
+		return 0, false
+	}
+
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(src[open+1:], closing)
+	if end < 0 {
+		return len(src), true
+	}
+
+	return open + 1 + end + len(closing), true
+}
+
+func isLuaSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isLuaNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isLuaDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// HighlightLua colors the Lua source with the theme, if theme is nil then
+// DefaultTheme is used.
+func HighlightLua(src string, theme *Theme) string {
+	if theme == nil {
+		theme = &DefaultTheme
+	}
+
+	buf := new(bytes.Buffer)
+	for _, token := range TokenizeLua(src) {
+		var color string
+		switch token.Kind {
+		case TokenKeyword:
+			color = theme.Keyword
+		case TokenConstant:
+			color = theme.Constant
+		case TokenNumber:
+			color = theme.Number
+		case TokenString:
+			color = theme.String
+		case TokenComment:
+			color = theme.Comment
+		}
+
+		if len(color) == 0 {
+			buf.WriteString(token.Text)
+
+			continue
+		}
+		buf.WriteString(color)
+		buf.WriteString(token.Text)
+		buf.WriteString(ansiReset)
+	}
+
+	return buf.String()
+}
+
+// luaPainter highlights the line being edited in the terminal, it implements
+// readline.Painter.
+type luaPainter struct {
+	theme *Theme
+}
+
+// Paint implements readline.Painter.
+func (lp *luaPainter) Paint(line []rune, _ int) []rune {
+	return []rune(HighlightLua(string(line), lp.theme))
+}
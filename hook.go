@@ -0,0 +1,151 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+// HookMask selects the events a hook set with SetHook is called for.
+type HookMask uint8
+
+// The events a hook can be called for.
+const (
+	// HookCall is reported when a Lua function begins.
+	HookCall HookMask = 1 << iota
+
+	// HookReturn is reported when a Lua function returns, functions unwound by
+	// an error don't report returning.
+	HookReturn
+
+	// HookLine is reported before the first statement on a line runs.
+	HookLine
+
+	// HookError is reported when a call from Go into Lua fails.
+	HookError
+
+	// HookAll selects every event.
+	HookAll = HookCall | HookReturn | HookLine | HookError
+)
+
+// String returns the name of the event.
+func (m HookMask) String() string {
+	switch m {
+	case HookCall:
+		return "call"
+	case HookReturn:
+		return "return"
+	case HookLine:
+		return "line"
+	case HookError:
+		return "error"
+	}
+
+	return "mask"
+}
+
+// HookEvent describes something that happened while Lua was running.
+type HookEvent struct {
+	// Type is the kind of event, a single bit of the mask.
+	Type HookMask
+
+	// Name is the name of the function the event happened in, the main chunk
+	// of a script is named "main chunk".
+	Name string
+
+	// Chunk is the name of the chunk the function was loaded from, such as
+	// the path of a file.
+	Chunk string
+
+	// Line is the line the event happened on, for calls and returns it's the
+	// line the function was defined on and for errors it's the last line that
+	// was reported before the error.
+	Line int
+
+	// Depth is the number of frames on the Lua call stack (Go functions
+	// included) counting the function the event happened in, for errors it's
+	// the number of frames left once the error was caught.
+	Depth int
+
+	// Err is the error for HookError events.
+	Err error
+}
+
+// SetHook calls fn for each of the events selected by the mask that happen in
+// chunks loaded from now on, replacing any hook that was set before. Setting a
+// hook with an empty mask (or a nil function) removes it. Events are reported
+// by instrumenting chunks as they're compiled so chunks loaded while no hook
+// is set have no overhead, and those loaded before the hook was set don't
+// report events.
+//
+// The hook runs on the goroutine running the script. To stop the script from
+// a hook, to enforce a budget for example, cancel the engine's context (see
+// SetContext).
+func (e *Engine) SetHook(mask HookMask, fn func(HookEvent)) {
+	if e.hook != nil {
+		e.removeHookListener(e.hook)
+		e.hook = nil
+	}
+	if mask == 0 || fn == nil {
+		return
+	}
+
+	e.hook = &userHook{mask: mask, fn: fn}
+	e.addHookListener(e.hook)
+}
+
+// userHook forwards the events selected by the mask to a hook function.
+type userHook struct {
+	mask HookMask
+	fn   func(HookEvent)
+
+	// the last line reported, errors are attributed to it
+	last HookEvent
+}
+
+func (h *userHook) chunkInstrumented(*instrumentedChunk) {}
+
+func (h *userHook) chunkReleased(int) {}
+
+func (h *userHook) hook(_ *Engine, ev *hookEvent) {
+	var typ HookMask
+	fnIndex := ev.index
+	switch ev.kind {
+	case hookLine:
+		typ = HookLine
+		fnIndex = ev.chunk.functionAt(ev.index)
+	case hookCall:
+		typ = HookCall
+	case hookReturn:
+		typ = HookReturn
+	default:
+		return
+	}
+
+	if typ == HookLine && h.mask&HookError != 0 {
+		h.last = HookEvent{
+			Name:  ev.chunk.functions[fnIndex].name,
+			Chunk: ev.chunk.name,
+			Line:  ev.index,
+		}
+	}
+	if h.mask&typ == 0 {
+		return
+	}
+
+	h.fn(HookEvent{
+		Type:  typ,
+		Name:  ev.chunk.functions[fnIndex].name,
+		Chunk: ev.chunk.name,
+		Line:  ev.line(),
+		Depth: ev.depth(),
+	})
+}
+
+func (h *userHook) luaCallFinished(_ *Engine, depth int, err error) {
+	if err == nil || h.mask&HookError == 0 {
+		return
+	}
+
+	ev := h.last
+	ev.Type = HookError
+	ev.Depth = depth
+	ev.Err = err
+	h.fn(ev)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"context"
+	"runtime"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("Hooks", func() {
+	var (
+		eng    *Engine
+		events []HookEvent
+	)
+
+	record := func(ev HookEvent) {
+		events = append(events, ev)
+	}
+
+	BeforeEach(func() {
+		eng = NewEngine()
+		events = nil
+	})
+
+	AfterEach(func() {
+		eng.Close()
+	})
+
+	It("reports calls and returns with the function, chunk, line and depth", func() {
+		eng.SetHook(HookCall|HookReturn, record)
+
+		Ω(eng.DoString(`local function add(a, b)
+  return a + b
+end
+add(1, 2)`)).Should(Succeed())
+
+		Ω(events).Should(HaveLen(4))
+		Ω(events[0]).Should(Equal(HookEvent{Type: HookCall, Name: "main chunk", Chunk: "<string>", Line: 0, Depth: 1}))
+		Ω(events[1]).Should(Equal(HookEvent{Type: HookCall, Name: "add", Chunk: "<string>", Line: 1, Depth: 2}))
+		Ω(events[2]).Should(Equal(HookEvent{Type: HookReturn, Name: "add", Chunk: "<string>", Line: 1, Depth: 2}))
+		Ω(events[3].Type).Should(Equal(HookReturn))
+		Ω(events[3].Name).Should(Equal("main chunk"))
+	})
+
+	It("reports lines in the function running them", func() {
+		eng.SetHook(HookLine, record)
+
+		Ω(eng.DoString(`local function double(n)
+  return n * 2
+end
+local x = double(2)`)).Should(Succeed())
+
+		lines := make([]int, len(events))
+		for i, ev := range events {
+			lines[i] = ev.Line
+		}
+		Ω(lines).Should(Equal([]int{1, 4, 2}))
+		Ω(events[2].Name).Should(Equal("double"))
+		Ω(events[2].Depth).Should(Equal(2))
+	})
+
+	It("reports errors with the line they happened after", func() {
+		eng.SetHook(HookError, record)
+
+		err := eng.DoString(`local x = 1
+error("boom")`)
+		Ω(err).ShouldNot(BeNil())
+
+		Ω(events).Should(HaveLen(1))
+		Ω(events[0].Type).Should(Equal(HookError))
+		Ω(events[0].Line).Should(Equal(2))
+		Ω(events[0].Depth).Should(Equal(0))
+		Ω(events[0].Err).Should(Equal(err))
+	})
+
+	It("doesn't report chunks loaded once the hook is removed", func() {
+		eng.SetHook(HookAll, record)
+		eng.SetHook(0, nil)
+
+		Ω(eng.DoString(`local x = 1`)).Should(Succeed())
+		Ω(events).Should(BeEmpty())
+	})
+
+	It("can't be changed by scripts", func() {
+		eng.SetHook(HookCall, record)
+
+		Ω(eng.DoString(`
+			assert(_G.__luna_hook == nil)
+			_G.__luna_hook = function() error("replaced") end
+		`)).Should(Succeed())
+		events = nil
+
+		Ω(eng.DoString(`local function f() end f()`)).Should(Succeed())
+		Ω(events).Should(HaveLen(2))
+	})
+
+	It("forgets chunks once their functions are collected", func() {
+		eng.SetHook(HookLine, func(HookEvent) {})
+		for i := 0; i < 100; i++ {
+			Ω(eng.DoString(`local x = 1`)).Should(Succeed())
+		}
+
+		Eventually(func() int {
+			runtime.GC()
+			Ω(eng.DoString(`local y = 2`)).Should(Succeed())
+
+			return eng.InstrumentedChunks()
+		}).Should(BeNumerically("<", 10))
+	})
+
+	It("can stop a script that exceeds a budget", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		eng.SetContext(ctx)
+
+		lines := 0
+		eng.SetHook(HookLine, func(HookEvent) {
+			lines++
+			if lines > 100 {
+				cancel()
+			}
+		})
+
+		Ω(eng.DoString(`while true do
+  local x = 1
+end`)).ShouldNot(Succeed())
+		Ω(lines).Should(BeNumerically("<", 110))
+	})
+})
@@ -0,0 +1,326 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	glua "github.com/yuin/gopher-lua"
+	gluar "layeh.com/gopher-luar"
+)
+
+// Go can't create types with methods at runtime, so implementing an interface
+// with a Lua table needs an adapter: a struct with a function field for each
+// method, and methods calling them. For example:
+//
+//	type AIController interface {
+//		Think(ctx context.Context) Action
+//	}
+//
+//	type aiControllerAdapter struct {
+//		ThinkFunc func(context.Context) Action
+//	}
+//
+//	func (a *aiControllerAdapter) Think(ctx context.Context) Action {
+//		return a.ThinkFunc(ctx)
+//	}
+//
+//	func init() {
+//		luna.RegisterAdapter(&aiControllerAdapter{})
+//	}
+//
+// With the adapter registered, Implement fills the function fields of a new
+// adapter with functions calling the Lua table's functions:
+//
+//	var ai AIController
+//	err := eng.Implement(eng.GetGlobal("brain"), &ai)
+
+var (
+	adapterMutex = new(sync.RWMutex)
+	adapters     []reflect.Type
+)
+
+// RegisterAdapter registers an adapter Implement uses for the interfaces the
+// adapter implements. Adapters are pointers to structs whose function fields
+// are named after the methods they implement, optionally with a "Func"
+// suffix. When more than one adapter implements an interface, the first one
+// registered is used.
+func RegisterAdapter(adapter interface{}) error {
+	t := reflect.TypeOf(adapter)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("adapter %T is not a pointer to a struct", adapter)
+	}
+	if len(funcFields(t.Elem())) == 0 {
+		return fmt.Errorf("adapter %T has no function fields", adapter)
+	}
+
+	adapterMutex.Lock()
+	defer adapterMutex.Unlock()
+
+	for _, registered := range adapters {
+		if registered == t {
+			return fmt.Errorf("adapter %T is already registered", adapter)
+		}
+	}
+	adapters = append(adapters, t)
+
+	return nil
+}
+
+// the first adapter registered that implements the interface
+func adapterFor(iface reflect.Type) (reflect.Type, bool) {
+	adapterMutex.RLock()
+	defer adapterMutex.RUnlock()
+
+	for _, t := range adapters {
+		if t.Implements(iface) {
+			return t, true
+		}
+	}
+
+	return nil, false
+}
+
+// the exported function fields of the struct type
+func funcFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath == "" && f.Type.Kind() == reflect.Func {
+			fields = append(fields, f)
+		}
+	}
+
+	return fields
+}
+
+// Implement sets the value target points to so its methods call the
+// functions of the Lua table, with self as the table. The target can point to
+// an interface, implemented with the adapter registered for it (see
+// RegisterAdapter), or to a struct whose function fields are set directly.
+//
+// Functions are looked up by the name the engine gives the method (so
+// "on_spawn" for OnSpawn with SnakeCase methods) or the Go name, and an error is
+// returned if the table is missing any. Arguments are passed as they are to
+// Go functions called from Lua, and results are converted like the arguments
+// of Go functions called from Lua are. For methods with a trailing error
+// result the Lua function can return an extra error value (following the
+// "return nil, message" convention), and errors raised by the function are
+// returned through it, other methods panic with the error raised.
+//
+// The methods run Lua on the engine, so they can only be called when the
+// engine could be.
+func (e *Engine) Implement(tbl *Value, target interface{}) error {
+	if tbl == nil || (!tbl.IsTable() && !tbl.IsUserData()) {
+		return errors.New("can only implement methods with a table")
+	}
+
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("target %T is not a pointer", target)
+	}
+
+	elem := ptr.Elem()
+	switch elem.Kind() {
+	case reflect.Interface:
+		adapter, ok := adapterFor(elem.Type())
+		if !ok {
+			return fmt.Errorf("no adapter is registered for %s, see RegisterAdapter", elem.Type())
+		}
+		val := reflect.New(adapter.Elem())
+		if err := e.implementFuncs(tbl, elem.Type(), val.Elem()); err != nil {
+			return err
+		}
+		elem.Set(val)
+	case reflect.Struct:
+		return e.implementFuncs(tbl, elem.Type(), elem)
+	default:
+		return fmt.Errorf("target %T is not a pointer to an interface or struct", target)
+	}
+
+	return nil
+}
+
+// set the function fields of the struct to call the table's functions, the
+// type is the type being implemented and is used to name the methods
+func (e *Engine) implementFuncs(tbl *Value, t reflect.Type, val reflect.Value) error {
+	var missing []string
+	for _, f := range funcFields(val.Type()) {
+		method := reflect.Method{
+			Name: strings.TrimSuffix(f.Name, "Func"),
+			Type: f.Type,
+		}
+		names := append(append([]string{}, e.naming.methodNames(t, method)...), method.Name)
+
+		name, ok := e.tableFunction(tbl, names)
+		if !ok {
+			missing = append(missing, names[0])
+
+			continue
+		}
+
+		lookup := func() glua.LValue {
+			return e.state.GetField(tbl.lval, name)
+		}
+		val.FieldByIndex(f.Index).Set(e.makeFunc(f.Type, lookup, tbl.lval))
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("table is missing the functions %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// the first name the table has a function for
+func (e *Engine) tableFunction(tbl *Value, names []string) (string, bool) {
+	for _, name := range names {
+		if e.state.GetField(tbl.lval, name).Type() == glua.LTFunction {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// makeFunc creates a Go function of the type calling the Lua function lookup
+// returns, with self (if not nil) as the first argument.
+func (e *Engine) makeFunc(t reflect.Type, lookup func() glua.LValue, self glua.LValue) reflect.Value {
+	outs := make([]reflect.Type, t.NumOut())
+	for i := range outs {
+		outs[i] = t.Out(i)
+	}
+
+	hasErr := len(outs) > 0 && outs[len(outs)-1] == errorType
+	if hasErr {
+		outs = outs[:len(outs)-1]
+	}
+	convert := e.converter(outs)
+
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		vals, err := e.callGo(t, lookup(), self, args, len(outs), hasErr)
+		if err == nil {
+			var results []reflect.Value
+			results, err = convert(vals)
+			if err == nil {
+				if hasErr {
+					results = append(results, reflect.Zero(errorType))
+				}
+
+				return results
+			}
+		}
+
+		if !hasErr {
+			panic(err)
+		}
+
+		results := make([]reflect.Value, t.NumOut())
+		for i := range outs {
+			results[i] = reflect.Zero(outs[i])
+		}
+		results[len(outs)] = reflect.ValueOf(&err).Elem()
+
+		return results
+	})
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// call the Lua function with the arguments of a call to a Go function of the
+// type, returning nret results and the error returned as an extra result when
+// withErr is true
+func (e *Engine) callGo(t reflect.Type, fn, self glua.LValue, args []reflect.Value, nret int, withErr bool) ([]glua.LValue, error) {
+	if fn.Type() != glua.LTFunction {
+		return nil, fmt.Errorf("expected a function, got %s", fn.Type())
+	}
+
+	var largs []glua.LValue
+	if self != nil {
+		largs = append(largs, self)
+	}
+	for i, arg := range args {
+		if t.IsVariadic() && i == len(args)-1 {
+			for j := 0; j < arg.Len(); j++ {
+				largs = append(largs, getLValue(e, arg.Index(j).Interface()))
+			}
+
+			continue
+		}
+		largs = append(largs, getLValue(e, arg.Interface()))
+	}
+
+	total := nret
+	if withErr {
+		total++
+	}
+
+	l := e.state
+	err := e.callLua(func() error {
+		return l.CallByParam(glua.P{Fn: fn, NRet: total, Protect: true}, largs...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make([]glua.LValue, total)
+	for i := total - 1; i >= 0; i-- {
+		vals[i] = l.Get(-1)
+		l.Pop(1)
+	}
+
+	if withErr {
+		if err := luaError(vals[nret]); err != nil {
+			return nil, err
+		}
+	}
+
+	return vals[:nret], nil
+}
+
+// the error for an error value returned from Lua, nil and false are no error
+func luaError(val glua.LValue) error {
+	if !glua.LVAsBool(val) {
+		return nil
+	}
+	if ud, ok := val.(*glua.LUserData); ok {
+		if err, ok := ud.Value.(error); ok {
+			return err
+		}
+	}
+
+	return errors.New(val.String())
+}
+
+// converter returns a function converting Lua values to the types, the way
+// gopher-luar converts the arguments of Go functions called from Lua.
+func (e *Engine) converter(types []reflect.Type) func([]glua.LValue) ([]reflect.Value, error) {
+	if len(types) == 0 {
+		return func([]glua.LValue) ([]reflect.Value, error) {
+			return nil, nil
+		}
+	}
+
+	var converted []reflect.Value
+	receive := reflect.MakeFunc(reflect.FuncOf(types, nil, false), func(args []reflect.Value) []reflect.Value {
+		converted = args
+
+		return nil
+	})
+	fn := gluar.New(e.state, receive.Interface())
+
+	return func(vals []glua.LValue) ([]reflect.Value, error) {
+		err := e.state.CallByParam(glua.P{Fn: fn, NRet: 0, Protect: true}, vals...)
+		if err != nil {
+			return nil, err
+		}
+		results := converted
+		converted = nil
+
+		return results, nil
+	}
+}
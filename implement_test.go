@@ -0,0 +1,142 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+type implAction struct {
+	Name   string
+	Target string
+}
+
+type implController interface {
+	Think(mood string) implAction
+	Plan(steps ...int) (int, error)
+}
+
+type implControllerAdapter struct {
+	ThinkFunc func(string) implAction
+	PlanFunc  func(...int) (int, error)
+}
+
+func (a *implControllerAdapter) Think(mood string) implAction {
+	return a.ThinkFunc(mood)
+}
+
+func (a *implControllerAdapter) Plan(steps ...int) (int, error) {
+	return a.PlanFunc(steps...)
+}
+
+type implGreeter interface {
+	Greet() string
+}
+
+type implHooks struct {
+	OnSpawn func(name string) string
+	Count   int
+}
+
+func init() {
+	if err := RegisterAdapter(&implControllerAdapter{}); err != nil {
+		panic(err)
+	}
+}
+
+var _ = Describe("Implement()", func() {
+	var eng *Engine
+
+	BeforeEach(func() {
+		eng = NewEngine()
+		Ω(eng.DoString(`
+			brain = { attacks = 0 }
+
+			function brain:think(mood)
+				self.attacks = self.attacks + 1
+				return { name = mood == "angry" and "attack" or "wait", target = "bob" }
+			end
+
+			function brain:plan(...)
+				local total = 0
+				for _, step in ipairs({...}) do
+					if step < 0 then
+						return nil, "negative step"
+					end
+					total = total + step
+				end
+				return total
+			end
+		`)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		eng.Close()
+	})
+
+	It("implements interfaces with registered adapters", func() {
+		var ai implController
+		Ω(eng.Implement(eng.GetGlobal("brain"), &ai)).Should(Succeed())
+
+		Ω(ai.Think("angry")).Should(Equal(implAction{Name: "attack", Target: "bob"}))
+		Ω(ai.Think("calm").Name).Should(Equal("wait"))
+		Ω(eng.GetGlobal("brain").Get("attacks").AsNumber()).Should(BeEquivalentTo(2))
+	})
+
+	It("returns errors through a trailing error result", func() {
+		var ai implController
+		Ω(eng.Implement(eng.GetGlobal("brain"), &ai)).Should(Succeed())
+
+		total, err := ai.Plan(1, 2, 3)
+		Ω(err).Should(BeNil())
+		Ω(total).Should(Equal(6))
+
+		_, err = ai.Plan(1, -2)
+		Ω(err).Should(MatchError("negative step"))
+
+		Ω(eng.DoString(`function brain:plan() error("no plan") end`)).Should(Succeed())
+		_, err = ai.Plan()
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("no plan"))
+	})
+
+	It("panics with errors when methods have no error result", func() {
+		var ai implController
+		Ω(eng.Implement(eng.GetGlobal("brain"), &ai)).Should(Succeed())
+
+		Ω(eng.DoString(`function brain:think() error("confused") end`)).Should(Succeed())
+		Ω(func() { ai.Think("angry") }).Should(Panic())
+	})
+
+	It("sets the function fields of structs", func() {
+		Ω(eng.DoString(`hooks = { on_spawn = function(self, name) return name .. " spawned" end }`)).Should(Succeed())
+
+		hooks := implHooks{Count: 2}
+		Ω(eng.Implement(eng.GetGlobal("hooks"), &hooks)).Should(Succeed())
+		Ω(hooks.OnSpawn("orc")).Should(Equal("orc spawned"))
+		Ω(hooks.Count).Should(Equal(2))
+	})
+
+	It("fails for tables missing functions", func() {
+		Ω(eng.DoString(`partial = { think = function() end }`)).Should(Succeed())
+
+		var ai implController
+		err := eng.Implement(eng.GetGlobal("partial"), &ai)
+		Ω(err).Should(MatchError(ContainSubstring("plan")))
+		Ω(ai).Should(BeNil())
+	})
+
+	It("fails for interfaces without an adapter", func() {
+		var greeter implGreeter
+		err := eng.Implement(eng.GetGlobal("brain"), &greeter)
+		Ω(err).Should(MatchError(ContainSubstring("RegisterAdapter")))
+	})
+
+	It("rejects adapters that aren't pointers to structs", func() {
+		Ω(RegisterAdapter(implControllerAdapter{})).ShouldNot(Succeed())
+		Ω(RegisterAdapter(&implControllerAdapter{})).ShouldNot(Succeed())
+	})
+})
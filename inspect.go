@@ -0,0 +1,218 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ANSI escape sequences used by the default theme.
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiDim     = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+)
+
+// DefaultInspectWidth is the width tables are fit into when no width is given
+// to an Inspector.
+const DefaultInspectWidth = 80
+
+// Theme is the set of ANSI escape sequences used to color inspected values and
+// highlighted Lua source. An empty sequence leaves that kind of text uncolored.
+type Theme struct {
+	// String colors string values and literals.
+	String string
+
+	// Number colors number values and literals.
+	Number string
+
+	// Constant colors true, false and nil.
+	Constant string
+
+	// Key colors the keys of tables.
+	Key string
+
+	// TypeName colors the Go type name of userdata.
+	TypeName string
+
+	// Function colors function values.
+	Function string
+
+	// Keyword colors Lua keywords in source.
+	Keyword string
+
+	// Comment colors comments in source.
+	Comment string
+
+	// Punctuation colors the braces, brackets and separators of tables.
+	Punctuation string
+}
+
+// DefaultTheme is the theme used when an Inspector or highlighter isn't given
+// one.
+var DefaultTheme = Theme{
+	String:      ansiGreen,
+	Number:      ansiYellow,
+	Constant:    ansiMagenta,
+	Key:         ansiCyan,
+	TypeName:    ansiBlue + ansiBold,
+	Function:    ansiRed,
+	Keyword:     ansiMagenta + ansiBold,
+	Comment:     ansiDim,
+	Punctuation: "",
+}
+
+// Inspector formats values for display like Value.Inspect, optionally coloring
+// them and fitting tables that are small enough onto a single line.
+type Inspector struct {
+	// Color enables coloring the output with the Theme.
+	Color bool
+
+	// Theme is used to color output, if nil then DefaultTheme is used.
+	Theme *Theme
+
+	// Width is the number of columns available, tables whose inspected form
+	// fits in the remaining width are collapsed onto one line. Defaults to
+	// DefaultInspectWidth, a negative width never collapses tables.
+	Width int
+}
+
+// NewInspector creates an inspector with the default width, coloring output
+// when color is true.
+func NewInspector(color bool) *Inspector {
+	return &Inspector{
+		Color: color,
+		Width: DefaultInspectWidth,
+	}
+}
+
+// Inspect formats the value for display, nested lines are indented beginning
+// with indent.
+func (in *Inspector) Inspect(v *Value, indent string) string {
+	return in.inspect(v, indent, make(map[*lua.LTable]bool))
+}
+
+func (in *Inspector) inspect(v *Value, indent string, seen map[*lua.LTable]bool) string {
+	theme := in.theme()
+
+	switch v.lval.Type() {
+	case lua.LTString:
+		return in.paint(theme.String, v.Inspect(indent))
+	case lua.LTNumber:
+		return in.paint(theme.Number, v.Inspect(indent))
+	case lua.LTBool, lua.LTNil:
+		return in.paint(theme.Constant, v.Inspect(indent))
+	case lua.LTFunction:
+		return in.paint(theme.Function, v.Inspect(indent))
+	case lua.LTUserData:
+		if str, ok := v.inspectUserData(indent); ok {
+			return str
+		}
+		iface := v.Interface()
+
+		return fmt.Sprintf("%s(%+v)", in.paint(theme.TypeName, fmt.Sprintf("%T", iface)), iface)
+	case lua.LTTable:
+		if en, ok := v.owner.enumFor(v.lval); ok {
+			return in.paint(theme.TypeName, en.inspect())
+		}
+		vals, err := v.Invoke("inspect", 1, v)
+		if err == nil && len(vals) > 0 {
+			return in.inspect(vals[0], indent+"  ", seen)
+		}
+
+		return in.inspectTable(v, indent, seen)
+	}
+
+	return v.Inspect(indent)
+}
+
+// format the entries of the table, on one line if they fit
+func (in *Inspector) inspectTable(v *Value, indent string, seen map[*lua.LTable]bool) string {
+	theme := in.theme()
+	tbl := v.asTable()
+	if seen[tbl] {
+		return in.paint(theme.Punctuation, "{") + " ... " + in.paint(theme.Punctuation, "}")
+	}
+	seen[tbl] = true
+	defer delete(seen, tbl)
+
+	nextIndent := indent + "  "
+	entries := make([]string, 0)
+	v.ForEach(func(key, val *Value) {
+		keyStr := in.paint(theme.Key, key.Inspect(nextIndent))
+		entries = append(entries, fmt.Sprintf("[%s] = %s", keyStr, in.inspect(val, nextIndent, seen)))
+	})
+
+	openBrace, closeBrace := in.paint(theme.Punctuation, "{"), in.paint(theme.Punctuation, "}")
+	if len(entries) == 0 {
+		return openBrace + closeBrace
+	}
+
+	oneLine := openBrace + " " + strings.Join(entries, in.paint(theme.Punctuation, ",")+" ") + " " + closeBrace
+	if in.fits(oneLine, indent) {
+		return oneLine
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(openBrace)
+	buf.WriteString("\n")
+	for _, entry := range entries {
+		buf.WriteString(nextIndent)
+		buf.WriteString(entry)
+		buf.WriteString(in.paint(theme.Punctuation, ","))
+		buf.WriteString("\n")
+	}
+	buf.WriteString(indent)
+	buf.WriteString(closeBrace)
+
+	return buf.String()
+}
+
+// determine if the formatted value fits on one line in the remaining width
+func (in *Inspector) fits(str, indent string) bool {
+	width := in.Width
+	if width == 0 {
+		width = DefaultInspectWidth
+	}
+	if width < 0 || strings.ContainsRune(str, '\n') {
+		return false
+	}
+
+	return len(indent)+visibleWidth(str) <= width
+}
+
+// wrap the text in the color, if coloring is enabled
+func (in *Inspector) paint(color, text string) string {
+	if !in.Color || len(color) == 0 {
+		return text
+	}
+
+	return color + text + ansiReset
+}
+
+func (in *Inspector) theme() *Theme {
+	if in.Theme != nil {
+		return in.Theme
+	}
+
+	return &DefaultTheme
+}
+
+var ansiSequence = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// the number of columns the text occupies once escape sequences are removed
+func visibleWidth(str string) int {
+	return utf8.RuneCountInString(ansiSequence.ReplaceAllString(str, ""))
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("Inspector", func() {
+	var engine *Engine
+
+	BeforeEach(func() {
+		engine = NewEngine()
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	eval := func(src string) *Value {
+		Ω(engine.DoString("return " + src)).Should(Succeed())
+
+		return engine.PopValue()
+	}
+
+	It("inspects values like Value.Inspect without color", func() {
+		inspector := NewInspector(false)
+		Ω(inspector.Inspect(eval(`"hi"`), "")).Should(Equal(`"hi"`))
+		Ω(inspector.Inspect(eval(`42`), "")).Should(Equal("42"))
+		Ω(inspector.Inspect(eval(`nil`), "")).Should(Equal("nil"))
+	})
+
+	It("colors values when enabled", func() {
+		inspector := NewInspector(true)
+		Ω(inspector.Inspect(eval(`"hi"`), "")).Should(Equal(DefaultTheme.String + `"hi"` + "\x1b[0m"))
+		Ω(inspector.Inspect(eval(`42`), "")).Should(HavePrefix(DefaultTheme.Number))
+	})
+
+	It("collapses small tables onto one line", func() {
+		inspector := NewInspector(false)
+		Ω(inspector.Inspect(eval(`{1, 2}`), "")).Should(Equal("{ [1] = 1, [2] = 2 }"))
+		Ω(inspector.Inspect(eval(`{}`), "")).Should(Equal("{}"))
+	})
+
+	It("spreads tables that don't fit the width over several lines", func() {
+		inspector := &Inspector{Width: 10}
+		Ω(inspector.Inspect(eval(`{1, 2}`), "")).Should(Equal("{\n  [1] = 1,\n  [2] = 2,\n}"))
+	})
+
+	It("doesn't count color codes towards the width", func() {
+		inspector := &Inspector{Color: true, Width: 20}
+		Ω(inspector.Inspect(eval(`{1, 2}`), "")).ShouldNot(ContainSubstring("\n"))
+	})
+
+	It("handles tables that contain themselves", func() {
+		Ω(engine.DoString(`cyclic = {}; cyclic.self = cyclic`)).Should(Succeed())
+		str := NewInspector(false).Inspect(engine.GetGlobal("cyclic"), "")
+		Ω(str).Should(ContainSubstring("..."))
+	})
+})
+
+var _ = Describe("TokenizeLua()", func() {
+	It("reproduces the source", func() {
+		src := `local s = "a \" b" .. [[long]] -- done` + "\n" + `return 0x1F, 1..2`
+		var parts []string
+		for _, token := range TokenizeLua(src) {
+			parts = append(parts, token.Text)
+		}
+		Ω(strings.Join(parts, "")).Should(Equal(src))
+	})
+
+	It("classifies tokens", func() {
+		tokens := TokenizeLua(`local x = 'y' --[[ note ]] nil`)
+		kinds := make([]LuaTokenKind, 0)
+		for _, token := range tokens {
+			if token.Kind != TokenWhitespace {
+				kinds = append(kinds, token.Kind)
+			}
+		}
+		Ω(kinds).Should(Equal([]LuaTokenKind{
+			TokenKeyword, TokenName, TokenOperator, TokenString, TokenComment, TokenConstant,
+		}))
+	})
+
+	It("tolerates unterminated strings", func() {
+		tokens := TokenizeLua(`print("unfinished`)
+		Ω(tokens[len(tokens)-1]).Should(Equal(LuaToken{Kind: TokenString, Text: `"unfinished`}))
+	})
+})
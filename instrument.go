@@ -0,0 +1,744 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	glua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// gopher-lua doesn't provide debug hooks, so chunks are instrumented instead.
+// When anything is listening for hook events, chunks loaded through the engine
+// are rewritten to call the hook function with the chunk's ID, the kind of
+// event and an index (a line, function or branch) before they are compiled.
+// Chunks loaded while nothing is listening are compiled untouched and have no
+// overhead.
+
+// the local holding the hook function in instrumented chunks. The main
+// function of each chunk is given the hook as an upvalue, which it copies into
+// the local when it begins, so the hook never passes through a global scripts
+// could change.
+const hookLocal = "__luna_hook"
+
+// errHookBinding is returned if an instrumented chunk doesn't begin by reading
+// the hook as expected, which would be a bug in the instrumentation.
+var errHookBinding = errors.New("instrumented chunk doesn't begin by reading the hook")
+
+// the kinds of event instrumented code reports
+const (
+	hookLine = iota + 1
+	hookCall
+	hookReturn
+	hookBranch
+)
+
+// chunkFunction describes a function defined in an instrumented chunk, the
+// main chunk is always the first function.
+type chunkFunction struct {
+	name     string
+	line     int
+	lastLine int
+}
+
+// chunkBranch describes one of the outcomes of an if statement, block
+// identifies the if statement and branch is 0 for the then block and 1 for the
+// else block (which includes any elseif).
+type chunkBranch struct {
+	line   int
+	block  int
+	branch int
+}
+
+// lineSite is a place a line is reported from, locals holds the names of the
+// locals in scope in the order they occupy registers. gopher-lua's own debug
+// information about locals is unreliable (loops in particular confuse it), so
+// the instrumentation keeps track of them.
+type lineSite struct {
+	line   int
+	locals []string
+}
+
+// instrumentedChunk holds what is known about a chunk compiled with hooks.
+type instrumentedChunk struct {
+	id        int
+	name      string
+	source    []byte
+	lines     []int
+	sites     []lineSite
+	functions []chunkFunction
+	branches  []chunkBranch
+}
+
+// functionAt returns the index of the innermost function whose definition
+// contains the line.
+func (chunk *instrumentedChunk) functionAt(line int) int {
+	index := 0
+	for i, fn := range chunk.functions {
+		if i > 0 && fn.line <= line && line <= fn.lastLine && fn.line >= chunk.functions[index].line {
+			index = i
+		}
+	}
+
+	return index
+}
+
+// hookEvent is an event reported by instrumented code, line events also
+// identify the site reporting them.
+type hookEvent struct {
+	kind  int
+	chunk *instrumentedChunk
+	index int
+	site  int
+	state *glua.LState
+}
+
+// depth returns the number of frames on the call stack of the function that
+// reported the event, counting the function itself.
+func (ev *hookEvent) depth() int {
+	// the hook function has a frame of its own
+	return callDepth(ev.state) - 1
+}
+
+// line returns the line the event happened on, for call and return events
+// this is the line the function was defined on.
+func (ev *hookEvent) line() int {
+	switch ev.kind {
+	case hookLine:
+		return ev.index
+	case hookCall, hookReturn:
+		return ev.chunk.functions[ev.index].line
+	case hookBranch:
+		return ev.chunk.branches[ev.index].line
+	}
+
+	return 0
+}
+
+// hookListener receives the chunks that are instrumented and the events they
+// report, and is told when a chunk is released once its functions are gone.
+type hookListener interface {
+	chunkInstrumented(chunk *instrumentedChunk)
+	chunkReleased(id int)
+	hook(eng *Engine, ev *hookEvent)
+}
+
+// callListener is implemented by listeners that need to know when a call from
+// Go into Lua finishes, as functions unwound by an error never report their
+// return. Depth is the number of frames left on the call stack.
+type callListener interface {
+	luaCallFinished(eng *Engine, depth int, err error)
+}
+
+// instrumentation is the hook state of an engine.
+type instrumentation struct {
+	chunks    map[int]*instrumentedChunk
+	nextID    int
+	listeners []hookListener
+
+	// hook is the upvalue holding the hook function, shared by every chunk
+	hook *glua.Upvalue
+
+	// released holds the chunks whose functions have all been garbage
+	// collected, they're forgotten the next time a chunk is compiled. The
+	// mutex guards it as it's added to by finalizers.
+	mutex    sync.Mutex
+	released []int
+}
+
+// add a listener, instrumenting chunks compiled from now on
+func (e *Engine) addHookListener(listener hookListener) {
+	if e.instrumentation == nil {
+		hook := new(glua.Upvalue)
+		hook.SetValue(e.state.NewFunction(e.dispatchHook))
+		e.instrumentation = &instrumentation{
+			chunks: make(map[int]*instrumentedChunk),
+			hook:   hook,
+		}
+	}
+
+	e.instrumentation.listeners = append(e.instrumentation.listeners, listener)
+}
+
+// remove the listener, once nothing is listening chunks are no longer
+// instrumented.
+func (e *Engine) removeHookListener(listener hookListener) {
+	if e.instrumentation == nil {
+		return
+	}
+
+	listeners := e.instrumentation.listeners[:0]
+	for _, l := range e.instrumentation.listeners {
+		if l != listener {
+			listeners = append(listeners, l)
+		}
+	}
+	e.instrumentation.listeners = listeners
+}
+
+// determines if chunks should be instrumented when compiled
+func (e *Engine) instrumenting() bool {
+	return e.instrumentation != nil && len(e.instrumentation.listeners) > 0
+}
+
+// the function called by instrumented code, it forwards the event to the
+// listeners. Return events pass along the values being returned.
+func (e *Engine) dispatchHook(l *glua.LState) int {
+	instr := e.instrumentation
+	chunk, ok := instr.chunks[l.ToInt(1)]
+	if !ok {
+		return 0
+	}
+
+	ev := hookEvent{
+		kind:  l.ToInt(2),
+		chunk: chunk,
+		index: l.ToInt(3),
+		state: l,
+	}
+	if ev.kind == hookLine {
+		ev.site = l.ToInt(4)
+	}
+	for _, listener := range instr.listeners {
+		listener.hook(e, &ev)
+	}
+
+	if ev.kind == hookReturn {
+		return l.GetTop() - 3
+	}
+
+	return 0
+}
+
+// compile the source read from r into a function, instrumenting it when
+// anything is listening for hook events.
+func (e *Engine) compile(r io.Reader, name string) (*glua.LFunction, error) {
+	if !e.instrumenting() {
+		return e.state.Load(r, name)
+	}
+
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, &glua.ApiError{Type: glua.ApiErrorFile, Object: glua.LString(err.Error()), Cause: err}
+	}
+
+	stmts, err := parse.Parse(bytes.NewReader(src), name)
+	if err != nil {
+		return nil, &glua.ApiError{Type: glua.ApiErrorSyntax, Object: glua.LString(err.Error()), Cause: err}
+	}
+
+	instr := e.instrumentation
+	e.forgetReleasedChunks()
+	chunk := &instrumentedChunk{
+		id:     instr.nextID,
+		name:   name,
+		source: src,
+	}
+	stmts = instrumentChunk(stmts, chunk)
+
+	proto, err := glua.Compile(stmts, name)
+	if err != nil {
+		return nil, &glua.ApiError{Type: glua.ApiErrorSyntax, Object: glua.LString(err.Error()), Cause: err}
+	}
+	if err := bindHook(proto); err != nil {
+		return nil, err
+	}
+
+	instr.nextID++
+	instr.chunks[chunk.id] = chunk
+	for _, listener := range instr.listeners {
+		listener.chunkInstrumented(chunk)
+	}
+	instr.track(chunk.id, proto)
+
+	fn := e.state.NewFunctionFromProto(proto)
+	fn.Upvalues[0] = instr.hook
+
+	return fn, nil
+}
+
+// turn the read of the hook the chunk begins with, compiled as a global
+// lookup, into a read of the main function's only upvalue
+func bindHook(proto *glua.FunctionProto) error {
+	if len(proto.Code) == 0 || proto.NumUpvalues != 0 {
+		return errHookBinding
+	}
+
+	// GETGLOBAL A Bx loads the constant named global into register A, the
+	// opcode is the top 6 bits followed by 8 bits for A and 18 for Bx
+	inst := proto.Code[0]
+	constant := int(inst & 0x3ffff)
+	if int(inst>>26) != glua.OP_GETGLOBAL || (inst>>18)&0xff != 0 || constant >= len(proto.Constants) {
+		return errHookBinding
+	}
+	if name, ok := proto.Constants[constant].(glua.LString); !ok || string(name) != hookLocal {
+		return errHookBinding
+	}
+
+	// GETUPVAL A B with A and B both 0
+	proto.Code[0] = uint32(glua.OP_GETUPVAL) << 26
+	proto.NumUpvalues = 1
+	proto.DbgUpvalues = []string{hookLocal}
+
+	return nil
+}
+
+// watch the functions of the chunk, releasing the chunk once all of them have
+// been garbage collected
+func (instr *instrumentation) track(id int, proto *glua.FunctionProto) {
+	var protos []*glua.FunctionProto
+	var collect func(*glua.FunctionProto)
+	collect = func(p *glua.FunctionProto) {
+		protos = append(protos, p)
+		for _, child := range p.FunctionPrototypes {
+			collect(child)
+		}
+	}
+	collect(proto)
+
+	live := int32(len(protos))
+	for _, p := range protos {
+		runtime.SetFinalizer(p, func(*glua.FunctionProto) {
+			if atomic.AddInt32(&live, -1) > 0 {
+				return
+			}
+
+			instr.mutex.Lock()
+			instr.released = append(instr.released, id)
+			instr.mutex.Unlock()
+		})
+	}
+}
+
+// forget the chunks that have been released, letting the listeners know
+func (e *Engine) forgetReleasedChunks() {
+	instr := e.instrumentation
+	instr.mutex.Lock()
+	released := instr.released
+	instr.released = nil
+	instr.mutex.Unlock()
+
+	for _, id := range released {
+		delete(instr.chunks, id)
+		for _, listener := range instr.listeners {
+			listener.chunkReleased(id)
+		}
+	}
+}
+
+// compile the file, like LState.LoadFile a leading line beginning with '#' is
+// ignored.
+func (e *Engine) compileFile(fpath string) (*glua.LFunction, error) {
+	if !e.instrumenting() {
+		return e.state.LoadFile(fpath)
+	}
+
+	src, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, &glua.ApiError{Type: glua.ApiErrorFile, Object: glua.LString(err.Error()), Cause: err}
+	}
+
+	if len(src) > 0 && src[0] == '#' {
+		// blank the line rather than dropping it to keep line numbers intact
+		end := bytes.IndexByte(src, '\n')
+		if end < 0 {
+			end = len(src)
+		}
+		src = append(bytes.Repeat([]byte{' '}, end), src[end:]...)
+	}
+
+	return e.compile(bytes.NewReader(src), fpath)
+}
+
+// run the function with no arguments, leaving its results on the stack
+func (e *Engine) run(fn *glua.LFunction) error {
+	return e.callLua(func() error {
+		e.state.Push(fn)
+
+		return e.state.PCall(0, glua.MultRet, nil)
+	})
+}
+
+// callLua makes a protected call from Go into Lua with the given function,
+// letting listeners know once it's finished.
+func (e *Engine) callLua(call func() error) error {
+	err := call()
+	if !e.instrumenting() {
+		return err
+	}
+
+	depth := callDepth(e.state)
+	for _, listener := range e.instrumentation.listeners {
+		if cl, ok := listener.(callListener); ok {
+			cl.luaCallFinished(e, depth, err)
+		}
+	}
+
+	return err
+}
+
+// callDepth counts the frames on the call stack of the state, the stack is
+// walked from the top for each level so the depth is found with a binary
+// search rather than trying each level in turn.
+func callDepth(l *glua.LState) int {
+	if _, ok := l.GetStack(0); !ok {
+		return 0
+	}
+
+	low, high := 0, 1
+	for {
+		if _, ok := l.GetStack(high); !ok {
+			break
+		}
+		low, high = high, high*2
+	}
+	for high-low > 1 {
+		mid := (low + high) / 2
+		if _, ok := l.GetStack(mid); ok {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	return low + 1
+}
+
+// instrumenter rewrites the statements of a chunk to report hook events.
+type instrumenter struct {
+	chunk *instrumentedChunk
+	lines map[int]bool
+
+	// the locals in scope in the function being instrumented
+	scope []string
+}
+
+// instrumentChunk rewrites the statements of a chunk to report hook events,
+// recording the lines, functions and branches found in the chunk.
+func instrumentChunk(stmts []ast.Stmt, chunk *instrumentedChunk) []ast.Stmt {
+	in := &instrumenter{
+		chunk: chunk,
+		lines: make(map[int]bool),
+	}
+
+	lastLine := 0
+	if len(stmts) > 0 {
+		lastLine = stmts[len(stmts)-1].LastLine()
+	}
+	// the hook function is the first local of the main chunk
+	body := in.function("main chunk", 0, lastLine, stmts, []string{hookLocal})
+
+	// local __luna_hook = __luna_hook, the hook is read from an upvalue once
+	// the chunk is compiled (see bindHook)
+	capture := &ast.LocalAssignStmt{
+		Names: []string{hookLocal},
+		Exprs: []ast.Expr{&ast.IdentExpr{Value: hookLocal}},
+	}
+
+	return append([]ast.Stmt{capture}, body...)
+}
+
+// instrument a function body, reporting the call when it begins and the
+// return when it ends. Params are the locals the function begins with.
+func (in *instrumenter) function(name string, line, lastLine int, stmts []ast.Stmt, params []string) []ast.Stmt {
+	outer := in.scope
+	in.scope = params
+	defer func() {
+		in.scope = outer
+	}()
+
+	fnIndex := len(in.chunk.functions)
+	in.chunk.functions = append(in.chunk.functions, chunkFunction{
+		name:     name,
+		line:     line,
+		lastLine: lastLine,
+	})
+
+	body := in.block(stmts, fnIndex, 0)
+	result := append([]ast.Stmt{in.hookStmt(hookCall, fnIndex, line)}, body...)
+
+	if len(stmts) == 0 {
+		return append(result, in.hookStmt(hookReturn, fnIndex, lastLine))
+	}
+
+	if _, ok := stmts[len(stmts)-1].(*ast.ReturnStmt); ok {
+		return result
+	}
+
+	return append(result, in.hookStmt(hookReturn, fnIndex, lastLine))
+}
+
+// instrument the statements of a block, reporting each line before the first
+// statement on it runs. Statements sharing a line with the one before them
+// don't report it again, nor does the first statement of an if or do block
+// that begins on the same line (enclosing is the line of that statement, 0
+// for function and loop bodies whose lines are reported on each pass).
+func (in *instrumenter) block(stmts []ast.Stmt, fnIndex, enclosing int) []ast.Stmt {
+	scope := len(in.scope)
+	defer func() {
+		in.scope = in.scope[:scope]
+	}()
+
+	result := make([]ast.Stmt, 0, len(stmts)*2)
+	previous := enclosing
+	for _, stmt := range stmts {
+		line := stmt.Line()
+		if !in.lines[line] {
+			in.lines[line] = true
+			in.chunk.lines = append(in.chunk.lines, line)
+		}
+
+		if line != previous {
+			result = append(result, in.lineHookStmt(line))
+		}
+		previous = line
+		result = append(result, in.stmt(stmt, fnIndex)...)
+
+		if local, ok := stmt.(*ast.LocalAssignStmt); ok {
+			in.scope = append(in.scope, local.Names...)
+		}
+	}
+
+	return result
+}
+
+// instrument a single statement and the functions and blocks it contains, a
+// statement can become several statements.
+func (in *instrumenter) stmt(stmt ast.Stmt, fnIndex int) []ast.Stmt {
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		for i, expr := range s.Rhs {
+			name := ""
+			if i < len(s.Lhs) {
+				name = exprName(s.Lhs[i])
+			}
+			s.Rhs[i] = in.expr(expr, name)
+		}
+		for i, expr := range s.Lhs {
+			s.Lhs[i] = in.expr(expr, "")
+		}
+	case *ast.LocalAssignStmt:
+		for i, expr := range s.Exprs {
+			name := ""
+			if i < len(s.Names) {
+				name = s.Names[i]
+			}
+			s.Exprs[i] = in.expr(expr, name)
+		}
+	case *ast.FuncCallStmt:
+		s.Expr = in.expr(s.Expr, "")
+	case *ast.DoBlockStmt:
+		s.Stmts = in.block(s.Stmts, fnIndex, s.Line())
+	case *ast.WhileStmt:
+		s.Condition = in.expr(s.Condition, "")
+		s.Stmts = in.block(s.Stmts, fnIndex, 0)
+	case *ast.RepeatStmt:
+		s.Stmts = in.block(s.Stmts, fnIndex, 0)
+		s.Condition = in.expr(s.Condition, "")
+	case *ast.IfStmt:
+		block := len(in.chunk.branches) / 2
+		thenBranch := len(in.chunk.branches)
+		in.chunk.branches = append(in.chunk.branches,
+			chunkBranch{line: s.Line(), block: block, branch: 0},
+			chunkBranch{line: s.Line(), block: block, branch: 1},
+		)
+
+		s.Condition = in.expr(s.Condition, "")
+		s.Then = append([]ast.Stmt{in.hookStmt(hookBranch, thenBranch, s.Line())}, in.block(s.Then, fnIndex, s.Line())...)
+		s.Else = append([]ast.Stmt{in.hookStmt(hookBranch, thenBranch+1, s.Line())}, in.block(s.Else, fnIndex, s.Line())...)
+	case *ast.NumberForStmt:
+		s.Init = in.expr(s.Init, "")
+		s.Limit = in.expr(s.Limit, "")
+		if s.Step != nil {
+			s.Step = in.expr(s.Step, "")
+		}
+
+		scope := len(in.scope)
+		in.scope = append(in.scope, "(for index)", "(for limit)", "(for step)", s.Name)
+		s.Stmts = in.block(s.Stmts, fnIndex, 0)
+		in.scope = in.scope[:scope]
+	case *ast.GenericForStmt:
+		for i, expr := range s.Exprs {
+			s.Exprs[i] = in.expr(expr, "")
+		}
+
+		scope := len(in.scope)
+		in.scope = append(in.scope, "(for generator)", "(for state)", "(for control)")
+		in.scope = append(in.scope, s.Names...)
+		s.Stmts = in.block(s.Stmts, fnIndex, 0)
+		in.scope = in.scope[:scope]
+	case *ast.FuncDefStmt:
+		if s.Name.Func != nil {
+			in.functionExpr(s.Func, exprName(s.Name.Func), false)
+		} else {
+			in.functionExpr(s.Func, exprName(s.Name.Receiver)+":"+s.Name.Method, true)
+		}
+	case *ast.ReturnStmt:
+		for i, expr := range s.Exprs {
+			s.Exprs[i] = in.expr(expr, "")
+		}
+
+		// a tail call replaces the function, so it returns before the call
+		// just as Lua reports tail returns. Other returns report after the
+		// values have been evaluated by passing them through the hook.
+		if len(s.Exprs) == 1 {
+			if call, ok := s.Exprs[0].(*ast.FuncCallExpr); ok && !call.AdjustRet {
+				return []ast.Stmt{in.hookStmt(hookReturn, fnIndex, s.Line()), s}
+			}
+		}
+
+		call := in.hookCall(hookReturn, fnIndex, s.Line())
+		call.Args = append(call.Args, s.Exprs...)
+		s.Exprs = []ast.Expr{call}
+	}
+
+	return []ast.Stmt{stmt}
+}
+
+// instrument the functions found in an expression, name is used for a
+// function expression when it's being assigned to a name.
+func (in *instrumenter) expr(expr ast.Expr, name string) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.FunctionExpr:
+		if len(name) == 0 {
+			name = "anonymous"
+		}
+		in.functionExpr(e, name, false)
+	case *ast.AttrGetExpr:
+		e.Object = in.expr(e.Object, "")
+		e.Key = in.expr(e.Key, "")
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			fieldName := ""
+			if key, ok := field.Key.(*ast.StringExpr); ok {
+				fieldName = key.Value
+			}
+			if field.Key != nil {
+				field.Key = in.expr(field.Key, "")
+			}
+			field.Value = in.expr(field.Value, fieldName)
+		}
+	case *ast.FuncCallExpr:
+		if e.Func != nil {
+			e.Func = in.expr(e.Func, "")
+		}
+		if e.Receiver != nil {
+			e.Receiver = in.expr(e.Receiver, "")
+		}
+		for i, arg := range e.Args {
+			e.Args[i] = in.expr(arg, "")
+		}
+	case *ast.LogicalOpExpr:
+		e.Lhs = in.expr(e.Lhs, "")
+		e.Rhs = in.expr(e.Rhs, "")
+	case *ast.RelationalOpExpr:
+		e.Lhs = in.expr(e.Lhs, "")
+		e.Rhs = in.expr(e.Rhs, "")
+	case *ast.StringConcatOpExpr:
+		e.Lhs = in.expr(e.Lhs, "")
+		e.Rhs = in.expr(e.Rhs, "")
+	case *ast.ArithmeticOpExpr:
+		e.Lhs = in.expr(e.Lhs, "")
+		e.Rhs = in.expr(e.Rhs, "")
+	case *ast.UnaryMinusOpExpr:
+		e.Expr = in.expr(e.Expr, "")
+	case *ast.UnaryNotOpExpr:
+		e.Expr = in.expr(e.Expr, "")
+	case *ast.UnaryLenOpExpr:
+		e.Expr = in.expr(e.Expr, "")
+	}
+
+	return expr
+}
+
+// instrument a function expression, methods have self as their first local
+// and gopher-lua gives functions with varargs an arg local.
+func (in *instrumenter) functionExpr(fn *ast.FunctionExpr, name string, method bool) {
+	params := make([]string, 0, len(fn.ParList.Names)+2)
+	if method {
+		params = append(params, "self")
+	}
+	params = append(params, fn.ParList.Names...)
+	if fn.ParList.HasVargs && glua.CompatVarArg {
+		params = append(params, "arg")
+	}
+
+	fn.Stmts = in.function(name, fn.Line(), fn.LastLine(), fn.Stmts, params)
+}
+
+// build a call to the hook function: __luna_hook(chunk, kind, index)
+func (in *instrumenter) hookCall(kind, index, line int) *ast.FuncCallExpr {
+	number := func(n int) ast.Expr {
+		expr := &ast.NumberExpr{Value: strconv.Itoa(n)}
+		expr.SetLine(line)
+
+		return expr
+	}
+
+	fn := &ast.IdentExpr{Value: hookLocal}
+	fn.SetLine(line)
+	call := &ast.FuncCallExpr{
+		Func: fn,
+		Args: []ast.Expr{number(in.chunk.id), number(kind), number(index)},
+	}
+	call.SetLine(line)
+	call.SetLastLine(line)
+
+	return call
+}
+
+// build a statement calling the hook function
+func (in *instrumenter) hookStmt(kind, index, line int) ast.Stmt {
+	stmt := &ast.FuncCallStmt{Expr: in.hookCall(kind, index, line)}
+	stmt.SetLine(line)
+	stmt.SetLastLine(line)
+
+	return stmt
+}
+
+// build a statement reporting the line, recording the locals in scope at the
+// site: __luna_hook(chunk, kind, line, site)
+func (in *instrumenter) lineHookStmt(line int) ast.Stmt {
+	site := len(in.chunk.sites)
+	in.chunk.sites = append(in.chunk.sites, lineSite{
+		line:   line,
+		locals: append([]string{}, in.scope...),
+	})
+
+	call := in.hookCall(hookLine, line, line)
+	siteExpr := &ast.NumberExpr{Value: strconv.Itoa(site)}
+	siteExpr.SetLine(line)
+	call.Args = append(call.Args, siteExpr)
+
+	stmt := &ast.FuncCallStmt{Expr: call}
+	stmt.SetLine(line)
+	stmt.SetLastLine(line)
+
+	return stmt
+}
+
+// the name of the value an expression refers to, such as `player.move`, used
+// to name functions.
+func exprName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.IdentExpr:
+		return e.Value
+	case *ast.AttrGetExpr:
+		if key, ok := e.Key.(*ast.StringExpr); ok {
+			return exprName(e.Object) + "." + key.Value
+		}
+
+		return exprName(e.Object) + "[?]"
+	}
+
+	return "?"
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultLuaTestPattern matches the files run by RunLuaTests when no pattern
+// is given.
+const DefaultLuaTestPattern = "*_test.lua"
+
+// LuaTestStatus is the outcome of a single Lua test.
+type LuaTestStatus int
+
+// The possible outcomes of a Lua test.
+const (
+	LuaTestPassed LuaTestStatus = iota
+	LuaTestFailed
+	LuaTestSkipped
+)
+
+// String returns the name of the status.
+func (s LuaTestStatus) String() string {
+	switch s {
+	case LuaTestPassed:
+		return "passed"
+	case LuaTestFailed:
+		return "failed"
+	case LuaTestSkipped:
+		return "skipped"
+	}
+
+	return "unknown"
+}
+
+// LuaTestResult is the outcome of a test defined with `it` in a Lua test file.
+type LuaTestResult struct {
+	// File is the path of the test file within the file system.
+	File string
+
+	// Path holds the names of the describe blocks enclosing the test.
+	Path []string
+
+	// Name is the name given to the test.
+	Name string
+
+	// Status is the outcome of the test.
+	Status LuaTestStatus
+
+	// Message describes why the test failed.
+	Message string
+
+	// Duration is how long the test took to run, including its hooks.
+	Duration time.Duration
+}
+
+// FullName joins the names of the enclosing describe blocks and the test.
+func (r LuaTestResult) FullName() string {
+	return strings.Join(append(append([]string{}, r.Path...), r.Name), " ")
+}
+
+// LuaTestFile holds the results of the tests in a single file.
+type LuaTestFile struct {
+	// Path is the path of the test file within the file system.
+	Path string
+
+	// Results are the outcomes of the tests in the order they were defined.
+	Results []LuaTestResult
+
+	// Duration is how long running the file took.
+	Duration time.Duration
+}
+
+// LuaTestReport is the outcome of running Lua test files.
+type LuaTestReport struct {
+	// Files are the results for each file run.
+	Files []*LuaTestFile
+
+	// Duration is how long running every file took.
+	Duration time.Duration
+}
+
+// Results returns the results of every test in every file.
+func (r *LuaTestReport) Results() []LuaTestResult {
+	results := make([]LuaTestResult, 0)
+	for _, file := range r.Files {
+		results = append(results, file.Results...)
+	}
+
+	return results
+}
+
+// Count returns the number of tests with the given status.
+func (r *LuaTestReport) Count(status LuaTestStatus) int {
+	count := 0
+	for _, result := range r.Results() {
+		if result.Status == status {
+			count++
+		}
+	}
+
+	return count
+}
+
+// OK determines if no tests failed.
+func (r *LuaTestReport) OK() bool {
+	return r.Count(LuaTestFailed) == 0
+}
+
+// LuaTestConfig customizes how Lua test files are run.
+type LuaTestConfig struct {
+	// Options are used to create the engine for each file, if nil then the
+	// options used by NewEngine are used.
+	Options *EngineOptions
+
+	// Setup prepares the engine for each file before it's executed, such as
+	// registering the Go types and functions the scripts expect. An error
+	// fails every test in the file.
+	Setup EngineInitializer
+
+	// RequirePaths are the patterns require searches within the file system,
+	// by default modules are found relative to the root of the file system and
+	// the directory of the test file.
+	RequirePaths []string
+
+	// Coverage records the coverage of the modules the test files require
+	// when given, the test files themselves aren't included.
+	Coverage *Coverage
+}
+
+// RunLuaTests runs every Lua test file in fsys matching the pattern, which is
+// matched against the base name of each file (or the full path if it contains
+// a slash) as with path.Match. Each file is run in its own engine, and can use
+// describe, it, xit, before_all, after_all, before_each, after_each and expect
+// to define its tests. An error is only returned if the files could not be
+// found or read, test failures are recorded in the report.
+func RunLuaTests(fsys fs.FS, pattern string) (*LuaTestReport, error) {
+	return RunLuaTestsWithConfig(fsys, pattern, LuaTestConfig{})
+}
+
+// RunLuaTestsWithConfig runs Lua test files like RunLuaTests, customized by
+// the config.
+func RunLuaTestsWithConfig(fsys fs.FS, pattern string, config LuaTestConfig) (*LuaTestReport, error) {
+	if len(pattern) == 0 {
+		pattern = DefaultLuaTestPattern
+	}
+
+	files, err := findLuaTests(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LuaTestReport{
+		Files: make([]*LuaTestFile, 0, len(files)),
+	}
+	start := time.Now()
+	for _, file := range files {
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Files = append(report.Files, runLuaTestFile(fsys, file, src, config))
+	}
+	report.Duration = time.Since(start)
+
+	return report, nil
+}
+
+// find the files in fsys matching the pattern, in lexical order
+func findLuaTests(fsys fs.FS, pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	err := fs.WalkDir(fsys, ".", func(fpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		name := path.Base(fpath)
+		if strings.Contains(pattern, "/") {
+			name = fpath
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			files = append(files, fpath)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// run the tests in a single file in a fresh engine
+func runLuaTestFile(fsys fs.FS, file string, src []byte, config LuaTestConfig) *LuaTestFile {
+	result := &LuaTestFile{
+		Path:    file,
+		Results: make([]LuaTestResult, 0),
+	}
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	fail := func(name string, err error) {
+		result.Results = append(result.Results, LuaTestResult{
+			File:    file,
+			Name:    name,
+			Status:  LuaTestFailed,
+			Message: err.Error(),
+		})
+	}
+
+	options := defaultOptions
+	if config.Options != nil {
+		options = *config.Options
+	}
+	eng := NewEngineWithOptions(options)
+	defer eng.Close()
+
+	requirePaths := config.RequirePaths
+	if len(requirePaths) == 0 {
+		dir := path.Dir(file)
+		requirePaths = []string{"?.lua", "?/init.lua", path.Join(dir, "?.lua"), path.Join(dir, "?/init.lua")}
+	}
+	eng.SecureRequireFS(fsys, requirePaths)
+
+	if config.Setup != nil {
+		if err := config.Setup(eng); err != nil {
+			fail("setup", err)
+
+			return result
+		}
+	}
+
+	var testStart time.Time
+	report := func(eng *Engine) int {
+		testPath := eng.Get(1)
+		testResult := LuaTestResult{
+			File:    file,
+			Path:    make([]string, 0, testPath.Len()),
+			Name:    eng.Get(2).AsString(),
+			Message: eng.Get(4).AsString(),
+		}
+		for i := 1; i <= testPath.Len(); i++ {
+			testResult.Path = append(testResult.Path, testPath.RawGet(i).AsString())
+		}
+
+		switch eng.Get(3).AsString() {
+		case "passed":
+			testResult.Status = LuaTestPassed
+		case "skipped":
+			testResult.Status = LuaTestSkipped
+		default:
+			testResult.Status = LuaTestFailed
+		}
+		if testResult.Status != LuaTestSkipped && !testStart.IsZero() {
+			testResult.Duration = time.Since(testStart)
+		}
+		testStart = time.Time{}
+
+		result.Results = append(result.Results, testResult)
+
+		return 0
+	}
+	startTest := func(*Engine) int {
+		testStart = time.Now()
+
+		return 0
+	}
+
+	dsl, err := eng.load(strings.NewReader(luaTestDSL), "luatest")
+	if err != nil {
+		fail("setup", err)
+
+		return result
+	}
+	vals, err := dsl.Call(1, ScriptFunction(report), ScriptFunction(startTest))
+	if err != nil {
+		fail("setup", err)
+
+		return result
+	}
+	runner := vals[0]
+
+	chunk, err := eng.load(bytes.NewReader(src), file)
+	if err != nil {
+		fail("load", err)
+
+		return result
+	}
+	if config.Coverage != nil {
+		eng.EnableCoverage(config.Coverage)
+	}
+	if _, err := chunk.Call(0); err != nil {
+		fail("load", err)
+
+		return result
+	}
+
+	if _, err := runner.Call(0); err != nil {
+		fail("run", err)
+	}
+
+	return result
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+// luaTestDSL defines the globals available to Lua test files (describe, it,
+// xit, expect and the hooks). The chunk is called with the functions used to
+// report to the runner and returns the function that runs the collected tests
+// once the test file has been executed.
+const luaTestDSL = `
+local report, start = ...
+
+local function new_suite(name, parent)
+  return {
+    name = name,
+    parent = parent,
+    children = {},
+    before_all = {},
+    after_all = {},
+    before_each = {},
+    after_each = {},
+  }
+end
+
+local root = new_suite(nil, nil)
+local current = root
+
+function describe(name, body)
+  local suite = new_suite(name, current)
+  table.insert(current.children, suite)
+
+  local previous = current
+  current = suite
+  local ok, err = pcall(body)
+  current = previous
+  if not ok then
+    error(err, 0)
+  end
+end
+
+function it(name, fn)
+  table.insert(current.children, { test = true, name = name, fn = fn })
+end
+
+function xit(name, fn)
+  table.insert(current.children, { test = true, skip = true, name = name, fn = fn })
+end
+
+function before_all(fn) table.insert(current.before_all, fn) end
+function after_all(fn) table.insert(current.after_all, fn) end
+function before_each(fn) table.insert(current.before_each, fn) end
+function after_each(fn) table.insert(current.after_each, fn) end
+
+local function format(value)
+  if type(value) == "string" then
+    return string.format("%q", value)
+  end
+
+  return tostring(value)
+end
+
+local function deep_equal(a, b, seen)
+  if a == b then
+    return true
+  end
+  if type(a) ~= "table" or type(b) ~= "table" then
+    return false
+  end
+
+  seen = seen or {}
+  if seen[a] == b then
+    return true
+  end
+  seen[a] = b
+
+  for k, v in pairs(a) do
+    if not deep_equal(v, b[k], seen) then
+      return false
+    end
+  end
+  for k in pairs(b) do
+    if a[k] == nil then
+      return false
+    end
+  end
+
+  return true
+end
+
+local function abs(n)
+  return n < 0 and -n or n
+end
+
+local function expectation(actual, negated)
+  local e = {}
+
+  -- errors are attributed to the test calling the matcher, gopher-lua counts
+  -- the error function as a level so that's level 4 rather than 3
+  local function check(passed, message, negated_message)
+    if negated then
+      passed = not passed
+      message = negated_message
+    end
+    if not passed then
+      error(message, 4)
+    end
+  end
+
+  function e.to_equal(expected)
+    check(deep_equal(actual, expected),
+      "expected " .. format(actual) .. " to equal " .. format(expected),
+      "expected " .. format(actual) .. " not to equal " .. format(expected))
+  end
+
+  function e.to_be(expected)
+    check(rawequal(actual, expected),
+      "expected " .. format(actual) .. " to be " .. format(expected),
+      "expected " .. format(actual) .. " not to be " .. format(expected))
+  end
+
+  function e.to_be_nil()
+    check(actual == nil,
+      "expected " .. format(actual) .. " to be nil",
+      "expected value not to be nil")
+  end
+
+  function e.to_be_truthy()
+    check(actual and true or false,
+      "expected " .. format(actual) .. " to be truthy",
+      "expected " .. format(actual) .. " not to be truthy")
+  end
+
+  function e.to_be_falsy()
+    check(not actual,
+      "expected " .. format(actual) .. " to be falsy",
+      "expected " .. format(actual) .. " not to be falsy")
+  end
+
+  function e.to_be_a(typename)
+    check(type(actual) == typename,
+      "expected " .. format(actual) .. " to be a " .. typename,
+      "expected " .. format(actual) .. " not to be a " .. typename)
+  end
+
+  function e.to_be_near(expected, delta)
+    delta = delta or 1e-9
+    check(type(actual) == "number" and abs(actual - expected) <= delta,
+      "expected " .. format(actual) .. " to be within " .. delta .. " of " .. expected,
+      "expected " .. format(actual) .. " not to be within " .. delta .. " of " .. expected)
+  end
+
+  function e.to_contain(item)
+    local found = false
+    if type(actual) == "string" then
+      found = string.find(actual, item, 1, true) ~= nil
+    elseif type(actual) == "table" then
+      for _, v in pairs(actual) do
+        if deep_equal(v, item) then
+          found = true
+          break
+        end
+      end
+    end
+
+    check(found,
+      "expected " .. format(actual) .. " to contain " .. format(item),
+      "expected " .. format(actual) .. " not to contain " .. format(item))
+  end
+
+  function e.to_error(text)
+    local ok, err = pcall(actual)
+    local matched = not ok and (text == nil or string.find(tostring(err), text, 1, true) ~= nil)
+    local wanted = text and ("an error containing " .. format(text)) or "an error"
+
+    check(matched,
+      "expected " .. wanted .. (ok and ", but nothing was raised" or (", got " .. format(err))),
+      "expected not to raise " .. wanted .. ", got " .. format(err))
+  end
+
+  if not negated then
+    e.never = expectation(actual, true)
+  end
+
+  return e
+end
+
+function expect(actual)
+  return expectation(actual, false)
+end
+
+local function run_hooks(hooks)
+  for _, hook in ipairs(hooks) do
+    hook()
+  end
+end
+
+local function run_before_each(suite)
+  if suite.parent then
+    run_before_each(suite.parent)
+  end
+  run_hooks(suite.before_each)
+end
+
+local function run_after_each(suite)
+  run_hooks(suite.after_each)
+  if suite.parent then
+    run_after_each(suite.parent)
+  end
+end
+
+local function run_suite(suite, path)
+  local setup_ok, setup_err = pcall(run_hooks, suite.before_all)
+
+  for _, child in ipairs(suite.children) do
+    if child.test then
+      if child.skip then
+        report(path, child.name, "skipped")
+      elseif not setup_ok then
+        report(path, child.name, "failed", tostring(setup_err))
+      else
+        start()
+        local ok, err = pcall(run_before_each, suite)
+        if ok then
+          ok, err = pcall(child.fn)
+        end
+        local after_ok, after_err = pcall(run_after_each, suite)
+        if ok and not after_ok then
+          ok, err = false, after_err
+        end
+
+        if ok then
+          report(path, child.name, "passed")
+        else
+          report(path, child.name, "failed", tostring(err))
+        end
+      end
+    else
+      local child_path = {}
+      for i, name in ipairs(path) do
+        child_path[i] = name
+      end
+      table.insert(child_path, child.name)
+      run_suite(child, child_path)
+    end
+  end
+
+  local ok, err = pcall(run_hooks, suite.after_all)
+  if not ok then
+    report(path, "after_all", "failed", tostring(err))
+  end
+end
+
+return function()
+  run_suite(root, {})
+end
+`
@@ -0,0 +1,144 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteTAP writes the report in the Test Anything Protocol (version 13)
+// format, failure messages are included as YAML diagnostics.
+func (r *LuaTestReport) WriteTAP(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	results := r.Results()
+
+	fmt.Fprintln(out, "TAP version 13")
+	fmt.Fprintf(out, "1..%d\n", len(results))
+	for i, result := range results {
+		description := fmt.Sprintf("%s: %s", result.File, result.FullName())
+		switch result.Status {
+		case LuaTestPassed:
+			fmt.Fprintf(out, "ok %d - %s\n", i+1, description)
+		case LuaTestSkipped:
+			fmt.Fprintf(out, "ok %d - %s # SKIP\n", i+1, description)
+		default:
+			fmt.Fprintf(out, "not ok %d - %s\n", i+1, description)
+			fmt.Fprintln(out, "  ---")
+			fmt.Fprintf(out, "  message: %s\n", strconv.Quote(result.Message))
+			fmt.Fprintln(out, "  ...")
+		}
+	}
+
+	return out.Flush()
+}
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds the test cases for a single file
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is a single test
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+// junitFailure describes why a test failed
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Details string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, with a test suite for each file.
+func (r *LuaTestReport) WriteJUnit(w io.Writer) error {
+	root := junitTestSuites{
+		Time:   junitTime(r.Duration),
+		Suites: make([]junitTestSuite, 0, len(r.Files)),
+	}
+
+	for _, file := range r.Files {
+		suite := junitTestSuite{
+			Name:  file.Path,
+			Tests: len(file.Results),
+			Time:  junitTime(file.Duration),
+			Cases: make([]junitTestCase, 0, len(file.Results)),
+		}
+
+		for _, result := range file.Results {
+			testCase := junitTestCase{
+				Name:      result.FullName(),
+				ClassName: strings.TrimSuffix(file.Path, ".lua"),
+				Time:      junitTime(result.Duration),
+			}
+
+			switch result.Status {
+			case LuaTestFailed:
+				suite.Failures++
+				testCase.Failure = &junitFailure{
+					Message: firstLine(result.Message),
+					Details: result.Message,
+				}
+			case LuaTestSkipped:
+				suite.Skipped++
+				testCase.Skipped = &struct{}{}
+			}
+
+			suite.Cases = append(suite.Cases, testCase)
+		}
+
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Skipped += suite.Skipped
+		root.Suites = append(root.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+// format a duration as seconds, as JUnit expects
+func junitTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// the first line of a (possibly) multi-line message
+func firstLine(str string) string {
+	if i := strings.IndexByte(str, '\n'); i >= 0 {
+		return str[:i]
+	}
+
+	return str
+}
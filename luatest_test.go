@@ -0,0 +1,134 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"bytes"
+	"os"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("RunLuaTests()", func() {
+	run := func(files map[string]string) *LuaTestReport {
+		fsys := fstest.MapFS{}
+		for name, src := range files {
+			fsys[name] = &fstest.MapFile{Data: []byte(src)}
+		}
+
+		report, err := RunLuaTests(fsys, "")
+		Ω(err).Should(BeNil())
+
+		return report
+	}
+
+	It("runs the repo's Lua tests", func() {
+		report, err := RunLuaTests(os.DirFS("."), "fib_test.lua")
+		Ω(err).Should(BeNil())
+		Ω(report.Files).Should(HaveLen(1))
+		Ω(report.Count(LuaTestPassed)).Should(Equal(2))
+		Ω(report.OK()).Should(BeTrue())
+	})
+
+	It("records passing, failing and skipped tests", func() {
+		report := run(map[string]string{
+			"a_test.lua": `
+				describe("outer", function()
+					describe("inner", function()
+						it("passes", function() expect({1, {2}}).to_equal({1, {2}}) end)
+						it("fails", function() expect(1).to_equal(2) end)
+						xit("skips", function() end)
+					end)
+				end)
+			`,
+		})
+
+		results := report.Results()
+		Ω(results).Should(HaveLen(3))
+		Ω(results[0].FullName()).Should(Equal("outer inner passes"))
+		Ω(results[0].Status).Should(Equal(LuaTestPassed))
+		Ω(results[1].Status).Should(Equal(LuaTestFailed))
+		Ω(results[1].Message).Should(ContainSubstring("a_test.lua:5: expected 1 to equal 2"))
+		Ω(results[2].Status).Should(Equal(LuaTestSkipped))
+		Ω(report.OK()).Should(BeFalse())
+	})
+
+	It("isolates each file in its own engine", func() {
+		report := run(map[string]string{
+			"a_test.lua": `leaked = true; it("sets", function() end)`,
+			"b_test.lua": `it("is clean", function() expect(leaked).to_be_nil() end)`,
+		})
+		Ω(report.OK()).Should(BeTrue())
+	})
+
+	It("runs hooks around each test", func() {
+		report := run(map[string]string{
+			"hooks_test.lua": `
+				local calls = {}
+				describe("hooks", function()
+					before_all(function() table.insert(calls, "before_all") end)
+					before_each(function() table.insert(calls, "before_each") end)
+					after_each(function() table.insert(calls, "after_each") end)
+
+					it("first", function() table.insert(calls, "first") end)
+					it("second", function()
+						expect(calls).to_equal({"before_all", "before_each", "first", "after_each", "before_each"})
+					end)
+				end)
+			`,
+		})
+		Ω(report.OK()).Should(BeTrue())
+	})
+
+	It("fails the file when it can't be loaded", func() {
+		report := run(map[string]string{
+			"broken_test.lua": `describe("oops"`,
+		})
+		Ω(report.Results()).Should(HaveLen(1))
+		Ω(report.Results()[0].Name).Should(Equal("load"))
+		Ω(report.OK()).Should(BeFalse())
+	})
+
+	It("loads modules relative to the file system", func() {
+		report := run(map[string]string{
+			"lib/greet.lua": `return function(name) return "hello " .. name end`,
+			"greet_test.lua": `
+				local greet = require("lib.greet")
+				it("greets", function() expect(greet("world")).to_equal("hello world") end)
+			`,
+		})
+		Ω(report.OK()).Should(BeTrue())
+	})
+
+	Describe("output", func() {
+		var report *LuaTestReport
+
+		BeforeEach(func() {
+			report = run(map[string]string{
+				"out_test.lua": `
+					it("passes", function() end)
+					it("fails", function() error("nope") end)
+				`,
+			})
+		})
+
+		It("writes TAP", func() {
+			buf := new(bytes.Buffer)
+			Ω(report.WriteTAP(buf)).Should(Succeed())
+			Ω(buf.String()).Should(HavePrefix("TAP version 13\n1..2\n"))
+			Ω(buf.String()).Should(ContainSubstring("ok 1 - out_test.lua: passes\n"))
+			Ω(buf.String()).Should(ContainSubstring("not ok 2 - out_test.lua: fails\n"))
+		})
+
+		It("writes JUnit XML", func() {
+			buf := new(bytes.Buffer)
+			Ω(report.WriteJUnit(buf)).Should(Succeed())
+			Ω(buf.String()).Should(ContainSubstring(`<testsuite name="out_test.lua" tests="2" failures="1"`))
+			Ω(buf.String()).Should(ContainSubstring(`<testcase name="fails" classname="out_test"`))
+		})
+	})
+})
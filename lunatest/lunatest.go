@@ -0,0 +1,50 @@
+// Copyright (c) 2020 Brandon Buck
+
+// Package lunatest runs Lua test files as part of `go test`, reporting each
+// Lua test as a subtest.
+package lunatest
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/bbuck/luna"
+)
+
+// Run runs the Lua test files in fsys matching the pattern (see
+// luna.RunLuaTests) and reports each file and test as a subtest of t.
+func Run(t *testing.T, fsys fs.FS, pattern string) {
+	t.Helper()
+	RunWithConfig(t, fsys, pattern, luna.LuaTestConfig{})
+}
+
+// RunWithConfig is like Run, running the files with the given configuration.
+func RunWithConfig(t *testing.T, fsys fs.FS, pattern string, config luna.LuaTestConfig) {
+	t.Helper()
+
+	report, err := luna.RunLuaTestsWithConfig(fsys, pattern, config)
+	if err != nil {
+		t.Fatalf("failed to run Lua tests: %s", err)
+	}
+
+	if len(report.Files) == 0 {
+		t.Logf("no Lua test files matched %q", pattern)
+	}
+
+	for _, file := range report.Files {
+		file := file
+		t.Run(file.Path, func(t *testing.T) {
+			for _, result := range file.Results {
+				result := result
+				t.Run(result.FullName(), func(t *testing.T) {
+					switch result.Status {
+					case luna.LuaTestFailed:
+						t.Error(result.Message)
+					case luna.LuaTestSkipped:
+						t.Skip("skipped with xit")
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2020 Brandon Buck
+
+package lunatest_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/bbuck/luna/lunatest"
+)
+
+func TestRun(t *testing.T) {
+	fsys := fstest.MapFS{
+		"math_test.lua": &fstest.MapFile{
+			Data: []byte(`
+				describe("addition", function()
+					it("adds numbers", function()
+						expect(1 + 1).to_equal(2)
+					end)
+
+					xit("is skipped", function() end)
+				end)
+			`),
+		},
+	}
+
+	lunatest.Run(t, fsys, "")
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"reflect"
+	"strings"
+
+	glua "github.com/yuin/gopher-lua"
+	gluar "layeh.com/gopher-luar"
+)
+
+// the struct tag controlling how fields are exposed to Lua, it's written as
+// `luna:"name,readonly"`. The name replaces the one given by the naming
+// convention, a name of "-" hides the field and readonly raises an error when
+// a script assigns to the field.
+const fieldTagName = "luna"
+
+// fieldTag is the parsed luna struct tag of a field.
+type fieldTag struct {
+	name     string
+	hidden   bool
+	readOnly bool
+}
+
+func parseFieldTag(f reflect.StructField) fieldTag {
+	tag := f.Tag.Get(fieldTagName)
+	if tag == "-" {
+		return fieldTag{hidden: true}
+	}
+
+	parts := strings.Split(tag, ",")
+	ft := fieldTag{name: strings.TrimSpace(parts[0])}
+	for _, option := range parts[1:] {
+		if strings.TrimSpace(option) == "readonly" {
+			ft.readOnly = true
+		}
+	}
+
+	return ft
+}
+
+// lvalueType is used to leave Lua values alone when preparing types.
+var lvalueType = reflect.TypeOf((*glua.LValue)(nil)).Elem()
+
+// naming applies luna struct tags and method renames on top of the field and
+// method transformers of an engine. Read-only fields are enforced by wrapping
+// the __newindex of the pointer metatable for the struct, which has to be in
+// place before a script first sees the struct so types are prepared (their
+// metatables created) as values are passed into the engine.
+type naming struct {
+	engine  *Engine
+	fields  FieldTransformer
+	methods MethodTransformer
+
+	prepared map[reflect.Type]bool
+	readOnly map[reflect.Type]map[string]bool
+	renames  map[reflect.Type]map[string]string
+}
+
+func newNaming(eng *Engine, fields FieldTransformer, methods MethodTransformer) *naming {
+	if fields == nil {
+		fields = luarFieldNames
+	}
+	if methods == nil {
+		methods = luarMethodNames
+	}
+
+	return &naming{
+		engine:   eng,
+		fields:   fields,
+		methods:  methods,
+		prepared: make(map[reflect.Type]bool),
+		readOnly: make(map[reflect.Type]map[string]bool),
+		renames:  make(map[reflect.Type]map[string]string),
+	}
+}
+
+// the names of a field, honoring its luna tag
+func (n *naming) fieldNames(t reflect.Type, f reflect.StructField) []string {
+	n.visitStruct(t)
+
+	tag := parseFieldTag(f)
+	if tag.hidden {
+		return nil
+	}
+
+	names := []string{tag.name}
+	if len(tag.name) == 0 {
+		names = n.fields(t, f)
+	}
+
+	if tag.readOnly {
+		n.markReadOnly(t, names)
+	}
+
+	return names
+}
+
+// the names of a method, honoring the names set for its type
+func (n *naming) methodNames(t reflect.Type, m reflect.Method) []string {
+	base := t
+	if base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+
+	switch name := n.renames[base][m.Name]; name {
+	case "":
+		return n.methods(t, m)
+	case "-":
+		return nil
+	default:
+		return []string{name}
+	}
+}
+
+// record the names of a read-only field, guarding assignments to them
+func (n *naming) markReadOnly(t reflect.Type, names []string) {
+	fields, ok := n.readOnly[t]
+	if !ok {
+		fields = make(map[string]bool)
+		n.readOnly[t] = fields
+		n.guardAssignment(t)
+	}
+
+	for _, name := range names {
+		fields[name] = true
+	}
+}
+
+// wrap the __newindex of the pointer metatable of the struct type to raise an
+// error when assigning read-only fields
+func (n *naming) guardAssignment(t reflect.Type) {
+	l := n.engine.state
+	mt := gluar.MT(l, reflect.Zero(reflect.PtrTo(t)).Interface())
+	newIndex, ok := mt.RawGetString("__newindex").(*glua.LFunction)
+	if !ok || !newIndex.IsG {
+		return
+	}
+
+	set := newIndex.GFunction
+	mt.RawSetString("__newindex", l.NewFunction(func(l *glua.LState) int {
+		key := l.CheckString(2)
+		if n.readOnly[t][key] {
+			l.RaiseError("cannot assign to read-only field %s", key)
+		}
+
+		return set(l)
+	}))
+}
+
+// prepare the types a value passed to the engine exposes, creating the
+// metatables of the structs reachable from it.
+func (n *naming) prepare(t reflect.Type) {
+	if t == nil || n.prepared[t] || t.Implements(lvalueType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Chan:
+		n.prepared[t] = true
+		n.prepare(t.Elem())
+	case reflect.Map:
+		n.prepared[t] = true
+		n.prepare(t.Key())
+		n.prepare(t.Elem())
+	case reflect.Func:
+		n.prepared[t] = true
+		for i := 0; i < t.NumIn(); i++ {
+			n.prepare(t.In(i))
+		}
+		for i := 0; i < t.NumOut(); i++ {
+			n.prepare(t.Out(i))
+		}
+	case reflect.Struct:
+		n.visitStruct(t)
+		gluar.MT(n.engine.state, reflect.Zero(t).Interface())
+	}
+}
+
+// prepare the types the struct's fields and methods expose, the struct itself
+// is left to gopher-luar.
+func (n *naming) visitStruct(t reflect.Type) {
+	if n.prepared[t] {
+		return
+	}
+	n.prepared[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); len(f.PkgPath) == 0 || f.Anonymous {
+			n.prepare(f.Type)
+		}
+	}
+	for _, typ := range []reflect.Type{t, reflect.PtrTo(t)} {
+		for i := 0; i < typ.NumMethod(); i++ {
+			n.prepare(typ.Method(i).Type)
+		}
+	}
+}
+
+// SetMethodNames changes the names the methods of a type have in Lua, as
+// methods can't carry struct tags. Names maps the Go name of a method to its
+// name in Lua, a name of "-" hides the method. The names apply to the type of
+// the value given and pointers to it (or the type pointed to when given a
+// pointer) and take effect for values already passed to the engine.
+func (e *Engine) SetMethodNames(val interface{}, names map[string]string) {
+	t := reflect.TypeOf(val)
+	if t == nil {
+		return
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	types := []reflect.Type{t, reflect.PtrTo(t)}
+	previous := make([]map[string][]string, len(types))
+	for i, typ := range types {
+		previous[i] = make(map[string][]string)
+		for j := 0; j < typ.NumMethod(); j++ {
+			m := typ.Method(j)
+			if _, ok := names[m.Name]; ok {
+				previous[i][m.Name] = e.naming.methodNames(typ, m)
+			}
+		}
+	}
+
+	renames, ok := e.naming.renames[t]
+	if !ok {
+		renames = make(map[string]string)
+		e.naming.renames[t] = renames
+	}
+	for goName, luaName := range names {
+		renames[goName] = luaName
+	}
+
+	for i, typ := range types {
+		e.renameMethods(typ, previous[i])
+	}
+}
+
+// move the methods of the type from their previous names to their current
+// ones in its metatable
+func (e *Engine) renameMethods(t reflect.Type, previous map[string][]string) {
+	mt := gluar.MT(e.state, reflect.Zero(t).Interface())
+	if mt == nil {
+		return
+	}
+	methods, ok := mt.RawGetString("methods").(*glua.LTable)
+	if !ok {
+		return
+	}
+
+	for name, prevNames := range previous {
+		var fn glua.LValue = glua.LNil
+		for _, prev := range prevNames {
+			if val := methods.RawGetString(prev); val != glua.LNil {
+				fn = val
+			}
+			methods.RawSetString(prev, glua.LNil)
+		}
+		if fn == glua.LNil {
+			// the metatable was just created with the current names
+			continue
+		}
+
+		m, _ := t.MethodByName(name)
+		for _, luaName := range e.naming.methodNames(t, m) {
+			methods.RawSetString(luaName, fn)
+		}
+	}
+}
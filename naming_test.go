@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+type taggedStats struct {
+	Level int `luna:",readonly"`
+}
+
+type taggedMob struct {
+	Name      string
+	HitPoints int    `luna:"hp"`
+	Secret    string `luna:"-"`
+	ID        int    `luna:"id,readonly"`
+	Stats     *taggedStats
+}
+
+func (m *taggedMob) Attack() string {
+	return m.Name + " attacks"
+}
+
+func (m *taggedMob) Debug() string {
+	return "debugging"
+}
+
+var _ = Describe("Naming", func() {
+	var (
+		eng *Engine
+		mob *taggedMob
+	)
+
+	BeforeEach(func() {
+		eng = NewEngine()
+		mob = &taggedMob{Name: "orc", HitPoints: 10, Secret: "shh", ID: 7, Stats: &taggedStats{Level: 3}}
+		eng.SetGlobal("mob", mob)
+	})
+
+	AfterEach(func() {
+		eng.Close()
+	})
+
+	Describe("luna struct tags", func() {
+		It("renames fields", func() {
+			Ω(eng.DoString(`mob.hp = mob.hp - 1; result = mob.hit_points`)).Should(Succeed())
+			Ω(mob.HitPoints).Should(Equal(9))
+			Ω(eng.GetGlobal("result").IsNil()).Should(BeTrue())
+		})
+
+		It("hides fields", func() {
+			Ω(eng.DoString(`result = mob.secret`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").IsNil()).Should(BeTrue())
+			Ω(eng.DoString(`mob.secret = "changed"`)).ShouldNot(Succeed())
+			Ω(mob.Secret).Should(Equal("shh"))
+		})
+
+		It("blocks assigning read-only fields", func() {
+			Ω(eng.DoString(`result = mob.id`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").AsNumber()).Should(BeEquivalentTo(7))
+
+			err := eng.DoString(`mob.id = 8`)
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring("cannot assign to read-only field id"))
+			Ω(mob.ID).Should(Equal(7))
+		})
+
+		It("blocks assigning read-only fields of nested structs", func() {
+			Ω(eng.DoString(`mob.stats.level = 4`)).ShouldNot(Succeed())
+			Ω(mob.Stats.Level).Should(Equal(3))
+		})
+	})
+
+	Describe("SetMethodNames()", func() {
+		It("renames and hides methods", func() {
+			eng.SetMethodNames(mob, map[string]string{
+				"Attack": "strike",
+				"Debug":  "-",
+			})
+
+			Ω(eng.DoString(`result = mob:strike()`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").AsString()).Should(Equal("orc attacks"))
+			Ω(eng.DoString(`result = mob.attack`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").IsNil()).Should(BeTrue())
+			Ω(eng.DoString(`mob:debug()`)).ShouldNot(Succeed())
+		})
+
+		It("applies to types not yet passed to the engine", func() {
+			eng.SetMethodNames(taggedStats{}, map[string]string{})
+			eng.SetMethodNames(&taggedMob{}, map[string]string{"Attack": "hit"})
+
+			eng.SetGlobal("other", &taggedMob{Name: "troll"})
+			Ω(eng.DoString(`result = other:hit()`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").AsString()).Should(Equal("troll attacks"))
+		})
+	})
+})
@@ -3,9 +3,16 @@
 package luna
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"runtime"
+	"runtime/debug"
 	"sync"
 	"time"
+
+	glua "github.com/yuin/gopher-lua"
 )
 
 // EnginePoolKey is the key used in the engine Meta field to store which Pool
@@ -16,16 +23,75 @@ const EnginePoolKey = "engine pool"
 // EnginePoolMetaKey is a string value for associating the pool with an engine
 const EnginePoolMetaKey = "engine pool"
 
+// ErrPoolClosed is returned when attempting to fetch an engine from a pool that
+// has already been shut down.
+var ErrPoolClosed = errors.New("engine pool has been shut down")
+
+// ErrEngineFatal can be wrapped by errors returned from the function given to
+// EnginePool.With to signal that the engine is no longer fit for use and should
+// be discarded rather than returned to the pool.
+var ErrEngineFatal = errors.New("engine is no longer usable")
+
 // EngineMutator will modify an Engine before it goes into the pool. This can
 // run any number of scripts as necessary such as registring libraries,
 // executing code, etc...
 type EngineMutator func(*Engine)
 
+// EngineInitializer behaves like an EngineMutator except that it can report a
+// failure. An engine whose initializer returns an error is closed and never
+// makes it into the pool.
+type EngineInitializer func(*Engine) error
+
+// EngineFactory constructs a brand new engine for a pool. This allows pools to
+// be built from engines with custom options, or with any setup that must happen
+// before the pool takes ownership of the engine.
+type EngineFactory func() (*Engine, error)
+
+// EnginePoolConfig provides the means for configuring an EnginePool.
+type EnginePoolConfig struct {
+	// MaxPoolSize is the maximum number of engines the pool will create.
+	MaxPoolSize int
+
+	// Options are used to create engines when no Factory is given, if nil
+	// then the default engine options are used.
+	Options *EngineOptions
+
+	// Factory is used to construct new engines (optional).
+	Factory EngineFactory
+
+	// Mutator and Initializer are run (in that order) against every new engine
+	// (both are optional).
+	Mutator     EngineMutator
+	Initializer EngineInitializer
+
+	// MaxRetries is the number of additional attempts made to build an engine
+	// when construction fails.
+	MaxRetries int
+
+	// RetryBackoff is the delay before the first retry, it doubles after each
+	// failed attempt.
+	RetryBackoff time.Duration
+
+	// Debug enables recording the stack trace of every checkout so that
+	// engines that are never released can be reported.
+	Debug bool
+
+	// LeakHandler is called with the checkout stack trace of a leaked engine
+	// when Debug is enabled, by default leaks are written to the standard
+	// logger.
+	LeakHandler func(stack []byte)
+
+	// Coverage records the coverage of every engine in the pool when given,
+	// it's enabled before the mutator and initializer run.
+	Coverage *Coverage
+}
+
 // PooledEngine wraps a Lua engine. It's purpose is provide a means with which
 // to return the engine to the EnginePool when it's not longer being used.
 type PooledEngine struct {
 	*Engine
-	pool *EnginePool
+	pool          *EnginePool
+	checkoutStack []byte
 }
 
 // Release will push the engine back into the queue for available engines for
@@ -33,110 +99,315 @@ type PooledEngine struct {
 // to prevent continued usage of the engine.
 func (pe *PooledEngine) Release() {
 	if pe.Engine != nil {
-		if !pe.pool.closed {
-			pe.pool.engines <- pe.Engine
-		}
+		pe.pool.put(pe.Engine)
+		pe.Engine = nil
+		runtime.SetFinalizer(pe, nil)
+	}
+}
+
+// Discard closes the engine and removes it from the pool instead of returning
+// it, use this when the engine has been left in a bad state. The pool will
+// create a replacement engine when one is needed.
+func (pe *PooledEngine) Discard() {
+	if pe.Engine != nil {
+		pe.pool.destroyEngine(pe.Engine)
 		pe.Engine = nil
+		runtime.SetFinalizer(pe, nil)
 	}
 }
 
+// called by the garbage collector when a PooledEngine was never released
+func (pe *PooledEngine) finalize() {
+	if pe.Engine == nil {
+		return
+	}
+
+	if pe.checkoutStack != nil {
+		pe.pool.reportLeak(pe.checkoutStack)
+	}
+	pe.Release()
+}
+
 // EnginePool represents a grouping of predefined/preloaded engines that can be
 // grabbed for use when Lua scripts need to run.
 type EnginePool struct {
 	MaxPoolSize   int
+	Options       *EngineOptions
+	Factory       EngineFactory
 	Mutator       EngineMutator
+	Initializer   EngineInitializer
+	MaxRetries    int
+	RetryBackoff  time.Duration
+	Debug         bool
+	LeakHandler   func(stack []byte)
+	Coverage      *Coverage
 	numEngines    int
 	engines       chan *Engine
 	cachedEngines []*Engine
 	mutex         *sync.Mutex
 	closed        bool
+	limiter       engineLimiter
+	generation    int
+	engineStates  map[*Engine]*pooledEngineState
+	updates       []func(*Engine)
+	updatesBase   int
+	baked         []func(*Engine)
+}
+
+// pooledEngineState tracks pool bookkeeping for a single engine
+type pooledEngineState struct {
+	// generation is the pool generation the engine was built in
+	generation int
+
+	// applied is the number of broadcast updates run against the engine,
+	// counting from the first update broadcast to the pool
+	applied int
+}
+
+// engineLimiter is consulted by a pool before it creates a new engine and is
+// notified when engines are destroyed, allowing several pools to share a single
+// limit on the number of live engines.
+type engineLimiter interface {
+	acquire(*EnginePool) bool
+	release(*EnginePool, int)
 }
 
 // NewEnginePool constructs a new pool with the specific maximum size and the
-// engine mutator. It will seed the pool with one engine.
+// engine mutator. It will seed the pool with one engine, panicking if the
+// mutator panics while building it. Use NewEnginePoolWithConfig to have that
+// reported as an error instead.
 func NewEnginePool(poolSize int, mutator EngineMutator) *EnginePool {
+	ep, err := NewEnginePoolWithConfig(EnginePoolConfig{
+		MaxPoolSize: poolSize,
+		Mutator:     mutator,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return ep
+}
+
+// NewEnginePoolWithConfig constructs a new pool from the provided
+// configuration. The pool is seeded with one engine, if that engine cannot be
+// built then the error is returned and no pool is created.
+func NewEnginePoolWithConfig(config EnginePoolConfig) (*EnginePool, error) {
+	return newEnginePool(config, nil)
+}
+
+// construct a pool whose engine count is (optionally) governed by the given
+// limiter.
+func newEnginePool(config EnginePoolConfig, limiter engineLimiter) (*EnginePool, error) {
+	poolSize := config.MaxPoolSize
 	if poolSize == 0 {
 		poolSize = 1
 	}
 	ep := &EnginePool{
 		MaxPoolSize:   poolSize,
-		Mutator:       mutator,
+		Options:       config.Options,
+		Factory:       config.Factory,
+		Mutator:       config.Mutator,
+		Initializer:   config.Initializer,
+		MaxRetries:    config.MaxRetries,
+		RetryBackoff:  config.RetryBackoff,
+		Debug:         config.Debug,
+		LeakHandler:   config.LeakHandler,
+		Coverage:      config.Coverage,
 		numEngines:    1,
 		engines:       make(chan *Engine, poolSize),
 		mutex:         new(sync.Mutex),
 		cachedEngines: make([]*Engine, 0),
 		closed:        false,
+		limiter:       limiter,
+		engineStates:  make(map[*Engine]*pooledEngineState),
 	}
-	ep.engines <- ep.generateEngine()
 
-	return ep
+	if limiter != nil && !limiter.acquire(ep) {
+		return nil, ErrPoolSetFull
+	}
+
+	eng, err := ep.generateEngine()
+	if err != nil {
+		if limiter != nil {
+			limiter.release(ep, 1)
+		}
+
+		return nil, err
+	}
+	ep.engines <- eng
+
+	return ep, nil
 }
 
 // Len will return the number of engines that have been spawned during the
 // execution fo the pool.
 func (ep *EnginePool) Len() int {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+
 	return len(ep.cachedEngines)
 }
 
 // Get will fetch the next available engine from the EnginePool. If no engines
 // are available and the maximum number of active engines in the pool have been
 // created yet then the spawner will be invoked to spawn a new engine and return
-// that.
-func (ep *EnginePool) Get() *PooledEngine {
-	if ep.closed {
-		return nil
-	}
+// that. Any failure to construct a new engine is returned.
+func (ep *EnginePool) Get() (*PooledEngine, error) {
+	return ep.GetContext(context.Background())
+}
 
-	if ep.MaxPoolSize == 0 {
-		ep.MaxPoolSize = 1
+// GetContext behaves like Get except that waiting for an engine to become
+// available is abandoned when the context is done.
+func (ep *EnginePool) GetContext(ctx context.Context) (*PooledEngine, error) {
+	if ep.isClosed() {
+		return nil, ErrPoolClosed
 	}
 
-	var engine *Engine
-	select {
-	case eng := <-ep.engines:
-		engine = eng
-	case <-time.After(250 * time.Millisecond):
-		if ep.Len() < ep.MaxPoolSize {
-			ep.mutex.Lock()
-			engine = ep.generateEngine()
-			ep.mutex.Unlock()
-		} else {
-			engine = <-ep.engines
+	var (
+		engine *Engine
+		err    error
+	)
+	for {
+		engine, err = ep.checkout(ctx)
+		if err != nil {
+			return nil, err
 		}
-	}
-	// if len(ep.engines) > 0 {
 
-	// } else if uint8(ep.Len()) < ep.MaxPoolSize {
-	// 	ep.mutex.Lock()
-	// 	engine = ep.generateEngine()
-	// 	ep.mutex.Unlock()
-	// } else {
-	// 	engine = <-ep.engines
-	// }
+		if !ep.isStale(engine) {
+			ep.applyUpdates(engine)
+
+			break
+		}
+
+		// the scripts this engine was built from have changed, so we replace
+		// it with a fresh one.
+		ep.destroyEngine(engine)
+		if ep.reserveEngine() {
+			engine, err = ep.generateEngine()
+			if err != nil {
+				ep.unreserveEngine()
+
+				return nil, err
+			}
+			ep.applyUpdates(engine)
+
+			break
+		}
+	}
 
 	pe := &PooledEngine{
 		Engine: engine,
 		pool:   ep,
 	}
+	if ep.Debug {
+		pe.checkoutStack = debug.Stack()
+	}
 	// NOTE: precaution to prevent leaks for long running servers, not a perfect
-	//       solution. BE DILIGENT AND RELEASE YOUR ENGINES!!
-	runtime.SetFinalizer(pe, (*PooledEngine).Release)
+	//       solution. BE DILIGENT AND RELEASE YOUR ENGINES!! (or use With)
+	runtime.SetFinalizer(pe, (*PooledEngine).finalize)
+
+	return pe, nil
+}
+
+// MarkStale flags every engine currently in the pool as out of date. Stale
+// engines are closed and rebuilt (running the pool's mutators again) the next
+// time they would be checked out, this is typically triggered by a
+// ScriptWatcher when the scripts the pool loads have changed.
+func (ep *EnginePool) MarkStale() {
+	ep.mutex.Lock()
+	ep.generation++
+	ep.mutex.Unlock()
+}
+
+// ReloadOn marks the pool stale whenever the watcher reports a change.
+func (ep *EnginePool) ReloadOn(w *ScriptWatcher) {
+	w.OnChange(func([]string) {
+		ep.MarkStale()
+	})
+}
 
-	return pe
+// With checks out an engine, calls fn with it and then always gives the engine
+// back, even if fn panics. The context is attached to the engine while fn runs
+// so long running scripts are stopped when it's done. If fn panics, or returns
+// an error that wraps ErrEngineFatal, the engine is discarded instead of being
+// returned to the pool.
+func (ep *EnginePool) With(ctx context.Context, fn func(*Engine) error) (err error) {
+	pe, err := ep.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	pe.SetContext(ctx)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: panic while using pooled engine: %v\n%s", ErrEngineFatal, r, debug.Stack())
+		}
+
+		pe.RemoveContext()
+		if isFatalEngineError(err) {
+			pe.Discard()
+		} else {
+			pe.Release()
+		}
+	}()
+
+	return fn(pe.Engine)
 }
 
 // EachEngine will call the provided handler with each engine. IN NO WAY SHOULD
-// THIS BE USED TO UNDERMINE GET, THIS IS FOR MAINTENANCE.
+// THIS BE USED TO UNDERMINE GET, THIS IS FOR MAINTENANCE. Engines may be in use
+// on other goroutines when the handler is called, use Broadcast to safely
+// update every engine in the pool.
 func (ep *EnginePool) EachEngine(fn func(*Engine)) {
-	for _, eng := range ep.cachedEngines {
+	ep.mutex.Lock()
+	engines := append([]*Engine{}, ep.cachedEngines...)
+	ep.mutex.Unlock()
+
+	for _, eng := range engines {
 		fn(eng)
 	}
 }
 
+// Broadcast applies an update to every engine in the pool, such as setting a
+// new configuration global. Idle engines are updated immediately, engines that
+// are checked out are updated when they are released. Every engine observes
+// the update before it's next checked out, including engines created later,
+// and updates are always applied in the order they were broadcast. Once every
+// engine has applied an update it becomes part of building new engines (run
+// after the Mutator), so only updates still in flight are tracked per engine.
+func (ep *EnginePool) Broadcast(fn func(*Engine)) {
+	ep.mutex.Lock()
+	if ep.closed {
+		ep.mutex.Unlock()
+
+		return
+	}
+	ep.updates = append(ep.updates, fn)
+
+	// take idle engines out of circulation while they're updated
+	idle := make([]*Engine, 0, len(ep.engines))
+	for draining := true; draining; {
+		select {
+		case eng := <-ep.engines:
+			idle = append(idle, eng)
+		default:
+			draining = false
+		}
+	}
+	ep.mutex.Unlock()
+
+	for _, eng := range idle {
+		ep.put(eng)
+	}
+}
+
 // Shutdown will empty the channel, close all generated engines and mark the
 // pool closed.
 func (ep *EnginePool) Shutdown() {
+	ep.mutex.Lock()
 	if ep.closed {
+		ep.mutex.Unlock()
+
 		return
 	}
 
@@ -151,17 +422,352 @@ func (ep *EnginePool) Shutdown() {
 	for _, eng := range ep.cachedEngines {
 		eng.Close()
 	}
+	closed := ep.numEngines
+	ep.cachedEngines = nil
+	ep.numEngines = 0
+	ep.mutex.Unlock()
+
+	if ep.limiter != nil {
+		ep.limiter.release(ep, closed)
+	}
+}
+
+// retire closes the pool to new checkouts and closes all idle engines. Unlike
+// Shutdown engines that are currently checked out are left alone, they will be
+// closed as they are released.
+func (ep *EnginePool) retire() {
+	ep.mutex.Lock()
+	if ep.closed {
+		ep.mutex.Unlock()
+
+		return
+	}
+
+	ep.closed = true
+
+	close(ep.engines)
+
+	closed := 0
+	for eng := range ep.engines {
+		ep.removeEngine(eng)
+		eng.Close()
+		closed++
+	}
+	ep.mutex.Unlock()
+
+	if ep.limiter != nil && closed > 0 {
+		ep.limiter.release(ep, closed)
+	}
 }
 
-// create a new engine for use in the pool
-func (ep *EnginePool) generateEngine() *Engine {
-	eng := NewEngine()
+// isIdle reports whether every engine the pool has created is currently
+// waiting to be checked out.
+func (ep *EnginePool) isIdle() bool {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+
+	return !ep.closed && len(ep.engines) == ep.numEngines
+}
+
+// determine if the pool has been shut down or retired
+func (ep *EnginePool) isClosed() bool {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+
+	return ep.closed
+}
+
+// put returns an engine to the pool, if the pool has been closed while the
+// engine was checked out then the engine is closed instead.
+func (ep *EnginePool) put(eng *Engine) {
+	ep.applyUpdates(eng)
+
+	ep.mutex.Lock()
+	if !ep.closed {
+		ep.engines <- eng
+		ep.mutex.Unlock()
+
+		return
+	}
+
+	ep.mutex.Unlock()
+
+	ep.destroyEngine(eng)
+}
+
+// destroyEngine closes the engine and forgets about it, freeing up room in the
+// pool for a replacement.
+func (ep *EnginePool) destroyEngine(eng *Engine) {
+	ep.mutex.Lock()
+	removed := ep.removeEngine(eng)
+	ep.mutex.Unlock()
+
+	if removed {
+		eng.Close()
+		if ep.limiter != nil {
+			ep.limiter.release(ep, 1)
+		}
+	}
+}
+
+// report an engine that was garbage collected without being released
+func (ep *EnginePool) reportLeak(stack []byte) {
+	if ep.LeakHandler != nil {
+		ep.LeakHandler(stack)
+
+		return
+	}
+
+	log.Printf("luna: pooled engine was never released, it was checked out at:\n%s", stack)
+}
+
+// drop the engine from the set of engines the pool has created, the pool mutex
+// must be held when calling this method.
+func (ep *EnginePool) removeEngine(eng *Engine) bool {
+	for i, cached := range ep.cachedEngines {
+		if cached == eng {
+			ep.cachedEngines = append(ep.cachedEngines[:i], ep.cachedEngines[i+1:]...)
+			delete(ep.engineStates, eng)
+			ep.numEngines--
+			ep.compactUpdates()
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// wait for an idle engine, or create one if the pool has room for it
+func (ep *EnginePool) checkout(ctx context.Context) (*Engine, error) {
+	select {
+	case engine, ok := <-ep.engines:
+		if !ok {
+			return nil, ErrPoolClosed
+		}
+
+		return engine, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(250 * time.Millisecond):
+		if ep.reserveEngine() {
+			engine, err := ep.generateEngine()
+			if err != nil {
+				ep.unreserveEngine()
+
+				return nil, err
+			}
+
+			return engine, nil
+		}
+	}
+
+	select {
+	case engine, ok := <-ep.engines:
+		if !ok {
+			return nil, ErrPoolClosed
+		}
+
+		return engine, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run any broadcast updates the engine hasn't seen yet, the caller must have
+// exclusive use of the engine.
+func (ep *EnginePool) applyUpdates(eng *Engine) {
+	ep.mutex.Lock()
+	state, ok := ep.engineStates[eng]
+	if !ok || state.applied == ep.updatesBase+len(ep.updates) {
+		ep.mutex.Unlock()
+
+		return
+	}
+	pending := ep.updatesSince(state.applied)
+	state.applied = ep.updatesBase + len(ep.updates)
+	ep.compactUpdates()
+	ep.mutex.Unlock()
+
+	for _, fn := range pending {
+		fn(eng)
+	}
+}
+
+// the updates after the first n broadcast, the pool mutex must be held
+func (ep *EnginePool) updatesSince(n int) []func(*Engine) {
+	if n >= ep.updatesBase {
+		return append([]func(*Engine){}, ep.updates[n-ep.updatesBase:]...)
+	}
+
+	pending := append([]func(*Engine){}, ep.baked[n:]...)
+
+	return append(pending, ep.updates...)
+}
+
+// move the updates every engine has applied out of the log and into the
+// updates new engines are built with, the pool mutex must be held
+func (ep *EnginePool) compactUpdates() {
+	oldest := ep.updatesBase + len(ep.updates)
+	for _, state := range ep.engineStates {
+		if state.applied < oldest {
+			oldest = state.applied
+		}
+	}
+	if oldest <= ep.updatesBase {
+		return
+	}
+
+	done := oldest - ep.updatesBase
+	ep.baked = append(ep.baked, ep.updates[:done]...)
+	ep.updates = append([]func(*Engine){}, ep.updates[done:]...)
+	ep.updatesBase = oldest
+}
+
+// determine if the engine was built before the pool was last marked stale
+func (ep *EnginePool) isStale(eng *Engine) bool {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+
+	state, ok := ep.engineStates[eng]
+
+	return ok && state.generation < ep.generation
+}
+
+// claim a slot for a new engine, returns false if the pool is already at its
+// maximum size.
+func (ep *EnginePool) reserveEngine() bool {
+	ep.mutex.Lock()
+	if ep.closed || ep.numEngines >= ep.MaxPoolSize {
+		ep.mutex.Unlock()
+
+		return false
+	}
+	ep.numEngines++
+	ep.mutex.Unlock()
+
+	if ep.limiter != nil && !ep.limiter.acquire(ep) {
+		ep.mutex.Lock()
+		ep.numEngines--
+		ep.mutex.Unlock()
+
+		return false
+	}
+
+	return true
+}
+
+// give back a slot claimed by reserveEngine for an engine that was never built
+func (ep *EnginePool) unreserveEngine() {
+	ep.mutex.Lock()
+	ep.numEngines--
+	ep.mutex.Unlock()
+
+	if ep.limiter != nil {
+		ep.limiter.release(ep, 1)
+	}
+}
+
+// create a new engine for use in the pool, retrying with backoff if the pool
+// has been configured to do so.
+func (ep *EnginePool) generateEngine() (*Engine, error) {
+	backoff := ep.RetryBackoff
+	attempts := ep.MaxRetries + 1
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 && backoff > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var (
+			eng     *Engine
+			applied int
+		)
+		eng, applied, err = ep.buildEngine()
+		if err == nil {
+			ep.mutex.Lock()
+			ep.cachedEngines = append(ep.cachedEngines, eng)
+			ep.engineStates[eng] = &pooledEngineState{generation: ep.generation, applied: applied}
+			ep.mutex.Unlock()
+
+			return eng, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to build pooled engine after %d attempt(s): %w", attempts, err)
+}
+
+// construct and prepare a single engine, panics raised by the factory or the
+// mutators are reported as errors. The number of broadcast updates run while
+// building the engine is returned with it.
+func (ep *EnginePool) buildEngine() (eng *Engine, applied int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if eng != nil {
+				eng.Close()
+			}
+			eng = nil
+			applied = 0
+			err = fmt.Errorf("panic while building engine: %v", r)
+		}
+	}()
+
+	switch {
+	case ep.Factory != nil:
+		eng, err = ep.Factory()
+		if err != nil {
+			return nil, 0, err
+		}
+	case ep.Options != nil:
+		eng = NewEngineWithOptions(*ep.Options)
+	default:
+		eng = NewEngine()
+	}
 	eng.Meta[EnginePoolKey] = ep
-	ep.cachedEngines = append(ep.cachedEngines, eng)
+
+	if ep.Coverage != nil {
+		eng.EnableCoverage(ep.Coverage)
+	}
 
 	if ep.Mutator != nil {
 		ep.Mutator(eng)
 	}
 
-	return eng
+	ep.mutex.Lock()
+	baked := ep.baked
+	ep.mutex.Unlock()
+	for _, fn := range baked {
+		fn(eng)
+	}
+
+	if ep.Initializer != nil {
+		if err = ep.Initializer(eng); err != nil {
+			eng.Close()
+
+			return nil, 0, err
+		}
+	}
+
+	return eng, len(baked), nil
+}
+
+// determine if an error returned while using a pooled engine means the engine
+// can no longer be trusted.
+func isFatalEngineError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrEngineFatal) {
+		return true
+	}
+
+	var apiErr *glua.ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Type == glua.ApiErrorPanic
+	}
+
+	return false
 }
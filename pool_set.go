@@ -0,0 +1,259 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// ErrPoolSetFull is returned when a PoolSet cannot create another engine
+// without exceeding its engine limit and there are no idle pools that can be
+// evicted to make room for it.
+var ErrPoolSetFull = errors.New("pool set has reached its engine limit")
+
+// PoolConfigFunc returns the configuration used to build the pool for the
+// given key (such as a tenant or script bundle ID) at the given bundle version.
+type PoolConfigFunc func(key, version string) (EnginePoolConfig, error)
+
+// PoolSetConfig provides the means for configuring a PoolSet.
+type PoolSetConfig struct {
+	// MaxEngines is the maximum number of engines that can exist across every
+	// pool in the set, a value of 0 means there is no limit.
+	MaxEngines int
+
+	// PoolConfig is used to lazily build the pool for a key the first time it
+	// is requested, or when the version of the key's bundle changes.
+	PoolConfig PoolConfigFunc
+}
+
+// PoolSet manages a group of EnginePools keyed by some identifier, such as a
+// tenant or script bundle ID. Pools are created on first use and the total
+// number of engines across all pools is capped, when the cap is reached the
+// least recently used idle pools are evicted to make room.
+type PoolSet struct {
+	MaxEngines int
+	PoolConfig PoolConfigFunc
+	pools      map[string]*list.Element
+	lru        *list.List
+	engines    int
+	mutex      *sync.Mutex
+	closed     bool
+}
+
+// entry in the PoolSet LRU list
+type poolSetEntry struct {
+	key     string
+	version string
+	pool    *EnginePool
+}
+
+// NewPoolSet creates a new, empty, PoolSet from the given configuration.
+func NewPoolSet(config PoolSetConfig) *PoolSet {
+	return &PoolSet{
+		MaxEngines: config.MaxEngines,
+		PoolConfig: config.PoolConfig,
+		pools:      make(map[string]*list.Element),
+		lru:        list.New(),
+		mutex:      new(sync.Mutex),
+	}
+}
+
+// Get fetches an engine from the pool associated with the key, creating the
+// pool if it doesn't exist or rebuilding it if its bundle version has changed.
+func (ps *PoolSet) Get(key, version string) (*PooledEngine, error) {
+	var err error
+	// a pool can be evicted between being looked up and being used, so we try
+	// a few times before giving up.
+	for attempt := 0; attempt < 3; attempt++ {
+		var pool *EnginePool
+		pool, err = ps.Pool(key, version)
+		if err != nil {
+			return nil, err
+		}
+
+		var pe *PooledEngine
+		pe, err = pool.Get()
+		if !errors.Is(err, ErrPoolClosed) {
+			return pe, err
+		}
+	}
+
+	return nil, err
+}
+
+// Pool returns the pool associated with the key at the given version. If a
+// pool exists for an older version of the bundle it is retired, engines that
+// are checked out from it continue to work and are closed when released.
+func (ps *PoolSet) Pool(key, version string) (*EnginePool, error) {
+	ps.mutex.Lock()
+	if ps.closed {
+		ps.mutex.Unlock()
+
+		return nil, ErrPoolClosed
+	}
+
+	var stale *EnginePool
+	if elem, ok := ps.pools[key]; ok {
+		entry := elem.Value.(*poolSetEntry)
+		if entry.version == version {
+			ps.lru.MoveToFront(elem)
+			ps.mutex.Unlock()
+
+			return entry.pool, nil
+		}
+		ps.remove(elem)
+		stale = entry.pool
+	}
+	ps.mutex.Unlock()
+
+	if stale != nil {
+		stale.retire()
+	}
+
+	config, err := ps.PoolConfig(key, version)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := newEnginePool(config, ps)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.mutex.Lock()
+	if elem, ok := ps.pools[key]; ok {
+		entry := elem.Value.(*poolSetEntry)
+		if entry.version == version {
+			// another caller beat us to building this pool
+			ps.lru.MoveToFront(elem)
+			ps.mutex.Unlock()
+			pool.Shutdown()
+
+			return entry.pool, nil
+		}
+		ps.remove(elem)
+		stale = entry.pool
+	} else {
+		stale = nil
+	}
+	ps.pools[key] = ps.lru.PushFront(&poolSetEntry{
+		key:     key,
+		version: version,
+		pool:    pool,
+	})
+	ps.mutex.Unlock()
+
+	if stale != nil {
+		stale.retire()
+	}
+
+	return pool, nil
+}
+
+// Evict removes the pool associated with the key from the set, idle engines
+// are closed immediately and checked out engines are closed when released.
+func (ps *PoolSet) Evict(key string) {
+	ps.mutex.Lock()
+	elem, ok := ps.pools[key]
+	if !ok {
+		ps.mutex.Unlock()
+
+		return
+	}
+	ps.remove(elem)
+	ps.mutex.Unlock()
+
+	elem.Value.(*poolSetEntry).pool.retire()
+}
+
+// Len returns the number of pools currently in the set.
+func (ps *PoolSet) Len() int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	return len(ps.pools)
+}
+
+// EngineCount returns the number of live engines across all pools in the set.
+func (ps *PoolSet) EngineCount() int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	return ps.engines
+}
+
+// Shutdown shuts down every pool in the set and prevents any new pools from
+// being created.
+func (ps *PoolSet) Shutdown() {
+	ps.mutex.Lock()
+	if ps.closed {
+		ps.mutex.Unlock()
+
+		return
+	}
+	ps.closed = true
+
+	pools := make([]*EnginePool, 0, len(ps.pools))
+	for _, elem := range ps.pools {
+		pools = append(pools, elem.Value.(*poolSetEntry).pool)
+	}
+	ps.pools = make(map[string]*list.Element)
+	ps.lru.Init()
+	ps.mutex.Unlock()
+
+	for _, pool := range pools {
+		pool.Shutdown()
+	}
+}
+
+// acquire implements engineLimiter, claiming room for one more engine and
+// evicting idle pools as necessary.
+func (ps *PoolSet) acquire(requester *EnginePool) bool {
+	ps.mutex.Lock()
+	if ps.MaxEngines <= 0 || ps.engines < ps.MaxEngines {
+		ps.engines++
+		ps.mutex.Unlock()
+
+		return true
+	}
+
+	var victim *EnginePool
+	for elem := ps.lru.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*poolSetEntry)
+		if entry.pool != requester && entry.pool.isIdle() {
+			ps.remove(elem)
+			victim = entry.pool
+
+			break
+		}
+	}
+
+	if victim == nil {
+		ps.mutex.Unlock()
+
+		return false
+	}
+	ps.engines++
+	ps.mutex.Unlock()
+
+	victim.retire()
+
+	return true
+}
+
+// release implements engineLimiter, returning room for closed engines.
+func (ps *PoolSet) release(_ *EnginePool, n int) {
+	ps.mutex.Lock()
+	ps.engines -= n
+	ps.mutex.Unlock()
+}
+
+// remove an element from the lru list and the pool map, the PoolSet mutex must
+// be held when calling this.
+func (ps *PoolSet) remove(elem *list.Element) {
+	entry := elem.Value.(*poolSetEntry)
+	delete(ps.pools, entry.key)
+	ps.lru.Remove(elem)
+}
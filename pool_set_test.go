@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("PoolSet", func() {
+	var (
+		set      *PoolSet
+		versions map[string]string
+	)
+
+	BeforeEach(func() {
+		versions = make(map[string]string)
+		set = NewPoolSet(PoolSetConfig{
+			MaxEngines: 2,
+			PoolConfig: func(key, version string) (EnginePoolConfig, error) {
+				versions[key] = version
+
+				return EnginePoolConfig{
+					MaxPoolSize: 2,
+					Initializer: func(eng *Engine) error {
+						eng.SetGlobal("bundle", key+"@"+version)
+
+						return nil
+					},
+				}, nil
+			},
+		})
+	})
+
+	AfterEach(func() {
+		set.Shutdown()
+	})
+
+	fetch := func(key, version string) string {
+		pe, err := set.Get(key, version)
+		Ω(err).Should(BeNil())
+		defer pe.Release()
+
+		return pe.GetGlobal("bundle").AsString()
+	}
+
+	It("lazily creates a pool per key", func() {
+		Ω(fetch("alpha", "1")).Should(Equal("alpha@1"))
+		Ω(fetch("beta", "1")).Should(Equal("beta@1"))
+		Ω(set.Len()).Should(Equal(2))
+	})
+
+	It("evicts the least recently used pool to stay under the engine cap", func() {
+		fetch("alpha", "1")
+		fetch("beta", "1")
+		fetch("alpha", "1")
+		fetch("gamma", "1")
+
+		Ω(set.Len()).Should(Equal(2))
+		Ω(set.EngineCount()).Should(Equal(2))
+
+		delete(versions, "beta")
+		fetch("beta", "1")
+		Ω(versions).Should(HaveKey("beta"))
+	})
+
+	It("rebuilds a pool when the bundle version changes", func() {
+		Ω(fetch("alpha", "1")).Should(Equal("alpha@1"))
+		Ω(fetch("alpha", "2")).Should(Equal("alpha@2"))
+		Ω(set.Len()).Should(Equal(1))
+		Ω(set.EngineCount()).Should(Equal(1))
+	})
+
+	It("fails when every pool is busy and the cap is reached", func() {
+		first, err := set.Get("alpha", "1")
+		Ω(err).Should(BeNil())
+		defer first.Release()
+
+		second, err := set.Get("beta", "1")
+		Ω(err).Should(BeNil())
+		defer second.Release()
+
+		_, err = set.Get("gamma", "1")
+		Ω(err).Should(Equal(ErrPoolSetFull))
+	})
+})
@@ -0,0 +1,270 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("EnginePool", func() {
+	Context("when the initializer fails", func() {
+		var (
+			pool  *EnginePool
+			err   error
+			calls int
+		)
+
+		BeforeEach(func() {
+			calls = 0
+			pool, err = NewEnginePoolWithConfig(EnginePoolConfig{
+				MaxPoolSize: 2,
+				MaxRetries:  2,
+				Initializer: func(eng *Engine) error {
+					calls++
+
+					return eng.DoString("this is not lua")
+				},
+			})
+		})
+
+		It("returns an error", func() {
+			Ω(err).ShouldNot(BeNil())
+		})
+
+		It("does not create a pool", func() {
+			Ω(pool).Should(BeNil())
+		})
+
+		It("retries the configured number of times", func() {
+			Ω(calls).Should(Equal(3))
+		})
+	})
+
+	Context("when the factory fails after the pool is seeded", func() {
+		var (
+			pool     *EnginePool
+			err      error
+			built    int
+			errBuild = errors.New("build failed")
+		)
+
+		BeforeEach(func() {
+			built = 0
+			pool, err = NewEnginePoolWithConfig(EnginePoolConfig{
+				MaxPoolSize: 2,
+				Factory: func() (*Engine, error) {
+					built++
+					if built > 1 {
+						return nil, errBuild
+					}
+
+					return NewEngineWithOptions(EngineOptions{
+						FieldCasing:  CamelCase,
+						MethodCasing: CamelCase,
+					}), nil
+				},
+			})
+		})
+
+		AfterEach(func() {
+			if pool != nil {
+				pool.Shutdown()
+			}
+		})
+
+		It("creates the pool", func() {
+			Ω(err).Should(BeNil())
+			Ω(pool.Len()).Should(Equal(1))
+		})
+
+		It("surfaces the failure from Get", func() {
+			first, err := pool.Get()
+			Ω(err).Should(BeNil())
+			defer first.Release()
+
+			second, err := pool.Get()
+			Ω(second).Should(BeNil())
+			Ω(errors.Is(err, errBuild)).Should(BeTrue())
+			Ω(pool.Len()).Should(Equal(1))
+		})
+	})
+
+	Context("when the mutator panics", func() {
+		It("panics from NewEnginePool with the error", func() {
+			Ω(func() {
+				NewEnginePool(1, func(*Engine) {
+					panic("bad mutator")
+				})
+			}).Should(PanicWith(MatchError(ContainSubstring("bad mutator"))))
+		})
+	})
+
+	Context("when shut down", func() {
+		It("returns ErrPoolClosed from Get", func() {
+			pool := NewEnginePool(1, nil)
+			pool.Shutdown()
+
+			_, err := pool.Get()
+			Ω(err).Should(Equal(ErrPoolClosed))
+		})
+	})
+
+	Context("when engines are never released", func() {
+		It("reports where they were checked out", func() {
+			leaks := make(chan string, 1)
+			pool, err := NewEnginePoolWithConfig(EnginePoolConfig{
+				Debug: true,
+				LeakHandler: func(stack []byte) {
+					leaks <- string(stack)
+				},
+			})
+			Ω(err).Should(BeNil())
+			defer pool.Shutdown()
+
+			func() {
+				_, err := pool.Get()
+				Ω(err).Should(BeNil())
+			}()
+
+			Eventually(func() string {
+				runtime.GC()
+				select {
+				case stack := <-leaks:
+					return stack
+				default:
+					return ""
+				}
+			}).Should(ContainSubstring("pool_test.go"))
+		})
+	})
+
+	Describe("With()", func() {
+		var pool *EnginePool
+
+		BeforeEach(func() {
+			pool = NewEnginePool(2, nil)
+		})
+
+		AfterEach(func() {
+			pool.Shutdown()
+		})
+
+		It("returns the error from the function", func() {
+			err := pool.With(context.Background(), func(eng *Engine) error {
+				return eng.DoString("error('boom')")
+			})
+			Ω(err).ShouldNot(BeNil())
+			Ω(pool.Len()).Should(Equal(1))
+		})
+
+		It("recovers from panics and discards the engine", func() {
+			err := pool.With(context.Background(), func(eng *Engine) error {
+				panic("boom")
+			})
+			Ω(errors.Is(err, ErrEngineFatal)).Should(BeTrue())
+			Ω(pool.Len()).Should(Equal(0))
+		})
+
+		It("discards the engine on fatal errors", func() {
+			err := pool.With(context.Background(), func(eng *Engine) error {
+				return fmt.Errorf("corrupted state: %w", ErrEngineFatal)
+			})
+			Ω(errors.Is(err, ErrEngineFatal)).Should(BeTrue())
+			Ω(pool.Len()).Should(Equal(0))
+		})
+
+		It("makes the engine available again afterwards", func() {
+			Ω(pool.With(context.Background(), func(*Engine) error { return nil })).Should(Succeed())
+
+			pe, err := pool.Get()
+			Ω(err).Should(BeNil())
+			pe.Release()
+			Ω(pool.Len()).Should(Equal(1))
+		})
+	})
+
+	Describe("Broadcast()", func() {
+		var pool *EnginePool
+
+		BeforeEach(func() {
+			pool = NewEnginePool(2, nil)
+		})
+
+		AfterEach(func() {
+			pool.Shutdown()
+		})
+
+		broadcastLevel := func(level int) {
+			pool.Broadcast(func(eng *Engine) {
+				eng.SetGlobal("level", level)
+			})
+		}
+
+		It("updates idle engines immediately", func() {
+			broadcastLevel(10)
+			pool.EachEngine(func(eng *Engine) {
+				Ω(eng.GetGlobal("level").AsNumber()).Should(Equal(float64(10)))
+			})
+		})
+
+		It("updates checked out engines when they're released", func() {
+			pe, err := pool.Get()
+			Ω(err).Should(BeNil())
+			eng := pe.Engine
+
+			broadcastLevel(20)
+			Ω(eng.GetGlobal("level").IsNil()).Should(BeTrue())
+
+			pe.Release()
+			Ω(eng.GetGlobal("level").AsNumber()).Should(Equal(float64(20)))
+		})
+
+		It("updates engines created after the broadcast", func() {
+			broadcastLevel(30)
+
+			first, err := pool.Get()
+			Ω(err).Should(BeNil())
+			defer first.Release()
+
+			second, err := pool.Get()
+			Ω(err).Should(BeNil())
+			defer second.Release()
+
+			Ω(pool.Len()).Should(Equal(2))
+			Ω(second.GetGlobal("level").AsNumber()).Should(Equal(float64(30)))
+		})
+
+		It("applies every update in order to engines created once all engines have applied them", func() {
+			pe, err := pool.Get()
+			Ω(err).Should(BeNil())
+
+			for i := 1; i <= 3; i++ {
+				n := i
+				pool.Broadcast(func(eng *Engine) {
+					eng.DoString(fmt.Sprintf(`seen = (seen or "") .. "%d"`, n))
+				})
+			}
+			pe.Release()
+
+			first, err := pool.Get()
+			Ω(err).Should(BeNil())
+			defer first.Release()
+
+			second, err := pool.Get()
+			Ω(err).Should(BeNil())
+			defer second.Release()
+
+			Ω(pool.Len()).Should(Equal(2))
+			Ω(first.GetGlobal("seen").AsString()).Should(Equal("123"))
+			Ω(second.GetGlobal("seen").AsString()).Should(Equal("123"))
+		})
+	})
+})
@@ -0,0 +1,411 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// DefaultProfileInterval is how often StartProfile samples the Lua call stack.
+const DefaultProfileInterval = 10 * time.Millisecond
+
+// StartProfile begins sampling the Lua call stack of the engine, the profile
+// is written to w in the pprof format when StopProfile is called and can be
+// viewed with `go tool pprof`. Only chunks loaded after the profile was
+// started are profiled, as they're instrumented to track the functions
+// being called while they're compiled. Time spent in Go functions is
+// attributed to the Lua function that called them.
+func (e *Engine) StartProfile(w io.Writer) error {
+	return e.StartProfileWithInterval(w, DefaultProfileInterval)
+}
+
+// StartProfileWithInterval begins profiling like StartProfile, sampling the
+// call stack at the given interval.
+func (e *Engine) StartProfileWithInterval(w io.Writer, interval time.Duration) error {
+	if e.profiler != nil {
+		return errors.New("profile already started")
+	}
+	if interval <= 0 {
+		interval = DefaultProfileInterval
+	}
+
+	e.profiler = &profiler{
+		w:        w,
+		interval: interval,
+		start:    time.Now(),
+		last:     time.Now(),
+		stacks:   make(map[*glua.LState][]profileFrame),
+		samples:  make(map[string]*profileSample),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	e.addHookListener(e.profiler)
+	go e.profiler.sample()
+
+	return nil
+}
+
+// StopProfile stops profiling the engine and writes the profile.
+func (e *Engine) StopProfile() error {
+	if e.profiler == nil {
+		return errors.New("profile not started")
+	}
+
+	p := e.profiler
+	e.profiler = nil
+	e.removeHookListener(p)
+	close(p.stop)
+	<-p.done
+
+	return p.write()
+}
+
+// profileFrame is a function on the Lua call stack tracked by the profiler.
+type profileFrame struct {
+	chunk    *instrumentedChunk
+	function int
+	line     int
+	depth    int
+}
+
+// profileSample is the number of times a call stack was seen and the time
+// attributed to it.
+type profileSample struct {
+	stack []profileFrame
+	count int64
+	nanos int64
+}
+
+// profiler tracks the call stack through hook events while a goroutine
+// periodically records the current stack.
+type profiler struct {
+	mutex    sync.Mutex
+	w        io.Writer
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+
+	// stacks are kept for each thread (coroutines have their own), current is
+	// the thread that reported the latest event.
+	stacks  map[*glua.LState][]profileFrame
+	current *glua.LState
+
+	samples map[string]*profileSample
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (p *profiler) chunkInstrumented(*instrumentedChunk) {}
+
+func (p *profiler) chunkReleased(int) {}
+
+func (p *profiler) hook(_ *Engine, ev *hookEvent) {
+	if ev.kind == hookBranch {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stack := p.stacks[ev.state]
+	p.current = ev.state
+
+	switch ev.kind {
+	case hookLine:
+		if n := len(stack); n > 0 && stack[n-1].chunk == ev.chunk {
+			stack[n-1].line = ev.index
+		}
+	case hookCall:
+		depth := ev.depth()
+		stack = truncateProfileStack(stack, depth)
+		stack = append(stack, profileFrame{
+			chunk:    ev.chunk,
+			function: ev.index,
+			line:     ev.line(),
+			depth:    depth,
+		})
+	case hookReturn:
+		stack = truncateProfileStack(stack, ev.depth())
+	}
+
+	p.stacks[ev.state] = stack
+}
+
+// drop the frames that have returned or been unwound by an error
+func (p *profiler) luaCallFinished(eng *Engine, depth int, _ error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stacks[eng.state] = truncateProfileStack(p.stacks[eng.state], depth+1)
+	for state, stack := range p.stacks {
+		if len(stack) == 0 {
+			delete(p.stacks, state)
+		}
+	}
+}
+
+// remove the frames at or above the given depth
+func truncateProfileStack(stack []profileFrame, depth int) []profileFrame {
+	for len(stack) > 0 && stack[len(stack)-1].depth >= depth {
+		stack = stack[:len(stack)-1]
+	}
+
+	return stack
+}
+
+// record the current stack every interval until stopped
+func (p *profiler) sample() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.record()
+		}
+	}
+}
+
+// record a sample of the current stack, if Lua is running. Ticks are dropped
+// while the hooks hold the lock so each sample is weighted by the time since
+// the previous one rather than the interval.
+func (p *profiler) record() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(p.last)
+	p.last = now
+
+	stack := p.stacks[p.current]
+	if len(stack) == 0 {
+		return
+	}
+
+	var key strings.Builder
+	for _, frame := range stack {
+		key.WriteString(strconv.Itoa(frame.chunk.id))
+		key.WriteByte(':')
+		key.WriteString(strconv.Itoa(frame.function))
+		key.WriteByte(':')
+		key.WriteString(strconv.Itoa(frame.line))
+		key.WriteByte(';')
+	}
+
+	sample, ok := p.samples[key.String()]
+	if !ok {
+		sample = &profileSample{stack: append([]profileFrame{}, stack...)}
+		p.samples[key.String()] = sample
+	}
+	sample.count++
+	sample.nanos += elapsed.Nanoseconds()
+}
+
+// write the samples as a gzipped pprof profile
+func (p *profiler) write() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	enc := newPprofEncoder()
+	prof := new(protoBuffer)
+
+	// sample_type
+	prof.message(1, enc.valueType("samples", "count"))
+	prof.message(1, enc.valueType("cpu", "nanoseconds"))
+
+	for _, key := range keys {
+		sample := p.samples[key]
+
+		// locations are listed from the leaf to the root
+		locations := make([]uint64, 0, len(sample.stack))
+		for i := len(sample.stack) - 1; i >= 0; i-- {
+			locations = append(locations, enc.location(sample.stack[i]))
+		}
+
+		msg := new(protoBuffer)
+		msg.packed(1, locations)
+		msg.packed(2, []uint64{uint64(sample.count), uint64(sample.nanos)})
+		prof.message(2, msg)
+	}
+
+	for _, loc := range enc.locations {
+		prof.message(4, loc)
+	}
+	for _, fn := range enc.functions {
+		prof.message(5, fn)
+	}
+
+	prof.int(9, uint64(p.start.UnixNano()))
+	prof.int(10, uint64(time.Since(p.start).Nanoseconds()))
+	prof.message(11, enc.valueType("cpu", "nanoseconds"))
+	prof.int(12, uint64(p.interval.Nanoseconds()))
+
+	// every string has been added to the table by now
+	for _, str := range enc.strings {
+		prof.bytes(6, []byte(str))
+	}
+
+	gz := gzip.NewWriter(p.w)
+	if _, err := gz.Write(prof.Bytes()); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// pprofEncoder builds the string, function and location tables of a profile.
+type pprofEncoder struct {
+	strings     []string
+	stringIDs   map[string]uint64
+	functions   []*protoBuffer
+	functionIDs map[[2]int]uint64
+	locations   []*protoBuffer
+	locationIDs map[[3]int]uint64
+}
+
+func newPprofEncoder() *pprofEncoder {
+	return &pprofEncoder{
+		strings:     []string{""},
+		stringIDs:   map[string]uint64{"": 0},
+		functionIDs: make(map[[2]int]uint64),
+		locationIDs: make(map[[3]int]uint64),
+	}
+}
+
+// the index of the string in the string table
+func (enc *pprofEncoder) string(str string) uint64 {
+	id, ok := enc.stringIDs[str]
+	if !ok {
+		id = uint64(len(enc.strings))
+		enc.strings = append(enc.strings, str)
+		enc.stringIDs[str] = id
+	}
+
+	return id
+}
+
+func (enc *pprofEncoder) valueType(typ, unit string) *protoBuffer {
+	msg := new(protoBuffer)
+	msg.int(1, enc.string(typ))
+	msg.int(2, enc.string(unit))
+
+	return msg
+}
+
+// the ID of the function the frame is in
+func (enc *pprofEncoder) function(frame profileFrame) uint64 {
+	key := [2]int{frame.chunk.id, frame.function}
+	if id, ok := enc.functionIDs[key]; ok {
+		return id
+	}
+
+	fn := frame.chunk.functions[frame.function]
+	name := fn.name
+	if frame.function == 0 {
+		name = "main chunk (" + frame.chunk.name + ")"
+	}
+
+	id := uint64(len(enc.functions) + 1)
+	msg := new(protoBuffer)
+	msg.int(1, id)
+	msg.int(2, enc.string(name))
+	msg.int(3, enc.string(name))
+	msg.int(4, enc.string(frame.chunk.name))
+	msg.int(5, uint64(fn.line))
+	enc.functions = append(enc.functions, msg)
+	enc.functionIDs[key] = id
+
+	return id
+}
+
+// the ID of the location (a line within a function) of the frame
+func (enc *pprofEncoder) location(frame profileFrame) uint64 {
+	key := [3]int{frame.chunk.id, frame.function, frame.line}
+	if id, ok := enc.locationIDs[key]; ok {
+		return id
+	}
+
+	line := new(protoBuffer)
+	line.int(1, enc.function(frame))
+	line.int(2, uint64(frame.line))
+
+	id := uint64(len(enc.locations) + 1)
+	msg := new(protoBuffer)
+	msg.int(1, id)
+	msg.message(4, line)
+	enc.locations = append(enc.locations, msg)
+	enc.locationIDs[key] = id
+
+	return id
+}
+
+// protoBuffer encodes the protocol buffer wire format, just enough of it to
+// write pprof profiles without depending on a protobuf library.
+type protoBuffer struct {
+	buf []byte
+}
+
+func (pb *protoBuffer) Bytes() []byte {
+	return pb.buf
+}
+
+func (pb *protoBuffer) varint(n uint64) {
+	for n >= 0x80 {
+		pb.buf = append(pb.buf, byte(n)|0x80)
+		n >>= 7
+	}
+	pb.buf = append(pb.buf, byte(n))
+}
+
+func (pb *protoBuffer) key(field, wireType int) {
+	pb.varint(uint64(field<<3 | wireType))
+}
+
+// a varint field, zero values are omitted as they're the default
+func (pb *protoBuffer) int(field int, n uint64) {
+	if n == 0 {
+		return
+	}
+
+	pb.key(field, 0)
+	pb.varint(n)
+}
+
+// a length delimited field
+func (pb *protoBuffer) bytes(field int, data []byte) {
+	pb.key(field, 2)
+	pb.varint(uint64(len(data)))
+	pb.buf = append(pb.buf, data...)
+}
+
+func (pb *protoBuffer) message(field int, msg *protoBuffer) {
+	pb.bytes(field, msg.buf)
+}
+
+// a repeated varint field in packed form
+func (pb *protoBuffer) packed(field int, values []uint64) {
+	msg := new(protoBuffer)
+	for _, n := range values {
+		msg.varint(n)
+	}
+	pb.bytes(field, msg.buf)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("Profiling", func() {
+	var eng *Engine
+
+	BeforeEach(func() {
+		eng = NewEngine()
+		eng.OpenLibs()
+	})
+
+	AfterEach(func() {
+		eng.Close()
+	})
+
+	It("writes a gzipped pprof profile naming the Lua functions", func() {
+		buf := new(bytes.Buffer)
+		Ω(eng.StartProfileWithInterval(buf, time.Millisecond)).Should(Succeed())
+
+		Ω(eng.DoString(`
+			local function busy()
+				local deadline = os.clock() + 0.05
+				while os.clock() < deadline do end
+			end
+			busy()
+		`)).Should(Succeed())
+		Ω(eng.StopProfile()).Should(Succeed())
+
+		reader, err := gzip.NewReader(buf)
+		Ω(err).Should(BeNil())
+		profile, err := ioutil.ReadAll(reader)
+		Ω(err).Should(BeNil())
+
+		Ω(string(profile)).Should(ContainSubstring("busy"))
+		Ω(string(profile)).Should(ContainSubstring("main chunk (<string>)"))
+		Ω(string(profile)).Should(ContainSubstring("nanoseconds"))
+	})
+
+	It("keeps running scripts correctly when errors unwind the stack", func() {
+		Ω(eng.StartProfile(ioutil.Discard)).Should(Succeed())
+
+		Ω(eng.DoString(`
+			local function fail() error("boom") end
+			local ok = pcall(fail)
+			assert(not ok)
+			result = select("#", (function() return 1, 2, 3 end)())
+		`)).Should(Succeed())
+		Ω(eng.DoString(`error("again")`)).ShouldNot(Succeed())
+		Ω(eng.StopProfile()).Should(Succeed())
+
+		Ω(eng.GetGlobal("result").AsNumber()).Should(BeEquivalentTo(3))
+	})
+
+	It("can only be started once", func() {
+		Ω(eng.StartProfile(ioutil.Discard)).Should(Succeed())
+		Ω(eng.StartProfile(ioutil.Discard)).ShouldNot(Succeed())
+		Ω(eng.StopProfile()).Should(Succeed())
+	})
+
+	It("must be started before being stopped", func() {
+		Ω(eng.StopProfile()).ShouldNot(Succeed())
+	})
+})
@@ -0,0 +1,320 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"reflect"
+
+	glua "github.com/yuin/gopher-lua"
+	gluar "layeh.com/gopher-luar"
+)
+
+// ProxyOp is the kind of access a script makes through a proxy.
+type ProxyOp int
+
+// The accesses a script can make through a proxy.
+const (
+	// ProxyGet is reading a field (or element) of the value.
+	ProxyGet ProxyOp = iota
+
+	// ProxySet is assigning a field (or element) of the value.
+	ProxySet
+
+	// ProxyMethod is looking up a method of the value.
+	ProxyMethod
+)
+
+// String returns the name of the operation.
+func (op ProxyOp) String() string {
+	switch op {
+	case ProxyGet:
+		return "get"
+	case ProxySet:
+		return "set"
+	case ProxyMethod:
+		return "method"
+	}
+
+	return "unknown"
+}
+
+// ProxyAccess describes an access a script made through a proxy, it's given to
+// the OnAccess function of the proxy's Policy.
+type ProxyAccess struct {
+	// Value is the Go value being accessed.
+	Value interface{}
+
+	// Op is the kind of access.
+	Op ProxyOp
+
+	// Key is the name of the field or method, or the element index (as a
+	// string) for slices and maps.
+	Key string
+
+	// Allowed is false when the policy refused the access.
+	Allowed bool
+}
+
+// Policy controls what a proxy lets scripts do with a Go value.
+type Policy struct {
+	// Fields lists the Lua names of the fields scripts can access, all fields
+	// are accessible when it's nil. Fields that aren't allowed read as nil and
+	// raise an error when assigned.
+	Fields []string
+
+	// Methods lists the Lua names of the methods scripts can call, all methods
+	// are accessible when it's nil. Methods that aren't allowed read as nil.
+	Methods []string
+
+	// ReadOnly raises an error when a script assigns to the value, or to any
+	// value reached through it (its fields and the results of its methods,
+	// which are proxied too). Methods are still called on the value itself, so
+	// methods that change it (such as those with pointer receivers) should be
+	// left out of Methods.
+	ReadOnly bool
+
+	// OnAccess, if set, is called for each access a script makes through the
+	// proxy, allowed or not, for auditing.
+	OnAccess func(ProxyAccess)
+}
+
+// ReadOnly exposes the Go value to Lua through a proxy that raises an error
+// when a script assigns to it or anything reached through it. The proxy can't
+// tell which methods change the value, so all of its methods can be called
+// unless the methods scripts may call are listed (by their Lua names).
+func (e *Engine) ReadOnly(val interface{}, methods ...string) *Value {
+	policy := Policy{ReadOnly: true}
+	if len(methods) > 0 {
+		policy.Methods = methods
+	}
+
+	return e.Proxy(val, policy)
+}
+
+// Proxy exposes the Go value to Lua through a proxy enforcing the policy. The
+// proxy behaves like the value normally does in Lua (using the metatable
+// MetatableFor returns) apart from the accesses the policy refuses, and is
+// converted back to the value when passed to Go functions.
+func (e *Engine) Proxy(val interface{}, policy Policy) *Value {
+	inner := e.ValueFor(val)
+	ud, ok := inner.lval.(*glua.LUserData)
+	if !ok {
+		return inner
+	}
+
+	p := &proxy{
+		engine:     e,
+		policy:     policy,
+		fields:     nameSet(policy.Fields),
+		methods:    nameSet(policy.Methods),
+		metatables: make(map[reflect.Type]*glua.LTable),
+	}
+
+	return e.newValue(p.wrap(ud))
+}
+
+// the set of names, or nil when allowing everything
+func nameSet(names []string) map[string]bool {
+	if names == nil {
+		return nil
+	}
+
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+
+	return set
+}
+
+// proxy holds the metatables built for a policy, one for each type proxied
+// with it. The allow lists only apply to the value given to Proxy, values
+// reached through it are proxied (when read-only) without them.
+type proxy struct {
+	engine     *Engine
+	policy     Policy
+	fields     map[string]bool
+	methods    map[string]bool
+	metatables map[reflect.Type]*glua.LTable
+	nested     *proxy
+}
+
+// wrap the gopher-luar userdata in a proxy userdata with the same value
+func (p *proxy) wrap(inner *glua.LUserData) *glua.LUserData {
+	ud := p.engine.state.NewUserData()
+	ud.Value = inner.Value
+	ud.Metatable = p.metatable(inner)
+
+	return ud
+}
+
+// the proxy metatable for the type of the userdata's value, it forwards to
+// the userdata's own metatable
+func (p *proxy) metatable(inner *glua.LUserData) *glua.LTable {
+	t := reflect.TypeOf(inner.Value)
+	if mt, ok := p.metatables[t]; ok {
+		return mt
+	}
+
+	l := p.engine.state
+	original, _ := inner.Metatable.(*glua.LTable)
+	mt := l.NewTable()
+	if original != nil {
+		original.ForEach(func(key, val glua.LValue) {
+			if fn, ok := val.(*glua.LFunction); ok {
+				mt.RawSet(key, l.NewFunction(p.forwarder(fn)))
+			}
+		})
+		mt.RawSetString("__metatable", original.RawGetString("__metatable"))
+	}
+	mt.RawSetString("__index", l.NewFunction(p.index))
+	mt.RawSetString("__newindex", l.NewFunction(p.newIndex))
+	mt.RawSetString(proxyMarker, glua.LTrue)
+	p.metatables[t] = mt
+
+	return mt
+}
+
+// a metamethod calling the original with the proxies in its arguments
+// replaced by the values they proxy
+func (p *proxy) forwarder(fn *glua.LFunction) glua.LGFunction {
+	return func(l *glua.LState) int {
+		return p.forward(l, fn)
+	}
+}
+
+// call fn with the arguments from the stack, replacing proxies with the
+// values they proxy, returning the number of results pushed
+func (p *proxy) forward(l *glua.LState, fn glua.LValue) int {
+	top := l.GetTop()
+	l.Push(fn)
+	for i := 1; i <= top; i++ {
+		l.Push(p.unwrap(l.Get(i)))
+	}
+	l.Call(top, glua.MultRet)
+
+	return l.GetTop() - top
+}
+
+// the field marking the metatables of proxies
+const proxyMarker = "__luna_proxy"
+
+// the gopher-luar userdata for the value if it's a proxy
+func (p *proxy) unwrap(val glua.LValue) glua.LValue {
+	ud, ok := val.(*glua.LUserData)
+	if !ok {
+		return val
+	}
+	mt, ok := ud.Metatable.(*glua.LTable)
+	if !ok || mt.RawGetString(proxyMarker) != glua.LTrue {
+		return val
+	}
+
+	return gluar.New(p.engine.state, ud.Value)
+}
+
+// the metatable gopher-luar uses for the proxied value
+func (p *proxy) original(ud *glua.LUserData) *glua.LTable {
+	return p.engine.MetatableFor(ud.Value).lval.(*glua.LTable)
+}
+
+// report the access to the policy's OnAccess function
+func (p *proxy) audit(ud *glua.LUserData, op ProxyOp, key glua.LValue, allowed bool) {
+	if p.policy.OnAccess != nil {
+		p.policy.OnAccess(ProxyAccess{
+			Value:   ud.Value,
+			Op:      op,
+			Key:     key.String(),
+			Allowed: allowed,
+		})
+	}
+}
+
+// __index, reading fields and methods the policy allows
+func (p *proxy) index(l *glua.LState) int {
+	ud := l.CheckUserData(1)
+	key := l.Get(2)
+
+	name, isString := key.(glua.LString)
+	if isString && p.engine.hasMethod(reflect.TypeOf(ud.Value), string(name)) {
+		allowed := p.methods == nil || p.methods[string(name)]
+		p.audit(ud, ProxyMethod, key, allowed)
+		if !allowed {
+			return 0
+		}
+
+		l.Push(l.NewFunction(p.method(string(name))))
+
+		return 1
+	}
+
+	allowed := !isString || p.fields == nil || p.fields[string(name)]
+	p.audit(ud, ProxyGet, key, allowed)
+	if !allowed {
+		return 0
+	}
+
+	return p.protectResults(l, p.forward(l, p.original(ud).RawGetString("__index")))
+}
+
+// proxy the n results at the top of the stack when read-only, as values
+// reached through a read-only value are read-only too
+func (p *proxy) protectResults(l *glua.LState, n int) int {
+	if !p.policy.ReadOnly {
+		return n
+	}
+
+	for i := l.GetTop() - n + 1; i <= l.GetTop(); i++ {
+		if result, ok := l.Get(i).(*glua.LUserData); ok && result.Metatable != nil {
+			l.Replace(i, p.nestedProxy().wrap(result))
+		}
+	}
+
+	return n
+}
+
+// the function for a method, calling it on the proxied value
+func (p *proxy) method(name string) glua.LGFunction {
+	return func(l *glua.LState) int {
+		self, ok := l.Get(1).(*glua.LUserData)
+		if !ok {
+			l.ArgError(1, "expected a proxied value, use ':' to call methods")
+		}
+
+		fn := l.GetField(p.unwrap(self), name)
+
+		return p.protectResults(l, p.forward(l, fn))
+	}
+}
+
+// __newindex, assigning fields the policy allows
+func (p *proxy) newIndex(l *glua.LState) int {
+	ud := l.CheckUserData(1)
+	key := l.Get(2)
+
+	name, isString := key.(glua.LString)
+	allowed := !p.policy.ReadOnly && (!isString || p.fields == nil || p.fields[string(name)])
+	p.audit(ud, ProxySet, key, allowed)
+	if !allowed {
+		if p.policy.ReadOnly {
+			l.RaiseError("cannot assign to %s of a read-only value", key.String())
+		}
+		l.RaiseError("cannot assign to %s", key.String())
+	}
+
+	return p.forward(l, p.original(ud).RawGetString("__newindex"))
+}
+
+// the proxy used for values reached through this one
+func (p *proxy) nestedProxy() *proxy {
+	if p.nested == nil {
+		p.nested = &proxy{
+			engine:     p.engine,
+			policy:     Policy{ReadOnly: p.policy.ReadOnly, OnAccess: p.policy.OnAccess},
+			metatables: make(map[reflect.Type]*glua.LTable),
+		}
+		p.nested.nested = p.nested
+	}
+
+	return p.nested
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+type proxyStats struct {
+	Level int
+}
+
+type proxyPlayer struct {
+	Name     string
+	Password string
+	Stats    *proxyStats
+	Items    []string
+}
+
+func (p *proxyPlayer) Greet() string {
+	return "hello " + p.Name
+}
+
+func (p *proxyPlayer) GetStats() *proxyStats {
+	return p.Stats
+}
+
+func (p *proxyPlayer) Ban() {
+	p.Name = "banned"
+}
+
+var _ = Describe("Proxies", func() {
+	var (
+		eng    *Engine
+		player *proxyPlayer
+	)
+
+	BeforeEach(func() {
+		eng = NewEngine()
+		player = &proxyPlayer{
+			Name:     "bob",
+			Password: "hunter2",
+			Stats:    &proxyStats{Level: 3},
+			Items:    []string{"sword"},
+		}
+	})
+
+	AfterEach(func() {
+		eng.Close()
+	})
+
+	Describe("ReadOnly()", func() {
+		BeforeEach(func() {
+			eng.SetGlobal("player", eng.ReadOnly(player))
+		})
+
+		It("allows reading fields and calling methods", func() {
+			Ω(eng.DoString(`result = player.name .. " " .. player.stats.level .. " " .. player:greet()`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").AsString()).Should(Equal("bob 3 hello bob"))
+		})
+
+		It("raises an error when assigning", func() {
+			err := eng.DoString(`player.name = "alice"`)
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring("cannot assign to name of a read-only value"))
+			Ω(player.Name).Should(Equal("bob"))
+		})
+
+		It("protects values reached through it", func() {
+			Ω(eng.DoString(`player.stats.level = 10`)).ShouldNot(Succeed())
+			Ω(eng.DoString(`player.items[1] = "axe"`)).ShouldNot(Succeed())
+			Ω(player.Stats.Level).Should(Equal(3))
+			Ω(player.Items[0]).Should(Equal("sword"))
+		})
+
+		It("protects values returned by methods", func() {
+			Ω(eng.DoString(`player:get_stats().level = 99`)).ShouldNot(Succeed())
+			Ω(player.Stats.Level).Should(Equal(3))
+		})
+
+		It("only allows the methods listed", func() {
+			eng.SetGlobal("limited", eng.ReadOnly(player, "greet", "get_stats"))
+
+			Ω(eng.DoString(`result = limited:greet() .. " " .. limited:get_stats().level`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").AsString()).Should(Equal("hello bob 3"))
+			Ω(eng.DoString(`limited:ban()`)).ShouldNot(Succeed())
+			Ω(player.Name).Should(Equal("bob"))
+		})
+
+		It("is converted back to the value for Go functions", func() {
+			eng.RegisterFunc("name_of", func(p *proxyPlayer) string {
+				return p.Name
+			})
+
+			Ω(eng.DoString(`result = name_of(player)`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").AsString()).Should(Equal("bob"))
+		})
+
+		It("keeps the behavior of the value's metatable", func() {
+			Ω(eng.DoString(`result = #player.items`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").AsNumber()).Should(BeEquivalentTo(1))
+		})
+	})
+
+	Describe("Proxy()", func() {
+		var accesses []ProxyAccess
+
+		BeforeEach(func() {
+			accesses = nil
+			eng.SetGlobal("player", eng.Proxy(player, Policy{
+				Fields:  []string{"name"},
+				Methods: []string{"greet"},
+				OnAccess: func(access ProxyAccess) {
+					accesses = append(accesses, access)
+				},
+			}))
+		})
+
+		It("only exposes the allowed fields and methods", func() {
+			Ω(eng.DoString(`result = tostring(player.password) .. tostring(player.ban) .. player:greet()`)).Should(Succeed())
+			Ω(eng.GetGlobal("result").AsString()).Should(Equal("nilnilhello bob"))
+
+			Ω(eng.DoString(`player.password = "x"`)).ShouldNot(Succeed())
+			Ω(player.Password).Should(Equal("hunter2"))
+		})
+
+		It("allows assigning allowed fields", func() {
+			Ω(eng.DoString(`player.name = "alice"`)).Should(Succeed())
+			Ω(player.Name).Should(Equal("alice"))
+		})
+
+		It("reports each access", func() {
+			Ω(eng.DoString(`local _ = player.name; local _ = player.password; player.name = "x"; player:greet()`)).Should(Succeed())
+
+			Ω(accesses).Should(Equal([]ProxyAccess{
+				{Value: player, Op: ProxyGet, Key: "name", Allowed: true},
+				{Value: player, Op: ProxyGet, Key: "password", Allowed: false},
+				{Value: player, Op: ProxySet, Key: "name", Allowed: true},
+				{Value: player, Op: ProxyMethod, Key: "greet", Allowed: true},
+			}))
+		})
+	})
+})
@@ -3,10 +3,16 @@
 package luna
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 	glua "github.com/yuin/gopher-lua"
@@ -15,35 +21,156 @@ import (
 
 var errExit = errors.New("Exit")
 
+// DefaultHistoryLimit is the number of lines of history kept when no limit is
+// configured.
+const DefaultHistoryLimit = 1000
+
+// historySaver is implemented by line readers that keep a history, such as
+// readline.Instance
+type historySaver interface {
+	SaveHistory(string) error
+}
+
+// LineReader is a source of input lines for a REPL. A readline.Instance is a
+// LineReader, NewLineReader provides one for any io.Reader.
+type LineReader interface {
+	Readline() (string, error)
+	SetPrompt(string)
+}
+
 // REPL represent a Read-Eval-Print-Loop
 type REPL struct {
 	lineNumber   uint
 	promptNumFmt string
 	promptStrFmt string
 	historyPath  string
+	historyLimit int
+	lastHistory  string
 	engine       *Engine
-	input        *readline.Instance
+	ownsEngine   bool
+	newEngine    func() *Engine
+	input        LineReader
+	output       io.Writer
+	commands     map[string]REPLCommand
+	session      []string
+	timeNext     bool
+	exiting      bool
+	color        bool
+	theme        *Theme
+	inspector    *Inspector
 }
 
 const defaultPrompt = "{name} ({n})"
 
+// ColorMode determines when the REPL colors its output.
+type ColorMode int
+
+// The supported color modes, ColorAuto colors output only when it's written to
+// a terminal and the NO_COLOR environment variable is not set.
+const (
+	ColorAuto ColorMode = iota
+	ColorAlways
+	ColorNever
+)
+
 // REPLConfig provides a mean for configuring a lua.REPL value.
-// -- HistoryFilePath is the path to the history file for storing the written
-//    history of the REPL session (optional)
-// -- Prompt is a fmt string to print as the prompt for each REPL input line.
-//    There is a special format value {n} here where you want the line number
-//    to go, or {name} as a place to inject the name provided (if any).
-// -- Name is a name given, really only useful when no prompt is given as this
-//    value is injected into the prompt.
 type REPLConfig struct {
-	Engine          *Engine
+	Engine *Engine
+
+	// HistoryFilePath is the path to the history file for storing the written
+	// history of the REPL session (optional). By default a file named after the
+	// REPL is used in the luna directory of the user's config directory.
 	HistoryFilePath string
-	Name            string
-	Prompt          string
+
+	// HistoryLimit is the maximum number of lines of history to keep, defaults
+	// to DefaultHistoryLimit.
+	HistoryLimit int
+
+	// DisableHistory prevents the session from being written to a history
+	// file, useful for sessions where sensitive values may be entered.
+	DisableHistory bool
+
+	// Name is a name given, really only useful when no prompt is given as this
+	// value is injected into the prompt.
+	Name string
+
+	// Prompt is a fmt string to print as the prompt for each REPL input line.
+	// There is a special format value {n} here where you want the line number
+	// to go, or {name} as a place to inject the name provided (if any).
+	Prompt string
+
+	// Input is read for lines of source instead of the terminal when set,
+	// prompts are written to Output as each line is read.
+	Input io.Reader
+
+	// LineReader takes precedence over Input and provides lines of source,
+	// this allows for custom frontends.
+	LineReader LineReader
+
+	// Output is where prompts and results are written, defaults to stdout.
+	Output io.Writer
+
+	// Commands are additional dot-commands made available in the REPL, keyed
+	// by name without the leading '.', these can replace the built-ins.
+	Commands map[string]REPLCommand
+
+	// DisableFileCommands removes the built-in .load and .save commands, which
+	// read and write files on the machine running the REPL.
+	DisableFileCommands bool
+
+	// NewEngine creates the engine used after a .reset, by default a new engine
+	// with the same options as the current engine is created.
+	NewEngine func() *Engine
+
+	// Color determines if results are colored and input is highlighted,
+	// defaults to ColorAuto.
+	Color ColorMode
+
+	// Theme is used to color output, defaults to DefaultTheme.
+	Theme *Theme
+
+	// InspectWidth is the width results are fit into, see Inspector.Width.
+	InspectWidth int
+}
+
+// REPLResult is the outcome of executing a line of input in the REPL.
+type REPLResult struct {
+	// Source is the input that was executed.
+	Source string
+
+	// Values are the values produced by the input, if any.
+	Values []*Value
+
+	// Inspected holds the inspected (display) form of each value.
+	Inspected []string
+
+	// Err is the error raised while executing the input, if any.
+	Err error
+
+	// Duration is how long executing the input took.
+	Duration time.Duration
+}
+
+// String formats the result the way the REPL displays it.
+func (rr *REPLResult) String() string {
+	if rr.Err != nil {
+		return fmt.Sprintf("\n <=> %s\n", rr.Err.Error())
+	}
+
+	if len(rr.Inspected) == 0 {
+		return " => nil\n"
+	}
+
+	buf := new(bytes.Buffer)
+	for _, str := range rr.Inspected {
+		fmt.Fprintf(buf, " => %s\n", str)
+	}
+
+	return buf.String()
 }
 
 // NewREPL creates a REPL struct and seeds it with the necessary values to
-// prepare it for use. Uses the default .repl-history file.
+// prepare it for use. History is stored in the default location for the name.
 func NewREPL(eng *Engine, name string) *REPL {
 	return NewREPLWithConfig(REPLConfig{
 		Prompt: defaultPrompt,
@@ -64,50 +191,198 @@ func NewREPLWithConfig(config REPLConfig) *REPL {
 		promptNumFmt: strings.Replace(prompt, "{n}", "%[1]d", -1),
 		promptStrFmt: strings.Replace(prompt, "{n}", "%[1]s", -1),
 		engine:       config.Engine,
+		newEngine:    config.NewEngine,
+		input:        config.LineReader,
+		output:       config.Output,
+		commands:     builtinREPLCommands(),
 	}
 
-	if len(config.HistoryFilePath) == 0 {
+	if config.DisableFileCommands {
+		delete(repl.commands, "load")
+		delete(repl.commands, "save")
+	}
+	for name, cmd := range config.Commands {
+		repl.commands[name] = cmd
+	}
+
+	if repl.output == nil {
+		repl.output = os.Stdout
+	}
+
+	if repl.input == nil && config.Input != nil {
+		repl.input = NewLineReader(config.Input, repl.output)
+	}
+
+	switch config.Color {
+	case ColorAlways:
+		repl.color = true
+	case ColorAuto:
+		repl.color = isColorTerminal(repl.output)
+	}
+	repl.theme = config.Theme
+	repl.inspector = &Inspector{
+		Color: repl.color,
+		Theme: config.Theme,
+		Width: config.InspectWidth,
+	}
+
+	switch {
+	case config.DisableHistory:
+		repl.historyPath = ""
+	case len(config.HistoryFilePath) > 0:
 		repl.historyPath = config.HistoryFilePath
+	default:
+		repl.historyPath = defaultHistoryPath(config.Name)
+	}
+
+	repl.historyLimit = config.HistoryLimit
+	if repl.historyLimit <= 0 {
+		repl.historyLimit = DefaultHistoryLimit
+	}
+
+	repl.prepareEngine()
+
+	return repl
+}
+
+// Engine returns the engine the REPL is currently executing code in, this
+// changes when the REPL is reset.
+func (r *REPL) Engine() *Engine {
+	return r.engine
+}
+
+// HistoryPath returns the path of the file history is written to, this is
+// empty when history is disabled.
+func (r *REPL) HistoryPath() string {
+	return r.historyPath
+}
+
+// Output returns the writer the REPL writes prompts and results to.
+func (r *REPL) Output() io.Writer {
+	return r.output
+}
+
+// Session returns the inputs that have been executed successfully since the
+// REPL started (or was last reset).
+func (r *REPL) Session() []string {
+	return append([]string{}, r.session...)
+}
+
+// Exit ends the REPL once the input being executed has finished, Run returns
+// nil without printing the input's result. It's meant to be called from Go
+// functions scripts call, such as a replacement for os.exit.
+func (r *REPL) Exit() {
+	r.exiting = true
+}
+
+// RegisterCommand makes a dot-command available in the REPL, replacing any
+// existing command with the same name.
+func (r *REPL) RegisterCommand(name string, cmd REPLCommand) {
+	r.commands[strings.TrimPrefix(name, ".")] = cmd
+}
+
+// Reset replaces the REPL's engine with a fresh one and clears the session.
+func (r *REPL) Reset() {
+	var eng *Engine
+	if r.newEngine != nil {
+		eng = r.newEngine()
+	} else {
+		eng = NewEngineWithOptions(r.engine.Options)
 	}
 
-	repl.engine.SetGlobal("inspect", func(eng *Engine) int {
+	// only close engines the REPL created itself, the original engine belongs
+	// to whoever created the REPL.
+	if r.ownsEngine {
+		r.engine.Close()
+	}
+	r.engine = eng
+	r.ownsEngine = true
+	r.session = nil
+	r.prepareEngine()
+}
+
+// Close releases resources held by the REPL, this closes any engine created
+// by a reset. The engine the REPL was created with is left open.
+func (r *REPL) Close() {
+	if r.ownsEngine {
+		r.engine.Close()
+		r.ownsEngine = false
+	}
+}
+
+// add the REPL helpers to the engine
+func (r *REPL) prepareEngine() {
+	r.engine.SetGlobal("inspect", func(eng *Engine) int {
 		val := eng.PopValue()
 		eng.PushValue(val.Inspect(""))
 
 		return 1
 	})
-
-	return repl
 }
 
 // Run begins the execution fo the read-eval-print-loop. Executing the REPL
-// only ends when an input line matches `.exit` or if an error is encountered.
+// only ends when an input line matches `.exit`, the input is exhausted or if an
+// error is encountered. When no input was configured the terminal is used.
 func (r *REPL) Run() error {
-	var err error
-	r.input, err = readline.NewEx(&readline.Config{
-		Prompt:      r.NumberPrompt(),
-		HistoryFile: ".repl-history",
-	})
-	if err != nil {
-		return err
+	if r.input == nil {
+		if len(r.historyPath) > 0 {
+			if err := compactHistory(r.historyPath, r.historyLimit); err != nil {
+				return err
+			}
+		}
+
+		rl, err := readline.NewEx(&readline.Config{
+			Prompt:                 r.NumberPrompt(),
+			HistoryFile:            r.historyPath,
+			HistoryLimit:           r.historyLimit,
+			DisableAutoSaveHistory: true,
+			AutoComplete:           &replCompleter{r},
+			Painter:                r.painter(),
+		})
+		if err != nil {
+			return err
+		}
+		r.input = rl
+		defer func() {
+			rl.Close()
+			r.input = nil
+		}()
 	}
+	r.input.SetPrompt(r.NumberPrompt())
 
 	for {
 		line, err := r.read()
 		if err != nil {
-			switch err.Error() {
-			case "Interrupt":
+			switch err {
+			case readline.ErrInterrupt:
 				r.input.SetPrompt(r.NumberPrompt())
 
 				continue
-			case "Exit":
+			case errExit, io.EOF:
 				return nil
 			}
 
 			return err
 		}
 
-		r.Execute(line)
+		if isREPLCommand(line) {
+			if err := r.RunCommand(line); err != nil {
+				if err == errExit {
+					return nil
+				}
+				fmt.Fprintf(r.output, "\n <=> %s\n", err.Error())
+			}
+		} else {
+			result := r.Execute(line)
+			if r.exiting {
+				return nil
+			}
+			fmt.Fprint(r.output, result.String())
+			if r.timeNext {
+				fmt.Fprintf(r.output, " (%s)\n", result.Duration)
+				r.timeNext = false
+			}
+		}
 
 		r.lineNumber++
 		r.input.SetPrompt(r.NumberPrompt())
@@ -115,11 +390,13 @@ func (r *REPL) Run() error {
 }
 
 // Execute will take a source string and attempt to execute it in the given
-// engine context.
-func (r *REPL) Execute(src string) {
-	retSrc := fmt.Sprintf("return (%s)", src)
+// engine context, returning the values it produced or the error it raised.
+func (r *REPL) Execute(src string) *REPLResult {
+	result := &REPLResult{Source: src}
+	retSrc := "return " + src
 
 	before := r.engine.StackSize()
+	start := time.Now()
 
 	// try to run code that forces a return value
 	err := r.engine.DoString(retSrc)
@@ -128,26 +405,35 @@ func (r *REPL) Execute(src string) {
 		// by executing the code without it.
 		err = r.engine.DoString(src)
 	}
+	result.Duration = time.Since(start)
 
 	if err != nil {
-		fmt.Printf("\n <=> %s\n", err.Error())
-	} else {
-		var results []*Value
-		after := r.engine.StackSize() - before
-		for i := 0; i < after; i++ {
-			val := r.engine.PopValue()
-			results = append([]*Value{val}, results...)
-		}
+		result.Err = err
 
-		if len(results) > 0 {
-			for i := 0; i < len(results); i++ {
-				str := results[i].Inspect("    ")
-				fmt.Printf(" => %s\n", str)
-			}
-		} else {
-			fmt.Println(" => nil")
-		}
+		return result
 	}
+	r.session = append(r.session, src)
+
+	after := r.engine.StackSize() - before
+	for i := 0; i < after; i++ {
+		val := r.engine.PopValue()
+		result.Values = append([]*Value{val}, result.Values...)
+	}
+
+	for _, val := range result.Values {
+		result.Inspected = append(result.Inspected, r.inspector.Inspect(val, "    "))
+	}
+
+	return result
+}
+
+// painter highlights input as it's typed when color is enabled
+func (r *REPL) painter() readline.Painter {
+	if !r.color {
+		return nil
+	}
+
+	return &luaPainter{theme: r.theme}
 }
 
 // NumberPrompt returns a formatted prompt to use as the Readline prompt.
@@ -187,6 +473,11 @@ func (r *REPL) read() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	r.remember(line)
+
+	if isREPLCommand(line) {
+		return line, nil
+	}
 
 	_, err = r.engine.LoadString("return " + line)
 	if err == nil {
@@ -212,6 +503,7 @@ func (r *REPL) readMulti(line string) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		r.remember(line)
 		if line == ".exit" {
 			return "", errExit
 		}
@@ -220,3 +512,119 @@ func (r *REPL) readMulti(line string) (string, error) {
 		buf.WriteString(line)
 	}
 }
+
+// save the line to history (if the input keeps history), skipping blank lines
+// and lines that repeat the previous one.
+func (r *REPL) remember(line string) {
+	saver, ok := r.input.(historySaver)
+	if !ok || len(strings.TrimSpace(line)) == 0 || line == r.lastHistory {
+		return
+	}
+
+	r.lastHistory = line
+	saver.SaveHistory(line)
+}
+
+// the history file used for a REPL with the given name when none is
+// configured.
+func defaultHistoryPath(name string) string {
+	safeName := strings.Map(func(c rune) rune {
+		if c == '-' || c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			return c
+		}
+
+		return '_'
+	}, strings.TrimSpace(name))
+	if len(safeName) == 0 {
+		safeName = "repl"
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".repl-history"
+	}
+
+	return filepath.Join(dir, "luna", "history", safeName+".history")
+}
+
+// compactHistory prepares the history file for use, creating its directory if
+// necessary, removing all but the latest occurrence of duplicate lines and
+// trimming it to the limit.
+func compactHistory(path string, limit int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(contents), "\n"), "\n")
+	seen := make(map[string]bool)
+	kept := make([]string, 0, len(lines))
+	for i := len(lines) - 1; i >= 0 && len(kept) < limit; i-- {
+		if len(lines[i]) == 0 || seen[lines[i]] {
+			continue
+		}
+		seen[lines[i]] = true
+		kept = append(kept, lines[i])
+	}
+
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+
+	return ioutil.WriteFile(path, []byte(strings.Join(kept, "\n")+"\n"), 0600)
+}
+
+// determine if colored output can be written to w, only terminals that haven't
+// opted out with NO_COLOR qualify.
+func isColorTerminal(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	f, ok := w.(*os.File)
+
+	return ok && readline.IsTerminal(int(f.Fd()))
+}
+
+// ioLineReader reads lines from an io.Reader, writing the prompt to an
+// io.Writer before each line is read.
+type ioLineReader struct {
+	in     *bufio.Reader
+	out    io.Writer
+	prompt string
+}
+
+// NewLineReader creates a LineReader that reads lines from in, writing the
+// current prompt to out (if given) before each one. Once in is exhausted
+// Readline returns io.EOF.
+func NewLineReader(in io.Reader, out io.Writer) LineReader {
+	return &ioLineReader{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// Readline implements LineReader.
+func (lr *ioLineReader) Readline() (string, error) {
+	if lr.out != nil {
+		fmt.Fprint(lr.out, lr.prompt)
+	}
+
+	line, err := lr.in.ReadString('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// SetPrompt implements LineReader.
+func (lr *ioLineReader) SetPrompt(prompt string) {
+	lr.prompt = prompt
+}
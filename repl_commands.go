@@ -0,0 +1,186 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// REPLCommand is a command that can be run in the REPL by entering a '.'
+// followed by the name of the command, such as `.help`.
+type REPLCommand struct {
+	// Help is a short description of the command, displayed by .help
+	Help string
+
+	// Run performs the command, args is the rest of the input line after the
+	// command name with surrounding whitespace removed.
+	Run func(r *REPL, args string) error
+}
+
+// RunCommand executes a line of input as a dot-command.
+func (r *REPL) RunCommand(line string) error {
+	line = strings.TrimSpace(line)
+	name := strings.TrimPrefix(line, ".")
+	args := ""
+	if i := strings.IndexAny(name, " \t"); i >= 0 {
+		name, args = name[:i], strings.TrimSpace(name[i:])
+	}
+
+	cmd, ok := r.commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command .%s, try .help", name)
+	}
+
+	return cmd.Run(r, args)
+}
+
+// determine if the input line is a dot-command
+func isREPLCommand(line string) bool {
+	line = strings.TrimSpace(line)
+
+	return len(line) > 1 && line[0] == '.' && line[1] != '.'
+}
+
+// the commands every REPL starts with
+func builtinREPLCommands() map[string]REPLCommand {
+	return map[string]REPLCommand{
+		"exit": {
+			Help: "end the REPL session",
+			Run: func(*REPL, string) error {
+				return errExit
+			},
+		},
+		"help": {
+			Help: "list the available commands",
+			Run:  replHelp,
+		},
+		"load": {
+			Help: "execute the given file in the session",
+			Run:  replLoad,
+		},
+		"save": {
+			Help: "write the input executed in the session to the given file",
+			Run:  replSave,
+		},
+		"reset": {
+			Help: "start over with a fresh engine",
+			Run: func(r *REPL, _ string) error {
+				r.Reset()
+				fmt.Fprintln(r.output, " => engine reset")
+
+				return nil
+			},
+		},
+		"globals": {
+			Help: "list the global variables and their types",
+			Run:  replGlobals,
+		},
+		"time": {
+			Help: "time the given expression, or the next one entered",
+			Run:  replTime,
+		},
+	}
+}
+
+// .help
+func replHelp(r *REPL, _ string) error {
+	names := make([]string, 0, len(r.commands))
+	width := 0
+	for name := range r.commands {
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(r.output, "  .%-*s  %s\n", width, name, r.commands[name].Help)
+	}
+
+	return nil
+}
+
+// .load <file>
+func replLoad(r *REPL, args string) error {
+	if len(args) == 0 {
+		return errors.New("usage: .load <file>")
+	}
+
+	src, err := ioutil.ReadFile(args)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(r.output, r.Execute(string(src)).String())
+
+	return nil
+}
+
+// .save <file>
+func replSave(r *REPL, args string) error {
+	if len(args) == 0 {
+		return errors.New("usage: .save <file>")
+	}
+
+	src := strings.Join(r.session, "\n") + "\n"
+	if err := ioutil.WriteFile(args, []byte(src), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(r.output, " => saved %d input(s) to %s\n", len(r.session), args)
+
+	return nil
+}
+
+// .globals
+func replGlobals(r *REPL, _ string) error {
+	types := make(map[string]string)
+	names := make([]string, 0)
+	width := 0
+	r.engine.GetGlobals().ForEach(func(key, val *Value) {
+		if !key.IsString() {
+			return
+		}
+
+		name := key.AsString()
+		typ := val.lval.Type().String()
+		if val.lval.Type() == glua.LTUserData {
+			typ = fmt.Sprintf("%s (%T)", typ, val.Interface())
+		}
+
+		names = append(names, name)
+		types[name] = typ
+		if len(name) > width {
+			width = len(name)
+		}
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(r.output, "  %-*s  %s\n", width, name, types[name])
+	}
+
+	return nil
+}
+
+// .time [expression]
+func replTime(r *REPL, args string) error {
+	if len(args) == 0 {
+		r.timeNext = true
+		fmt.Fprintln(r.output, " => timing the next input")
+
+		return nil
+	}
+
+	result := r.Execute(args)
+	fmt.Fprint(r.output, result.String())
+	fmt.Fprintf(r.output, " (%s)\n", result.Duration)
+
+	return nil
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	glua "github.com/yuin/gopher-lua"
+	gluar "layeh.com/gopher-luar"
+)
+
+// replCompleter adapts the REPL to the readline.AutoCompleter interface.
+type replCompleter struct {
+	repl *REPL
+}
+
+// Do implements readline.AutoCompleter, it returns the remaining text for each
+// candidate along with the length of the partial name being completed.
+func (rc *replCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	input := string(line[:pos])
+	partial := completionPartial(input)
+	candidates := rc.repl.Completions(input)
+
+	suffixes := make([][]rune, 0, len(candidates))
+	for _, candidate := range candidates {
+		suffixes = append(suffixes, []rune(candidate[len(partial):]))
+	}
+
+	return suffixes, utf8.RuneCountInString(partial)
+}
+
+// Completions returns the names that could complete the expression at the end
+// of the input. Dotted expressions (`player.na`) complete table keys, struct
+// fields and methods, colon expressions (`player:na`) only complete functions
+// and methods. Modules that have been required can be completed by their name.
+func (r *REPL) Completions(input string) []string {
+	expr := completionExpr(input)
+	partial := completionPartial(input)
+	path := expr[:len(expr)-len(partial)]
+
+	var names []string
+	if len(path) == 0 {
+		names = r.globalNames()
+	} else {
+		sep := path[len(path)-1]
+		target, goType, ok := r.resolveCompletionPath(path[:len(path)-1])
+		if !ok {
+			return nil
+		}
+		if goType != nil {
+			names = r.engine.goMemberNames(goType, sep == ':')
+		} else {
+			names = r.engine.memberNames(target, sep == ':')
+		}
+	}
+
+	seen := make(map[string]bool)
+	matches := make([]string, 0)
+	for _, name := range names {
+		if strings.HasPrefix(name, partial) && !seen[name] {
+			seen[name] = true
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+
+	return matches
+}
+
+// global names, including the names of required modules
+func (r *REPL) globalNames() []string {
+	names := make([]string, 0)
+	collect := func(key, _ *Value) {
+		if key.IsString() {
+			names = append(names, key.AsString())
+		}
+	}
+
+	r.engine.GetGlobals().ForEach(collect)
+	r.engine.loadedModules().ForEach(collect)
+
+	return names
+}
+
+// follow a dotted path from the globals, without triggering any metamethods
+// or Go code that could raise errors. Once the path reaches a Go value the rest
+// of it is followed through the fields of its type, returning the Go type of
+// the field the path ends on.
+func (r *REPL) resolveCompletionPath(path string) (*Value, reflect.Type, bool) {
+	segments := strings.FieldsFunc(path, func(c rune) bool {
+		return c == '.' || c == ':'
+	})
+	if len(segments) == 0 {
+		return nil, nil, false
+	}
+
+	current := r.engine.GetGlobals().RawGet(segments[0])
+	if current.IsNil() {
+		current = r.engine.loadedModules().RawGet(segments[0])
+	}
+
+	var goType reflect.Type
+	for _, segment := range segments[1:] {
+		switch {
+		case goType != nil:
+			goType = r.engine.goFieldType(goType, segment)
+			if goType == nil {
+				return nil, nil, false
+			}
+		case current.IsTable():
+			current = rawLookup(current, segment)
+		case current.IsUserData():
+			goType = r.engine.goFieldType(reflect.TypeOf(current.Interface()), segment)
+			if goType == nil {
+				return nil, nil, false
+			}
+		default:
+			return nil, nil, false
+		}
+	}
+
+	return current, goType, goType != nil || !current.IsNil()
+}
+
+// the package.loaded table
+func (e *Engine) loadedModules() *Value {
+	return e.GetEnviron().RawGet("package").RawGet("loaded")
+}
+
+// memberNames lists the names that can follow a '.' or ':' on the given value.
+func (e *Engine) memberNames(val *Value, methodsOnly bool) []string {
+	names := make([]string, 0)
+
+	switch val.lval.Type() {
+	case glua.LTTable:
+		// walk the table and any tables it inherits from through __index
+		seen := make(map[*glua.LTable]bool)
+		for tbl := val; tbl.IsTable() && !seen[tbl.asTable()]; tbl = e.indexTable(tbl) {
+			seen[tbl.asTable()] = true
+			tbl.ForEach(func(key, member *Value) {
+				if key.IsString() && (!methodsOnly || member.IsFunction()) {
+					names = append(names, key.AsString())
+				}
+			})
+		}
+	case glua.LTUserData:
+		names = append(names, e.goMemberNames(reflect.TypeOf(val.Interface()), methodsOnly)...)
+	case glua.LTString:
+		if methodsOnly {
+			return e.memberNames(e.GetGlobals().RawGet("string"), true)
+		}
+	}
+
+	return names
+}
+
+// return the __index table of the table's metatable, if it has one
+func (e *Engine) indexTable(tbl *Value) *Value {
+	mt, ok := tbl.asTable().Metatable.(*glua.LTable)
+	if !ok {
+		return e.Nil()
+	}
+
+	return e.newValue(mt.RawGetString("__index"))
+}
+
+// names gopher-luar exposes for the fields and methods of a Go type, using the
+// name transformers configured for the engine.
+func (e *Engine) goMemberNames(typ reflect.Type, methodsOnly bool) []string {
+	if typ == nil {
+		return nil
+	}
+
+	config := gluar.GetConfig(e.state)
+	names := make([]string, 0)
+
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+		if config.MethodNames != nil {
+			names = append(names, config.MethodNames(typ, method)...)
+		} else {
+			names = append(names, defaultLuarNames(method.Name)...)
+		}
+	}
+
+	if methodsOnly {
+		return names
+	}
+
+	e.eachGoField(typ, func(_ reflect.StructField, fieldNames []string) bool {
+		names = append(names, fieldNames...)
+
+		return true
+	})
+
+	return names
+}
+
+// the type of the field of a Go type gopher-luar exposes by the given name, or
+// nil if it has no such field
+func (e *Engine) goFieldType(typ reflect.Type, name string) reflect.Type {
+	var found reflect.Type
+	e.eachGoField(typ, func(field reflect.StructField, fieldNames []string) bool {
+		for _, fieldName := range fieldNames {
+			if fieldName == name {
+				found = field.Type
+
+				return false
+			}
+		}
+
+		return true
+	})
+
+	return found
+}
+
+// call fn with the exported fields of a struct type (or pointer to one),
+// including those of embedded structs, and the names gopher-luar exposes them
+// by, until fn returns false
+func (e *Engine) eachGoField(typ reflect.Type, fn func(field reflect.StructField, names []string) bool) {
+	structType := typ
+	if structType != nil && structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType == nil || structType.Kind() != reflect.Struct {
+		return
+	}
+
+	config := gluar.GetConfig(e.state)
+
+	var collect func(t reflect.Type) bool
+	collect = func(t reflect.Type) bool {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct && !collect(embedded) {
+					return false
+				}
+			}
+
+			if field.PkgPath != "" {
+				// unexported
+				continue
+			}
+
+			var names []string
+			if config.FieldNames != nil {
+				names = config.FieldNames(structType, field)
+			} else {
+				names = defaultLuarNames(field.Name)
+			}
+			if !fn(field, names) {
+				return false
+			}
+		}
+
+		return true
+	}
+	collect(structType)
+}
+
+// gopher-luar exposes Go names as is and with the first letter lower-cased
+// when no transformer has been configured.
+func defaultLuarNames(name string) []string {
+	first, n := utf8.DecodeRuneInString(name)
+	if n == 0 {
+		return []string{name}
+	}
+
+	return []string{name, string(unicode.ToLower(first)) + name[n:]}
+}
+
+// look up a key in a table, following __index tables (but never functions)
+func rawLookup(tbl *Value, key string) *Value {
+	seen := make(map[*glua.LTable]bool)
+	for current := tbl; current.IsTable() && !seen[current.asTable()]; current = current.owner.indexTable(current) {
+		seen[current.asTable()] = true
+		if val := current.RawGet(key); !val.IsNil() {
+			return val
+		}
+	}
+
+	return tbl.owner.Nil()
+}
+
+// the expression being completed at the end of the input, such as
+// `player.inventory:ad`
+func completionExpr(input string) string {
+	start := len(input)
+	for start > 0 {
+		c := input[start-1]
+		if c == '.' || c == ':' || c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) {
+			start--
+
+			continue
+		}
+
+		break
+	}
+
+	return input[start:]
+}
+
+// the partial name being completed at the end of the input
+func completionPartial(input string) string {
+	expr := completionExpr(input)
+	if i := strings.LastIndexAny(expr, ".:"); i >= 0 {
+		return expr[i+1:]
+	}
+
+	return expr
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// REPLEngineProvider supplies the engine for a remote REPL session. Along with
+// the engine it returns a function that is called once the session has ended,
+// to clean up or give back the engine.
+type REPLEngineProvider func() (*Engine, func(), error)
+
+// DedicatedEngines returns a provider that creates a brand new engine with the
+// given options for every session, the engine is closed when the session ends.
+func DedicatedEngines(options EngineOptions) REPLEngineProvider {
+	return func() (*Engine, func(), error) {
+		eng := NewEngineWithOptions(options)
+
+		return eng, eng.Close, nil
+	}
+}
+
+// PooledEngines returns a provider that checks an engine out of the pool for
+// each session. The engine is discarded when the session ends rather than
+// released, as the remote user may have changed its globals and loaded modules,
+// and the pool builds a fresh engine in its place when one is needed.
+func PooledEngines(pool *EnginePool) REPLEngineProvider {
+	return func() (*Engine, func(), error) {
+		pe, err := pool.Get()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return pe.Engine, pe.Discard, nil
+	}
+}
+
+// REPLServer serves REPL sessions to network connections, each connection gets
+// its own session and engine from the Provider.
+type REPLServer struct {
+	// Provider supplies the engine for each session.
+	Provider REPLEngineProvider
+
+	// Name is injected into the prompt of each session.
+	Name string
+
+	// Secret, when set, must be sent as the first line of a connection before
+	// the session begins.
+	Secret string
+
+	// IdleTimeout ends sessions that have been waiting for input for the given
+	// duration. Time spent running the input doesn't count, so long running
+	// input isn't cut short. A value of 0 means sessions never time out.
+	IdleTimeout time.Duration
+
+	// AllowFileCommands gives sessions the .load and .save commands. They read
+	// and write files on the server outside of the engine, so they're disabled
+	// unless the clients are trusted with the server's file system.
+	AllowFileCommands bool
+
+	listener net.Listener
+	sessions map[net.Conn]context.CancelFunc
+	mutex    *sync.Mutex
+	wg       *sync.WaitGroup
+	closed   bool
+	once     sync.Once
+}
+
+// ServeREPL accepts connections on the listener, giving each its own REPL
+// session on an engine supplied by the provider. It blocks until the listener
+// fails or is closed.
+func ServeREPL(listener net.Listener, engineProvider REPLEngineProvider) error {
+	server := &REPLServer{
+		Provider: engineProvider,
+	}
+
+	return server.Serve(listener)
+}
+
+// Serve accepts connections on the listener and serves REPL sessions to them.
+// It blocks until the listener fails or Close is called, returning nil in the
+// latter case.
+func (s *REPLServer) Serve(listener net.Listener) error {
+	s.init()
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+
+		return errors.New("repl server has been closed")
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return nil
+			}
+
+			return err
+		}
+
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+
+			return nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.sessions[conn] = cancel
+		s.wg.Add(1)
+		s.mutex.Unlock()
+
+		go s.serveSession(ctx, cancel, conn)
+	}
+}
+
+// Close stops accepting new connections and ends every active session,
+// stopping any Lua they're running. It waits for all sessions to finish
+// tearing down before returning.
+func (s *REPLServer) Close() error {
+	s.init()
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+
+		return nil
+	}
+	s.closed = true
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn, cancel := range s.sessions {
+		cancel()
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+// lazily prepare internal state, allowing REPLServer literals to be used
+func (s *REPLServer) init() {
+	s.once.Do(func() {
+		s.mutex = new(sync.Mutex)
+		s.wg = new(sync.WaitGroup)
+		s.sessions = make(map[net.Conn]context.CancelFunc)
+	})
+}
+
+// run a single REPL session for the connection, the Lua it runs is stopped
+// when the context is canceled
+func (s *REPLServer) serveSession(ctx context.Context, cancel context.CancelFunc, conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		cancel()
+		conn.Close()
+		s.mutex.Lock()
+		delete(s.sessions, conn)
+		s.mutex.Unlock()
+	}()
+
+	idle := newIdleConn(conn, s.IdleTimeout, cancel)
+	defer idle.stop()
+	in := bufio.NewReader(idle)
+
+	if len(s.Secret) > 0 {
+		fmt.Fprint(conn, "secret: ")
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		given := strings.TrimRight(line, "\r\n")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.Secret)) != 1 {
+			fmt.Fprintln(conn, "authentication failed")
+
+			return
+		}
+	}
+
+	eng, done, err := s.Provider()
+	if err != nil {
+		fmt.Fprintf(conn, "failed to start session: %s\n", err)
+
+		return
+	}
+	if done != nil {
+		defer done()
+	}
+	eng.SetContext(ctx)
+	defer eng.RemoveContext()
+
+	name := s.Name
+	if len(name) == 0 {
+		name = "luna"
+	}
+
+	repl := NewREPLWithConfig(REPLConfig{
+		Engine:     eng,
+		Name:       name,
+		LineReader: NewLineReader(in, conn),
+		Output:     conn,
+		NewEngine: func() *Engine {
+			fresh := NewEngineWithOptions(eng.Options)
+			fresh.SetContext(ctx)
+
+			return fresh
+		},
+
+		DisableFileCommands: !s.AllowFileCommands,
+	})
+	defer repl.Close()
+
+	err = repl.Run()
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		fmt.Fprintln(conn, "\nsession timed out")
+	}
+}
+
+// idleConn ends the session when a read has waited for input for the timeout,
+// canceling the session's context and failing the read with a timeout error.
+// The timer only runs during reads, so the time taken by the input read isn't
+// counted.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// wrap the connection, calling cancel if a read waits for the timeout
+func newIdleConn(conn net.Conn, timeout time.Duration, cancel context.CancelFunc) *idleConn {
+	ic := &idleConn{Conn: conn, timeout: timeout}
+	if timeout > 0 {
+		ic.timer = time.AfterFunc(timeout, func() {
+			cancel()
+			conn.SetReadDeadline(time.Now())
+		})
+		ic.timer.Stop()
+	}
+
+	return ic
+}
+
+// Read implements io.Reader.
+func (ic *idleConn) Read(p []byte) (int, error) {
+	if ic.timer != nil {
+		ic.timer.Reset(ic.timeout)
+		defer ic.timer.Stop()
+	}
+
+	return ic.Conn.Read(p)
+}
+
+// stop the idle timer
+func (ic *idleConn) stop() {
+	if ic.timer != nil {
+		ic.timer.Stop()
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("REPLServer", func() {
+	var (
+		server   *REPLServer
+		listener net.Listener
+		served   chan error
+	)
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).Should(BeNil())
+
+		dedicated := DedicatedEngines(EngineOptions{})
+		server = &REPLServer{
+			Provider: func() (*Engine, func(), error) {
+				eng, done, err := dedicated()
+				if err == nil {
+					eng.SetGlobal("sleep", func(ms int) {
+						time.Sleep(time.Duration(ms) * time.Millisecond)
+					})
+				}
+
+				return eng, done, err
+			},
+			Secret:      "hunter2",
+			IdleTimeout: 200 * time.Millisecond,
+		}
+		served = make(chan error, 1)
+		go func() {
+			served <- server.Serve(listener)
+		}()
+	})
+
+	AfterEach(func() {
+		Ω(server.Close()).Should(Succeed())
+		Eventually(served).Should(Receive(BeNil()))
+	})
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Ω(err).Should(BeNil())
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	readUntil := func(in *bufio.Reader, text string) string {
+		var buf strings.Builder
+		for !strings.Contains(buf.String(), text) {
+			b, err := in.ReadByte()
+			if err != nil {
+				break
+			}
+			buf.WriteByte(b)
+		}
+
+		return buf.String()
+	}
+
+	It("runs a session for an authenticated connection", func() {
+		conn, in := dial()
+		defer conn.Close()
+
+		conn.Write([]byte("hunter2\n1 + 1\n"))
+		Ω(readUntil(in, " => 2\n")).Should(ContainSubstring(" => 2\n"))
+	})
+
+	It("doesn't give sessions the file commands", func() {
+		conn, in := dial()
+		defer conn.Close()
+
+		conn.Write([]byte("hunter2\n.load /etc/passwd\n.save /tmp/luna-repl-save\n"))
+		Ω(readUntil(in, "unknown command .load")).Should(ContainSubstring("unknown command .load"))
+		Ω(readUntil(in, "unknown command .save")).Should(ContainSubstring("unknown command .save"))
+	})
+
+	It("rejects connections with the wrong secret", func() {
+		conn, in := dial()
+		defer conn.Close()
+
+		conn.Write([]byte("wrong\n"))
+		Ω(readUntil(in, "authentication failed")).Should(ContainSubstring("authentication failed"))
+	})
+
+	It("ends idle sessions", func() {
+		conn, in := dial()
+		defer conn.Close()
+
+		conn.Write([]byte("hunter2\n"))
+		Ω(readUntil(in, "session timed out")).Should(ContainSubstring("session timed out"))
+	})
+
+	It("doesn't count the time spent running input as idle", func() {
+		conn, in := dial()
+		defer conn.Close()
+
+		conn.Write([]byte("hunter2\nsleep(400)\n1 + 1\n"))
+		Ω(readUntil(in, " => 2\n")).Should(ContainSubstring(" => 2\n"))
+	})
+
+	It("discards pooled engines when their sessions end", func() {
+		pool := NewEnginePool(1, nil)
+		defer pool.Shutdown()
+
+		pooled, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).Should(BeNil())
+		go ServeREPL(pooled, PooledEngines(pool))
+		defer pooled.Close()
+
+		conn, err := net.Dial("tcp", pooled.Addr().String())
+		Ω(err).Should(BeNil())
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		in := bufio.NewReader(conn)
+		conn.Write([]byte("secret = 1\nsecret\n"))
+		Ω(readUntil(in, " => 1\n")).Should(ContainSubstring(" => 1\n"))
+		conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		pe, err := pool.GetContext(ctx)
+		Ω(err).Should(BeNil())
+		defer pe.Release()
+		Ω(pe.GetGlobal("secret").IsNil()).Should(BeTrue())
+	})
+
+	It("stops running Lua when closed", func() {
+		conn, _ := dial()
+		defer conn.Close()
+
+		conn.Write([]byte("hunter2\nwhile true do end\n"))
+		time.Sleep(50 * time.Millisecond)
+
+		closed := make(chan error, 1)
+		go func() {
+			closed <- server.Close()
+		}()
+		Eventually(closed, time.Second).Should(Receive(BeNil()))
+	})
+})
@@ -0,0 +1,280 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("REPL", func() {
+	var (
+		engine *Engine
+		output *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		engine = NewEngine()
+		output = new(bytes.Buffer)
+	})
+
+	AfterEach(func() {
+		engine.Close()
+	})
+
+	newREPL := func(input string) *REPL {
+		return NewREPLWithConfig(REPLConfig{
+			Engine: engine,
+			Name:   "test",
+			Prompt: "{name} ({n})> ",
+			Input:  strings.NewReader(input),
+			Output: output,
+		})
+	}
+
+	Describe("Execute()", func() {
+		It("returns the values produced", func() {
+			result := newREPL("").Execute("1 + 1, 'two'")
+			Ω(result.Err).Should(BeNil())
+			Ω(result.Values).Should(HaveLen(2))
+			Ω(result.Inspected).Should(Equal([]string{"2", `"two"`}))
+		})
+
+		It("returns errors rather than printing them", func() {
+			result := newREPL("").Execute("error('boom')")
+			Ω(result.Err).ShouldNot(BeNil())
+			Ω(output.Len()).Should(Equal(0))
+		})
+	})
+
+	Describe("Run()", func() {
+		It("reads from the input and writes to the output until it's exhausted", func() {
+			err := newREPL("x = 10\nx * 2\n").Run()
+			Ω(err).Should(BeNil())
+			Ω(output.String()).Should(ContainSubstring("test (0)> "))
+			Ω(output.String()).Should(ContainSubstring("test (1)> "))
+			Ω(output.String()).Should(ContainSubstring(" => 20\n"))
+		})
+
+		It("stops once Exit is called", func() {
+			repl := newREPL("quit()\nanswer = 42\n")
+			engine.SetGlobal("quit", func() {
+				repl.Exit()
+			})
+
+			Ω(repl.Run()).Should(Succeed())
+			Ω(engine.GetGlobal("answer").IsNil()).Should(BeTrue())
+		})
+
+		It("handles multi-line input", func() {
+			err := newREPL("function add(a, b)\nreturn a + b\nend\nadd(1, 2)\n").Run()
+			Ω(err).Should(BeNil())
+			Ω(output.String()).Should(ContainSubstring("test (*)> "))
+			Ω(output.String()).Should(ContainSubstring(" => 3\n"))
+		})
+	})
+
+	Describe("dot-commands", func() {
+		It("lists globals with their types", func() {
+			err := newREPL("answer = 42\n.globals\n").Run()
+			Ω(err).Should(BeNil())
+			Ω(output.String()).Should(MatchRegexp(`answer\s+number`))
+		})
+
+		It("resets the engine", func() {
+			repl := newREPL("answer = 42\n.reset\nanswer\n")
+			Ω(repl.Run()).Should(Succeed())
+			Ω(repl.Engine()).ShouldNot(Equal(engine))
+			Ω(repl.Engine().GetGlobal("answer").IsNil()).Should(BeTrue())
+			repl.Close()
+		})
+
+		It("stops at .exit", func() {
+			err := newREPL(".exit\nanswer = 42\n").Run()
+			Ω(err).Should(BeNil())
+			Ω(engine.GetGlobal("answer").IsNil()).Should(BeTrue())
+		})
+
+		It("runs custom commands", func() {
+			var given string
+			repl := NewREPLWithConfig(REPLConfig{
+				Engine: engine,
+				Input:  strings.NewReader(".greet world\n"),
+				Output: output,
+				Commands: map[string]REPLCommand{
+					"greet": {
+						Help: "say hello",
+						Run: func(_ *REPL, args string) error {
+							given = args
+
+							return nil
+						},
+					},
+				},
+			})
+			Ω(repl.Run()).Should(Succeed())
+			Ω(given).Should(Equal("world"))
+		})
+
+		It("reports unknown commands", func() {
+			Ω(newREPL("").RunCommand(".nope")).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Completions()", func() {
+		var repl *REPL
+
+		BeforeEach(func() {
+			repl = newREPL("")
+			engine.SetGlobal("player", &completionPlayer{
+				Name:  "hero",
+				Stats: &completionStats{HitPoints: 8, MaxHitPoints: 10},
+			})
+			Ω(engine.DoString(`
+				config = { volume = 10, verbose = true }
+				function config.validate() end
+			`)).Should(Succeed())
+		})
+
+		It("completes globals", func() {
+			Ω(repl.Completions("pla")).Should(Equal([]string{"player"}))
+		})
+
+		It("completes table keys", func() {
+			Ω(repl.Completions("config.v")).Should(Equal([]string{"validate", "verbose", "volume"}))
+		})
+
+		It("only completes functions after a colon", func() {
+			Ω(repl.Completions("config:v")).Should(Equal([]string{"validate"}))
+		})
+
+		It("completes userdata fields and methods using the engine's casing", func() {
+			Ω(repl.Completions("player.")).Should(ContainElement("name"))
+			Ω(repl.Completions("player:")).Should(Equal([]string{"display_name"}))
+		})
+
+		It("completes the fields and methods of nested Go values", func() {
+			Ω(repl.Completions("player.stats.")).Should(ContainElement("max_hit_points"))
+			Ω(repl.Completions("player.stats:")).Should(Equal([]string{"total"}))
+			Ω(repl.Completions("player.stats.max_hit_points.")).Should(BeEmpty())
+		})
+
+		It("completes modules that have been required", func() {
+			engine.RegisterModule("dice", map[string]interface{}{
+				"roll": func() int { return 4 },
+			})
+			Ω(engine.DoString(`require("dice")`)).Should(Succeed())
+			Ω(repl.Completions("dice.r")).Should(Equal([]string{"roll"}))
+		})
+	})
+
+	Describe("history", func() {
+		It("defaults to a file named after the REPL", func() {
+			repl := NewREPL(engine, "my game")
+			Ω(filepath.Base(repl.HistoryPath())).Should(Equal("my_game.history"))
+		})
+
+		It("honors the configured path", func() {
+			repl := NewREPLWithConfig(REPLConfig{
+				Engine:          engine,
+				HistoryFilePath: "custom-history",
+			})
+			Ω(repl.HistoryPath()).Should(Equal("custom-history"))
+		})
+
+		It("can be disabled", func() {
+			repl := NewREPLWithConfig(REPLConfig{
+				Engine:          engine,
+				HistoryFilePath: "custom-history",
+				DisableHistory:  true,
+			})
+			Ω(repl.HistoryPath()).Should(BeEmpty())
+		})
+
+		It("skips blank lines and lines repeating the previous one", func() {
+			reader := &historyLineReader{LineReader: NewLineReader(strings.NewReader("x = 1\nx = 1\n\ny = 2\nx = 1\n"), nil)}
+			repl := NewREPLWithConfig(REPLConfig{
+				Engine:     engine,
+				LineReader: reader,
+				Output:     output,
+			})
+			Ω(repl.Run()).Should(Succeed())
+			Ω(reader.saved).Should(Equal([]string{"x = 1", "y = 2", "x = 1"}))
+		})
+
+		Describe("compacting the file", func() {
+			var path string
+
+			BeforeEach(func() {
+				dir, err := ioutil.TempDir("", "luna-history")
+				Ω(err).Should(BeNil())
+				path = filepath.Join(dir, "nested", "test.history")
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(filepath.Dir(filepath.Dir(path)))
+			})
+
+			compact := func(contents string, limit int) string {
+				Ω(os.MkdirAll(filepath.Dir(path), 0700)).Should(Succeed())
+				Ω(ioutil.WriteFile(path, []byte(contents), 0600)).Should(Succeed())
+				Ω(CompactHistory(path, limit)).Should(Succeed())
+				compacted, err := ioutil.ReadFile(path)
+				Ω(err).Should(BeNil())
+
+				return string(compacted)
+			}
+
+			It("keeps only the latest occurrence of each line", func() {
+				Ω(compact("a\nb\na\n\nc\nc\n", DefaultHistoryLimit)).Should(Equal("b\na\nc\n"))
+			})
+
+			It("keeps only the latest lines up to the limit", func() {
+				Ω(compact("1\n2\n3\n4\n5\n", 3)).Should(Equal("3\n4\n5\n"))
+			})
+
+			It("creates the directory when there's no file yet", func() {
+				Ω(CompactHistory(path, DefaultHistoryLimit)).Should(Succeed())
+				Ω(filepath.Dir(path)).Should(BeADirectory())
+			})
+		})
+	})
+})
+
+// historyLineReader records the lines the REPL saves to its history
+type historyLineReader struct {
+	LineReader
+	saved []string
+}
+
+func (hr *historyLineReader) SaveHistory(line string) error {
+	hr.saved = append(hr.saved, line)
+
+	return nil
+}
+
+type completionPlayer struct {
+	Name  string
+	Stats *completionStats
+}
+
+func (cp *completionPlayer) DisplayName() string {
+	return cp.Name
+}
+
+type completionStats struct {
+	HitPoints    int
+	MaxHitPoints int
+}
+
+func (cs *completionStats) Total() int {
+	return cs.HitPoints + cs.MaxHitPoints
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2020 Brandon Buck
+
+package transformers
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Acronyms is a dictionary of acronyms recognized when splitting names into
+// words, so "HTTPServerURL" becomes "HTTP", "Server" and "URL" and "IPv6Addr"
+// becomes "IPv6" and "Addr". Acronyms are matched exactly (with an optional
+// plural "s") at the start of a word and keep their form in camelCase and Go
+// names. An Acronyms is safe for concurrent use.
+type Acronyms struct {
+	mutex *sync.RWMutex
+	words []string
+	lower map[string]string
+}
+
+// DefaultAcronyms is the dictionary used by the package level functions, add
+// to it to have acronyms recognized everywhere names are converted.
+var DefaultAcronyms = NewAcronyms(
+	"ACL", "API", "ASCII", "CPU", "CSS", "CSV", "DNS", "EOF", "GUID", "HTML",
+	"HTTP", "HTTPS", "ID", "IP", "IPv4", "IPv6", "JSON", "JWT", "LHS", "QPS",
+	"RAM", "RHS", "RPC", "SLA", "SMTP", "SQL", "SSH", "TCP", "TLS", "TTL", "UDP",
+	"UI", "UID", "URI", "URL", "UTF8", "UUID", "VM", "XML", "XMPP", "XSRF",
+	"XSS", "YAML",
+)
+
+// NewAcronyms creates a dictionary of the given acronyms.
+func NewAcronyms(words ...string) *Acronyms {
+	a := &Acronyms{
+		mutex: new(sync.RWMutex),
+		lower: make(map[string]string),
+	}
+	a.Add(words...)
+
+	return a
+}
+
+// Add adds acronyms to the dictionary.
+func (a *Acronyms) Add(words ...string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, word := range words {
+		if len(word) == 0 {
+			continue
+		}
+		if _, ok := a.lower[strings.ToLower(word)]; ok {
+			continue
+		}
+		a.words = append(a.words, word)
+		a.lower[strings.ToLower(word)] = word
+	}
+
+	// longest first so HTTPS is matched before HTTP
+	sort.SliceStable(a.words, func(i, j int) bool {
+		return len(a.words[i]) > len(a.words[j])
+	})
+}
+
+// Words returns the acronyms in the dictionary.
+func (a *Acronyms) Words() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	words := append([]string{}, a.words...)
+	sort.Strings(words)
+
+	return words
+}
+
+// Split breaks a name into its words, names can be in any of the cases this
+// package produces. Underscores and hyphens separate words and are dropped.
+func (a *Acronyms) Split(name string) []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	runes := []rune(name)
+	length := len(runes)
+
+	var (
+		words []string
+		word  []rune
+	)
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+
+	for i := 0; i < length; {
+		r := runes[i]
+		if r == '_' || r == '-' {
+			flush()
+			i++
+
+			continue
+		}
+
+		if len(word) == 0 || isWordStart(runes, i) {
+			if n := a.match(runes, i); n > 0 {
+				flush()
+				words = append(words, string(runes[i:i+n]))
+				i += n
+
+				continue
+			}
+			flush()
+		}
+
+		word = append(word, r)
+		i++
+	}
+	flush()
+
+	return words
+}
+
+// determines if a new word begins at i in a name without acronyms, an upper
+// case letter following a lower case letter or followed by one.
+func isWordStart(runes []rune, i int) bool {
+	if i == 0 || !unicode.IsUpper(runes[i]) {
+		return false
+	}
+
+	return unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))
+}
+
+// the length of the acronym found at i, if the word ends with it, or 0 when
+// none is found. The lock must be held.
+func (a *Acronyms) match(runes []rune, i int) int {
+	for _, acronym := range a.words {
+		n := len([]rune(acronym))
+		if i+n > len(runes) || string(runes[i:i+n]) != acronym {
+			continue
+		}
+		if a.endsWord(runes, i+n) {
+			return n
+		}
+		if runes[i+n] == 's' && a.endsWord(runes, i+n+1) {
+			return n + 1
+		}
+	}
+
+	return 0
+}
+
+// determines if a word ends before i, the name ends there, a separator follows
+// or a new word starts, so "IDLE" isn't split into "ID" and "LE". The lock must
+// be held.
+func (a *Acronyms) endsWord(runes []rune, i int) bool {
+	if i >= len(runes) {
+		return true
+	}
+	if r := runes[i]; r == '_' || r == '-' {
+		return true
+	}
+
+	return isWordStart(runes, i) || a.match(runes, i) > 0
+}
+
+// the form of the word in the dictionary, including a plural "s", if it's an
+// acronym
+func (a *Acronyms) acronym(word string) (string, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	lower := strings.ToLower(word)
+	if acronym, ok := a.lower[lower]; ok {
+		return acronym, true
+	}
+	if strings.HasSuffix(lower, "s") {
+		if acronym, ok := a.lower[strings.TrimSuffix(lower, "s")]; ok {
+			return acronym + "s", true
+		}
+	}
+
+	return "", false
+}
+
+// ToSnake converts a name to snake_case.
+func (a *Acronyms) ToSnake(in string) string {
+	return a.join(in, "_", strings.ToLower)
+}
+
+// ToScreamingSnake converts a name to SCREAMING_SNAKE_CASE.
+func (a *Acronyms) ToScreamingSnake(in string) string {
+	return a.join(in, "_", strings.ToUpper)
+}
+
+// ToKebab converts a name to kebab-case.
+func (a *Acronyms) ToKebab(in string) string {
+	return a.join(in, "-", strings.ToLower)
+}
+
+// ToCamel converts a name to camelCase, the first word is in lower case and
+// acronyms after it keep their form (as in "userID").
+func (a *Acronyms) ToCamel(in string) string {
+	words := a.Split(in)
+	for i, word := range words {
+		switch {
+		case i == 0:
+			words[i] = strings.ToLower(word)
+		default:
+			if acronym, ok := a.acronym(word); ok {
+				words[i] = acronym
+			} else {
+				words[i] = upperFirst(word)
+			}
+		}
+	}
+
+	return strings.Join(words, "")
+}
+
+// ToGo converts a name in any of the cases this package produces back into a
+// Go (exported) name, restoring acronyms. Names entirely in upper case (such
+// as SCREAMING_SNAKE_CASE) have the words that aren't acronyms put into lower
+// case after their first letter.
+func (a *Acronyms) ToGo(in string) string {
+	screaming := strings.ToUpper(in) == in
+	words := a.Split(in)
+	for i, word := range words {
+		if acronym, ok := a.acronym(word); ok {
+			words[i] = acronym
+
+			continue
+		}
+		if screaming {
+			word = strings.ToLower(word)
+		}
+		words[i] = upperFirst(word)
+	}
+
+	return strings.Join(words, "")
+}
+
+// split the name into words, converting and joining them
+func (a *Acronyms) join(in, sep string, convert func(string) string) string {
+	words := a.Split(in)
+	for i, word := range words {
+		words[i] = convert(word)
+	}
+
+	return strings.Join(words, sep)
+}
+
+// the word with its first letter in upper case
+func upperFirst(word string) string {
+	runes := []rune(word)
+	if len(runes) == 0 {
+		return word
+	}
+	runes[0] = unicode.ToUpper(runes[0])
+
+	return string(runes)
+}
+
+// StringToKebab converts an exported Go name to kebab-case using the
+// DefaultAcronyms.
+func StringToKebab(in string) string {
+	return DefaultAcronyms.ToKebab(in)
+}
+
+// StringToScreamingSnake converts an exported Go name to SCREAMING_SNAKE_CASE
+// using the DefaultAcronyms.
+func StringToScreamingSnake(in string) string {
+	return DefaultAcronyms.ToScreamingSnake(in)
+}
+
+// StringToGo converts a name produced by this package (a Lua name) back into
+// the Go name it came from using the DefaultAcronyms, for error messages and
+// documentation.
+func StringToGo(in string) string {
+	return DefaultAcronyms.ToGo(in)
+}
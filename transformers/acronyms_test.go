@@ -0,0 +1,77 @@
+// Copyright (c) 2020 Brandon Buck
+
+package transformers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/bbuck/luna/transformers"
+)
+
+var _ = Describe("Acronyms", func() {
+	DescribeTable("StringToSnake with acronyms",
+		func(input, expected string) {
+			Ω(transformers.StringToSnake(input)).Should(Equal(expected))
+		},
+		Entry("trailing acronym", "UserID", "user_id"),
+		Entry("adjacent acronyms", "HTTPServerURL", "http_server_url"),
+		Entry("acronyms without separation", "GetHTTPURL", "get_http_url"),
+		Entry("acronym with lower case letters", "IPv6Addr", "ipv6_addr"),
+		Entry("plural acronym", "UserIDs", "user_ids"),
+		Entry("digits", "Vector3D", "vector3d"),
+		Entry("word starting with an acronym", "IDLE", "idle"),
+		Entry("word starting with a plural acronym", "CPUS", "cpus"),
+		Entry("words starting with acronyms", "IDLEState", "idle_state"),
+		Entry("acronym before a word", "CPUSpeed", "cpu_speed"),
+	)
+
+	DescribeTable("StringToCamel with acronyms",
+		func(input, expected string) {
+			Ω(transformers.StringToCamel(input)).Should(Equal(expected))
+		},
+		Entry("trailing acronym", "UserID", "userID"),
+		Entry("leading acronym", "IPv6Addr", "ipv6Addr"),
+		Entry("adjacent acronyms", "ParseHTTPURL", "parseHTTPURL"),
+	)
+
+	DescribeTable("StringToKebab",
+		func(input, expected string) {
+			Ω(transformers.StringToKebab(input)).Should(Equal(expected))
+		},
+		Entry("basic exported name", "HelloWorld", "hello-world"),
+		Entry("acronyms", "JSONAPIKey", "json-api-key"),
+	)
+
+	DescribeTable("StringToScreamingSnake",
+		func(input, expected string) {
+			Ω(transformers.StringToScreamingSnake(input)).Should(Equal(expected))
+		},
+		Entry("basic exported name", "MaxHitPoints", "MAX_HIT_POINTS"),
+		Entry("acronyms", "DefaultTTLSeconds", "DEFAULT_TTL_SECONDS"),
+	)
+
+	DescribeTable("StringToGo",
+		func(input, expected string) {
+			Ω(transformers.StringToGo(input)).Should(Equal(expected))
+		},
+		Entry("snake case", "http_server_url", "HTTPServerURL"),
+		Entry("camel case", "userID", "UserID"),
+		Entry("kebab case", "ipv6-addr", "IPv6Addr"),
+		Entry("screaming snake case", "MAX_HIT_POINTS", "MaxHitPoints"),
+		Entry("plural acronym", "user_ids", "UserIDs"),
+		Entry("screaming snake case words", "IDLE_CPUS", "IdleCPUs"),
+	)
+
+	It("uses a configurable dictionary", func() {
+		acronyms := transformers.NewAcronyms()
+		Ω(acronyms.ToSnake("LoadNPCXP")).Should(Equal("load_npcxp"))
+
+		acronyms.Add("NPC", "XP")
+		Ω(acronyms.ToSnake("LoadNPCXP")).Should(Equal("load_npc_xp"))
+		Ω(acronyms.ToGo("load_npc_xp")).Should(Equal("LoadNPCXP"))
+		Ω(acronyms.Split("LoadNPCXP")).Should(Equal([]string{"Load", "NPC", "XP"}))
+		Ω(acronyms.Words()).Should(Equal([]string{"NPC", "XP"}))
+	})
+})
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"reflect"
 
 	lua "github.com/yuin/gopher-lua"
 )
@@ -80,35 +81,16 @@ func (v *Value) Inspect(indent string) string {
 
 		return fmt.Sprintf("%g", n)
 	case lua.LTUserData:
+		if str, ok := v.inspectUserData(indent); ok {
+			return str
+		}
 		iface := v.Interface()
-		switch it := iface.(type) {
-		case Inspecter:
-			return it.Inspect(indent)
-		case fmt.Stringer:
-			return it.String()
-		default:
-			ud := v.asUserData()
-			val := v.owner.ValueFor(ud.Metatable)
-
-			mt := v.owner.MetatableFor(ud.Value)
-			vals, err := mt.RawGet("ptr_methods").Invoke("inspect", 1, v, indent)
-			if err == nil && len(vals) > 0 {
-				return vals[0].AsString()
-			}
-
-			vals, err = mt.Invoke("inspect", 1, v, indent)
-			if err == nil && len(vals) > 0 {
-				return vals[0].AsString()
-			}
 
-			vals, err = val.Invoke("inspect", 1, v, indent)
-			if err != nil || len(vals) == 0 {
-				return fmt.Sprintf("%T(%+v)", iface, iface)
-			}
-
-			return vals[0].AsString()
-		}
+		return fmt.Sprintf("%T(%+v)", iface, iface)
 	case lua.LTTable:
+		if en, ok := v.owner.enumFor(v.lval); ok {
+			return en.inspect()
+		}
 		vals, err := v.Invoke("inspect", 1, v)
 		if err != nil || len(vals) == 0 {
 			buf := new(bytes.Buffer)
@@ -132,6 +114,40 @@ func (v *Value) Inspect(indent string) string {
 	return "nil"
 }
 
+// inspectUserData produces the custom inspected form of a userdata value,
+// from Inspecter, fmt.Stringer or an inspect method, returning false if the
+// value has no custom form.
+func (v *Value) inspectUserData(indent string) (string, bool) {
+	iface := v.Interface()
+	switch it := iface.(type) {
+	case Inspecter:
+		return it.Inspect(indent), true
+	case fmt.Stringer:
+		return it.String(), true
+	}
+
+	ud := v.asUserData()
+	val := v.owner.ValueFor(ud.Metatable)
+
+	mt := v.owner.MetatableFor(ud.Value)
+	vals, err := mt.RawGet("ptr_methods").Invoke("inspect", 1, v, indent)
+	if err == nil && len(vals) > 0 {
+		return vals[0].AsString(), true
+	}
+
+	vals, err = mt.Invoke("inspect", 1, v, indent)
+	if err == nil && len(vals) > 0 {
+		return vals[0].AsString(), true
+	}
+
+	vals, err = val.Invoke("inspect", 1, v, indent)
+	if err != nil || len(vals) == 0 {
+		return "", false
+	}
+
+	return vals[0].AsString(), true
+}
+
 // AsString returns the LValue as a Go string
 func (v *Value) AsString() string {
 	return lua.LVAsString(v.lval)
@@ -488,7 +504,7 @@ func (v *Value) Invoke(key interface{}, retCount int, argList ...interface{}) ([
 
 // Call invokes the LuaValue as a function (if it is one) with similar behavior
 // to engine.Call. If you're looking to invoke a function on table, then see
-// Value.Invoke
+// Value.Invoke, and see Value.Bind to call it through a typed Go function.
 func (v *Value) Call(retCount int, argList ...interface{}) ([]*Value, error) {
 	if v.IsFunction() && v.owner != nil {
 		p := lua.P{
@@ -501,7 +517,9 @@ func (v *Value) Call(retCount int, argList ...interface{}) ([]*Value, error) {
 			args[i] = getLValue(v.owner, iface)
 		}
 
-		err := v.owner.state.CallByParam(p, args...)
+		err := v.owner.callLua(func() error {
+			return v.owner.state.CallByParam(p, args...)
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -516,3 +534,32 @@ func (v *Value) Call(retCount int, argList ...interface{}) ([]*Value, error) {
 
 	return make([]*Value, 0), nil
 }
+
+// Bind sets the Go function fnPtr points to so it calls the Lua function the
+// Value holds, converting arguments and results with reflection. For example:
+//
+//	var onDamage func(target *Mob, amount int) (bool, error)
+//	err := eng.GetGlobal("on_damage").Bind(&onDamage)
+//
+// Arguments are passed the way they are to Go functions called from Lua, and
+// results are converted to the function's result types. When the function's
+// last result is an error, errors raised by the script (or returned as an
+// extra "nil, message" result) are returned through it, other functions panic
+// with the error raised.
+func (v *Value) Bind(fnPtr interface{}) error {
+	if !v.IsFunction() || v.owner == nil {
+		return fmt.Errorf("cannot bind a %s value, expected a function", v.lval.Type())
+	}
+
+	ptr := reflect.ValueOf(fnPtr)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("%T is not a pointer to a function", fnPtr)
+	}
+
+	lookup := func() lua.LValue {
+		return v.lval
+	}
+	ptr.Elem().Set(v.owner.makeFunc(ptr.Elem().Type(), lookup, nil))
+
+	return nil
+}
@@ -12,6 +12,11 @@ import (
 	"github.com/bbuck/luna"
 )
 
+type bindMob struct {
+	Name      string
+	HitPoints int
+}
+
 var _ = Describe("LuaValue", func() {
 	var (
 		engine *luna.Engine
@@ -370,4 +375,77 @@ var _ = Describe("LuaValue", func() {
 			Ω(s[1]).Should(Equal(float64(1)))
 		})
 	})
+
+	Describe("Bind()", func() {
+		BeforeEach(func() {
+			Ω(engine.DoString(`
+				function on_damage(target, amount)
+					if amount < 0 then
+						return false, "negative damage"
+					end
+					target.hit_points = target.hit_points - amount
+					return target.hit_points <= 0
+				end
+
+				function describe(name, ...)
+					return name .. " x" .. select("#", ...), select("#", ...)
+				end
+
+				function explode()
+					error("boom")
+				end
+			`)).Should(Succeed())
+		})
+
+		It("calls the Lua function with converted arguments and results", func() {
+			var onDamage func(target *bindMob, amount int) (bool, error)
+			Ω(engine.GetGlobal("on_damage").Bind(&onDamage)).Should(Succeed())
+
+			mob := &bindMob{Name: "orc", HitPoints: 10}
+			dead, err := onDamage(mob, 4)
+			Ω(err).Should(BeNil())
+			Ω(dead).Should(BeFalse())
+			Ω(mob.HitPoints).Should(Equal(6))
+
+			dead, err = onDamage(mob, 6)
+			Ω(err).Should(BeNil())
+			Ω(dead).Should(BeTrue())
+		})
+
+		It("passes variadic arguments", func() {
+			var describe func(string, ...int) (string, int)
+			Ω(engine.GetGlobal("describe").Bind(&describe)).Should(Succeed())
+
+			name, count := describe("orc", 1, 2, 3)
+			Ω(name).Should(Equal("orc x3"))
+			Ω(count).Should(Equal(3))
+		})
+
+		It("returns script errors through the error result", func() {
+			var onDamage func(*bindMob, int) (bool, error)
+			Ω(engine.GetGlobal("on_damage").Bind(&onDamage)).Should(Succeed())
+
+			_, err := onDamage(&bindMob{}, -1)
+			Ω(err).Should(MatchError("negative damage"))
+
+			var explode func() error
+			Ω(engine.GetGlobal("explode").Bind(&explode)).Should(Succeed())
+			err = explode()
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring("boom"))
+		})
+
+		It("panics with script errors without an error result", func() {
+			var explode func()
+			Ω(engine.GetGlobal("explode").Bind(&explode)).Should(Succeed())
+			Ω(explode).Should(Panic())
+		})
+
+		It("fails for values that aren't functions or targets that aren't function pointers", func() {
+			var fn func()
+			Ω(engine.ValueFor(10).Bind(&fn)).ShouldNot(Succeed())
+			Ω(engine.GetGlobal("explode").Bind(fn)).ShouldNot(Succeed())
+			Ω(engine.GetGlobal("explode").Bind(&str)).ShouldNot(Succeed())
+		})
+	})
 })
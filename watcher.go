@@ -0,0 +1,200 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is the polling interval used by a ScriptWatcher when
+// none is specified.
+const DefaultWatchInterval = time.Second
+
+// ScriptWatcher polls a set of script files and directories for changes. It
+// relies only on file modification times and sizes so that it works the same
+// on every platform. Directories are watched recursively for Lua files, new
+// and deleted files are reported as changes as well.
+type ScriptWatcher struct {
+	Interval time.Duration
+	roots    map[string]bool
+	stamps   map[string]fileStamp
+	handlers []func([]string)
+	mutex    *sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// the information used to detect a change in a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewScriptWatcher creates a watcher that polls at the given interval once
+// started, an interval of 0 uses DefaultWatchInterval.
+func NewScriptWatcher(interval time.Duration) *ScriptWatcher {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	return &ScriptWatcher{
+		Interval: interval,
+		roots:    make(map[string]bool),
+		stamps:   make(map[string]fileStamp),
+		mutex:    new(sync.Mutex),
+	}
+}
+
+// Watch adds files or directories to the set being watched. The current state
+// of each path is recorded, so only changes made after this call are reported.
+//
+// Engine.ReloadFiles can only reload modules that require loaded from files on
+// disk, through package.path or SecureRequire. Modules loaded with
+// SecureRequireFS and scripts run with DoFile aren't reloaded, ReloadFiles
+// skips their changes. Pools following the watcher with ReloadOn rebuild their
+// engines instead, so they pick up every change.
+func (w *ScriptWatcher) Watch(paths ...string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(abs); err != nil {
+			return err
+		}
+		w.roots[abs] = true
+	}
+
+	for path, stamp := range w.scan() {
+		if _, ok := w.stamps[path]; !ok {
+			w.stamps[path] = stamp
+		}
+	}
+
+	return nil
+}
+
+// OnChange registers a handler that is called with the (absolute) paths of the
+// files that changed. Handlers are called from the goroutine that detected the
+// change, which is the watcher's own goroutine when started with Start.
+func (w *ScriptWatcher) OnChange(fn func(changed []string)) {
+	w.mutex.Lock()
+	w.handlers = append(w.handlers, fn)
+	w.mutex.Unlock()
+}
+
+// Poll checks every watched path for changes once, notifying the handlers if
+// anything changed, and returns the changed paths. This can be used in place of
+// Start by hosts that want changes applied from their own main loop.
+func (w *ScriptWatcher) Poll() []string {
+	w.mutex.Lock()
+	current := w.scan()
+
+	var changed []string
+	for path, stamp := range current {
+		if old, ok := w.stamps[path]; !ok || old != stamp {
+			changed = append(changed, path)
+		}
+	}
+	for path := range w.stamps {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	w.stamps = current
+	handlers := append([]func([]string){}, w.handlers...)
+	w.mutex.Unlock()
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	sort.Strings(changed)
+	for _, handler := range handlers {
+		handler(changed)
+	}
+
+	return changed
+}
+
+// Start begins polling on a new goroutine, it does nothing if the watcher is
+// already running.
+func (w *ScriptWatcher) Start() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stop != nil {
+		return
+	}
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+
+	go w.run(w.stop, w.done)
+}
+
+// Stop ends polling and waits for the polling goroutine to exit.
+func (w *ScriptWatcher) Stop() {
+	w.mutex.Lock()
+	stop, done := w.stop, w.done
+	w.stop, w.done = nil, nil
+	w.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// polling loop, started by Start
+func (w *ScriptWatcher) run(stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.Poll()
+		}
+	}
+}
+
+// collect the current stamps for every watched file, the mutex must be held
+// when calling this.
+func (w *ScriptWatcher) scan() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for root := range w.roots {
+		info, err := os.Stat(root)
+		if err != nil {
+			continue
+		}
+
+		if !info.IsDir() {
+			stamps[root] = fileStamp{info.ModTime(), info.Size()}
+
+			continue
+		}
+
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(path) != ".lua" {
+				return nil
+			}
+			stamps[path] = fileStamp{info.ModTime(), info.Size()}
+
+			return nil
+		})
+	}
+
+	return stamps
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2020 Brandon Buck
+
+package luna_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/bbuck/luna"
+)
+
+var _ = Describe("ScriptWatcher", func() {
+	var (
+		dir     string
+		modPath string
+		watcher *ScriptWatcher
+		engine  *Engine
+	)
+
+	writeModule := func(value string) {
+		src := `
+			local M = { value = ` + value + ` }
+
+			function M.on_reload(new, old)
+				new.previous = old.value
+			end
+
+			return M
+		`
+		err := ioutil.WriteFile(modPath, []byte(src), 0644)
+		Ω(err).Should(BeNil())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "luna-watcher")
+		Ω(err).Should(BeNil())
+		modPath = filepath.Join(dir, "counter.lua")
+		writeModule("1")
+
+		watcher = NewScriptWatcher(0)
+		Ω(watcher.Watch(dir)).Should(Succeed())
+
+		engine = NewEngine()
+		engine.SecureRequire([]string{filepath.Join(dir, "?.lua")})
+		Ω(engine.DoString(`counter = require("counter")`)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		engine.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("reports nothing when nothing changed", func() {
+		Ω(watcher.Poll()).Should(BeEmpty())
+	})
+
+	It("reports changed files", func() {
+		writeModule("100")
+		changed := watcher.Poll()
+		Ω(changed).Should(HaveLen(1))
+		Ω(filepath.Base(changed[0])).Should(Equal("counter.lua"))
+	})
+
+	It("reloads modules in standalone engines", func() {
+		writeModule("100")
+		Ω(engine.ReloadFiles(watcher.Poll())).Should(Succeed())
+		Ω(engine.DoString(`counter = require("counter")`)).Should(Succeed())
+
+		counter := engine.GetGlobal("counter")
+		Ω(counter.RawGet("value").AsNumber()).Should(Equal(float64(100)))
+		Ω(counter.RawGet("previous").AsNumber()).Should(Equal(float64(1)))
+	})
+
+	It("reloads modules found on package.path", func() {
+		eng := NewEngine()
+		defer eng.Close()
+		eng.GetGlobal("package").RawSet("path", filepath.Join(dir, "?.lua"))
+		Ω(eng.DoString(`counter = require("counter")`)).Should(Succeed())
+
+		writeModule("100")
+		Ω(eng.ReloadFiles(watcher.Poll())).Should(Succeed())
+		Ω(eng.DoString(`counter = require("counter")`)).Should(Succeed())
+		Ω(eng.GetGlobal("counter").RawGet("value").AsNumber()).Should(Equal(float64(100)))
+	})
+
+	It("reloads modules found on package.path by engines with the core libraries", func() {
+		eng := NewEngineWithOptions(EngineOptions{
+			OpenLibs:     true,
+			FieldCasing:  SnakeCase,
+			MethodCasing: SnakeCase,
+		})
+		defer eng.Close()
+		eng.GetGlobal("package").RawSet("path", filepath.Join(dir, "?.lua"))
+		Ω(eng.DoString(`counter = require("counter")`)).Should(Succeed())
+
+		writeModule("100")
+		Ω(eng.ReloadFiles(watcher.Poll())).Should(Succeed())
+		Ω(eng.DoString(`counter = require("counter")`)).Should(Succeed())
+		Ω(eng.GetGlobal("counter").RawGet("value").AsNumber()).Should(Equal(float64(100)))
+	})
+
+	It("reloads modules in the order they were loaded", func() {
+		for _, name := range []string{"first", "second", "third"} {
+			src := `loads = (loads or "") .. "` + name + ` "`
+			Ω(ioutil.WriteFile(filepath.Join(dir, name+".lua"), []byte(src), 0644)).Should(Succeed())
+		}
+		Ω(engine.DoString(`require("third"); require("first"); require("second")`)).Should(Succeed())
+
+		for _, name := range []string{"first", "second", "third"} {
+			future := time.Now().Add(time.Minute)
+			Ω(os.Chtimes(filepath.Join(dir, name+".lua"), future, future)).Should(Succeed())
+		}
+		Ω(engine.ReloadFiles(watcher.Poll())).Should(Succeed())
+		Ω(engine.GetGlobal("loads").AsString()).Should(Equal("third first second third first second "))
+	})
+
+	It("skips changed files no module was loaded from", func() {
+		other := filepath.Join(dir, "other.lua")
+		Ω(ioutil.WriteFile(other, []byte(`return 1`), 0644)).Should(Succeed())
+		writeModule("100")
+
+		Ω(engine.ReloadFiles(watcher.Poll())).Should(Succeed())
+
+		Ω(engine.DoString(`counter = require("counter")`)).Should(Succeed())
+		Ω(engine.GetGlobal("counter").RawGet("value").AsNumber()).Should(Equal(float64(100)))
+	})
+
+	It("marks pools stale", func() {
+		builds := 0
+		pool := NewEnginePool(1, func(*Engine) {
+			builds++
+		})
+		defer pool.Shutdown()
+		pool.ReloadOn(watcher)
+
+		writeModule("100")
+		watcher.Poll()
+
+		pe, err := pool.Get()
+		Ω(err).Should(BeNil())
+		pe.Release()
+		Ω(builds).Should(Equal(2))
+	})
+})